@@ -2,9 +2,15 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/wcatz/dashboard-generator/internal/config"
@@ -23,8 +29,32 @@ var (
 	grafanaPass   string
 	grafanaToken  string
 	dryRun        bool
+	clean         bool
+	quiet         bool
+	jsonSummary   bool
 	verbose       bool
 	servePort     int
+	diffFormat    string
+	statsSlowest  int
+	failFast      bool
+	backupDir     string
+	strictYAML    bool
+	envName       string
+	listJSON      bool
+	sets          []string
+	trace         bool
+	configCheck   bool
+	dsProvision   bool
+	grafInsecure  bool
+	promInsecure  bool
+	caFile        string
+	fmtWrite      bool
+	fmtSort       bool
+	outputFormat  string
+	cmBundle      bool
+	pushDiff      bool
+	grafanaFolder string
+	watch         bool
 )
 
 func main() {
@@ -38,36 +68,62 @@ func main() {
 		Short: "generate Grafana dashboard JSON from YAML config",
 		RunE:  runGenerate,
 	}
-	genCmd.Flags().StringVar(&cfgFile, "config", "", "path to YAML config file (required)")
+	genCmd.Flags().StringVar(&cfgFile, "config", "", "path to YAML config file (default: search upward for dashboard-generator.yaml)")
+	genCmd.Flags().StringArrayVar(&sets, "set", nil, "override a constant or selector (key=value, repeatable)")
+	genCmd.Flags().BoolVar(&dsProvision, "datasource-provisioning", false, "also write a Grafana datasource provisioning file (datasources.yaml) to the output directory")
+	genCmd.Flags().BoolVar(&promInsecure, "prometheus-insecure", false, "skip TLS certificate verification for discovery requests")
+	genCmd.Flags().StringVar(&caFile, "ca-file", "", "PEM CA bundle trusted for Grafana and Prometheus TLS, in addition to system roots")
+	genCmd.Flags().BoolVar(&trace, "trace", false, "log each discovery API request with its duration to stderr")
+	genCmd.Flags().StringVar(&envName, "env", "", "environment selecting datasource urls entries (e.g. prod, staging)")
+	genCmd.Flags().BoolVar(&strictYAML, "strict-yaml", false, "reject unknown config keys instead of ignoring them")
 	genCmd.Flags().StringVar(&profile, "profile", "", "generate only dashboards in named profile")
 	genCmd.Flags().StringVar(&outputDir, "output-dir", "", "override output directory")
 	genCmd.Flags().BoolVar(&dryRun, "dry-run", false, "generate to memory only")
+	genCmd.Flags().BoolVar(&clean, "clean", false, "remove previously generated files for dashboards no longer in the config")
 	genCmd.Flags().BoolVar(&verbose, "verbose", false, "print panel details")
-	genCmd.MarkFlagRequired("config")
+	genCmd.Flags().BoolVar(&failFast, "fail-fast", false, "stop at the first dashboard build error instead of reporting all")
+	genCmd.Flags().BoolVar(&quiet, "quiet", false, "suppress per-file output (errors and warnings still print)")
+	genCmd.Flags().BoolVar(&jsonSummary, "json-summary", false, "print only a JSON totals object (dashboards, panels, bytes, per-file sizes)")
+	genCmd.Flags().StringVar(&outputFormat, "output-format", "json", "output format: json or configmap (Kubernetes ConfigMap YAML for the Grafana sidecar)")
+	genCmd.Flags().BoolVar(&cmBundle, "configmap-bundle", false, "with --output-format configmap, write all ConfigMaps to one multi-document "+generator.ConfigMapBundleFile)
+	genCmd.Flags().BoolVar(&watch, "watch", false, "keep running and regenerate whenever the config or one of its includes changes")
 
 	discoverCmd := &cobra.Command{
 		Use:   "discover",
 		Short: "query Prometheus and print suggested YAML config",
 		RunE:  runDiscover,
 	}
-	discoverCmd.Flags().StringVar(&cfgFile, "config", "", "path to YAML config file (required)")
+	discoverCmd.Flags().StringVar(&cfgFile, "config", "", "path to YAML config file (default: search upward for dashboard-generator.yaml)")
+	discoverCmd.Flags().BoolVar(&promInsecure, "prometheus-insecure", false, "skip TLS certificate verification for discovery requests")
+	discoverCmd.Flags().StringVar(&caFile, "ca-file", "", "PEM CA bundle trusted for Grafana and Prometheus TLS, in addition to system roots")
+	discoverCmd.Flags().BoolVar(&trace, "trace", false, "log each discovery API request with its duration to stderr")
+	discoverCmd.Flags().StringVar(&envName, "env", "", "environment selecting datasource urls entries (e.g. prod, staging)")
+	discoverCmd.Flags().BoolVar(&strictYAML, "strict-yaml", false, "reject unknown config keys instead of ignoring them")
 	discoverCmd.Flags().StringVar(&prometheusURL, "prometheus-url", "", "Prometheus URL for discovery")
-	discoverCmd.MarkFlagRequired("config")
 
 	pushCmd := &cobra.Command{
 		Use:   "push",
 		Short: "generate and push dashboards to Grafana API",
 		RunE:  runPush,
 	}
-	pushCmd.Flags().StringVar(&cfgFile, "config", "", "path to YAML config file (required)")
+	pushCmd.Flags().StringVar(&cfgFile, "config", "", "path to YAML config file (default: search upward for dashboard-generator.yaml)")
+	pushCmd.Flags().StringArrayVar(&sets, "set", nil, "override a constant or selector (key=value, repeatable)")
+	pushCmd.Flags().BoolVar(&promInsecure, "prometheus-insecure", false, "skip TLS certificate verification for discovery requests")
+	pushCmd.Flags().StringVar(&caFile, "ca-file", "", "PEM CA bundle trusted for Grafana and Prometheus TLS, in addition to system roots")
+	pushCmd.Flags().BoolVar(&trace, "trace", false, "log each discovery API request with its duration to stderr")
+	pushCmd.Flags().StringVar(&envName, "env", "", "environment selecting datasource urls entries (e.g. prod, staging)")
+	pushCmd.Flags().BoolVar(&strictYAML, "strict-yaml", false, "reject unknown config keys instead of ignoring them")
 	pushCmd.Flags().StringVar(&profile, "profile", "", "generate only dashboards in named profile")
 	pushCmd.Flags().StringVar(&outputDir, "output-dir", "", "override output directory")
 	pushCmd.Flags().StringVar(&grafanaURL, "grafana-url", "", "Grafana URL (required)")
 	pushCmd.Flags().StringVar(&grafanaUser, "grafana-user", "", "Grafana basic auth user")
 	pushCmd.Flags().StringVar(&grafanaPass, "grafana-pass", "", "Grafana basic auth password")
+	pushCmd.Flags().BoolVar(&grafInsecure, "grafana-insecure", false, "skip TLS certificate verification for Grafana API requests")
 	pushCmd.Flags().StringVar(&grafanaToken, "grafana-token", "", "Grafana API token")
 	pushCmd.Flags().BoolVar(&verbose, "verbose", false, "print panel details")
-	pushCmd.MarkFlagRequired("config")
+	pushCmd.Flags().StringVar(&backupDir, "backup-dir", "", "save each dashboard's current Grafana JSON here before overwriting it")
+	pushCmd.Flags().StringVar(&grafanaFolder, "grafana-folder", "", "push every dashboard into this Grafana folder (title; created if missing), overriding folder config")
+	pushCmd.Flags().BoolVar(&pushDiff, "diff", false, "compare each dashboard with its live copy and skip the push when nothing changed")
 	pushCmd.MarkFlagRequired("grafana-url")
 
 	serveCmd := &cobra.Command{
@@ -75,27 +131,151 @@ func main() {
 		Short: "start the web UI server",
 		RunE:  runServe,
 	}
-	serveCmd.Flags().StringVar(&cfgFile, "config", "", "path to YAML config file (required)")
+	serveCmd.Flags().StringVar(&cfgFile, "config", "", "path to YAML config file (default: search upward for dashboard-generator.yaml)")
 	serveCmd.Flags().IntVar(&servePort, "port", 8080, "HTTP server port")
 	serveCmd.Flags().StringVar(&grafanaURL, "grafana-url", "", "Grafana URL for push (or set GRAFANA_URL env)")
-	serveCmd.MarkFlagRequired("config")
+	serveCmd.Flags().StringVar(&grafanaToken, "grafana-token", "", "Grafana API token for push and render (or set GRAFANA_TOKEN env)")
+	serveCmd.Flags().StringVar(&grafanaUser, "grafana-user", "", "Grafana basic auth user (or set GRAFANA_USER env)")
+	serveCmd.Flags().StringVar(&grafanaPass, "grafana-pass", "", "Grafana basic auth password (or set GRAFANA_PASS env)")
+	serveCmd.Flags().BoolVar(&grafInsecure, "grafana-insecure", false, "skip TLS certificate verification for Grafana API requests")
+	serveCmd.Flags().StringVar(&caFile, "ca-file", "", "PEM CA bundle trusted for Grafana TLS, in addition to system roots")
+
+	diffCmd := &cobra.Command{
+		Use:   "diff",
+		Short: "compare generated dashboards against the live copies in Grafana",
+		RunE:  runDiff,
+	}
+	diffCmd.Flags().StringVar(&cfgFile, "config", "", "path to YAML config file (default: search upward for dashboard-generator.yaml)")
+	diffCmd.Flags().StringArrayVar(&sets, "set", nil, "override a constant or selector (key=value, repeatable)")
+	diffCmd.Flags().BoolVar(&promInsecure, "prometheus-insecure", false, "skip TLS certificate verification for discovery requests")
+	diffCmd.Flags().StringVar(&caFile, "ca-file", "", "PEM CA bundle trusted for Grafana and Prometheus TLS, in addition to system roots")
+	diffCmd.Flags().BoolVar(&trace, "trace", false, "log each discovery API request with its duration to stderr")
+	diffCmd.Flags().StringVar(&envName, "env", "", "environment selecting datasource urls entries (e.g. prod, staging)")
+	diffCmd.Flags().BoolVar(&strictYAML, "strict-yaml", false, "reject unknown config keys instead of ignoring them")
+	diffCmd.Flags().StringVar(&profile, "profile", "", "diff only dashboards in named profile")
+	diffCmd.Flags().StringVar(&grafanaURL, "grafana-url", "", "Grafana URL (required)")
+	diffCmd.Flags().StringVar(&grafanaUser, "grafana-user", "", "Grafana basic auth user")
+	diffCmd.Flags().StringVar(&grafanaPass, "grafana-pass", "", "Grafana basic auth password")
+	diffCmd.Flags().BoolVar(&grafInsecure, "grafana-insecure", false, "skip TLS certificate verification for Grafana API requests")
+	diffCmd.Flags().StringVar(&grafanaToken, "grafana-token", "", "Grafana API token")
+	diffCmd.Flags().StringVar(&diffFormat, "format", "unified", "output format: unified, json, or summary")
+	diffCmd.MarkFlagRequired("grafana-url")
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "build all dashboards without writing and report build time and size",
+		RunE:  runStats,
+	}
+	statsCmd.Flags().StringVar(&cfgFile, "config", "", "path to YAML config file (default: search upward for dashboard-generator.yaml)")
+	statsCmd.Flags().StringArrayVar(&sets, "set", nil, "override a constant or selector (key=value, repeatable)")
+	statsCmd.Flags().BoolVar(&promInsecure, "prometheus-insecure", false, "skip TLS certificate verification for discovery requests")
+	statsCmd.Flags().StringVar(&caFile, "ca-file", "", "PEM CA bundle trusted for Grafana and Prometheus TLS, in addition to system roots")
+	statsCmd.Flags().BoolVar(&trace, "trace", false, "log each discovery API request with its duration to stderr")
+	statsCmd.Flags().StringVar(&envName, "env", "", "environment selecting datasource urls entries (e.g. prod, staging)")
+	statsCmd.Flags().BoolVar(&strictYAML, "strict-yaml", false, "reject unknown config keys instead of ignoring them")
+	statsCmd.Flags().StringVar(&profile, "profile", "", "measure only dashboards in named profile")
+	statsCmd.Flags().IntVar(&statsSlowest, "slowest", 5, "number of slowest dashboards to list")
+
+	listCmd := &cobra.Command{
+		Use:       "list dashboards|profiles|datasources",
+		Short:     "print dashboard, profile or datasource names from the config",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: generator.ListKinds,
+		RunE:      runList,
+	}
+	listCmd.Flags().StringVar(&cfgFile, "config", "", "path to YAML config file (default: search upward for dashboard-generator.yaml)")
+	listCmd.Flags().StringVar(&profile, "profile", "", "list only dashboards in named profile")
+	listCmd.Flags().BoolVar(&listJSON, "json", false, "print a JSON array instead of one name per line")
+
+	diffConfigCmd := &cobra.Command{
+		Use:   "diff-config OLD NEW",
+		Short: "report added, removed and changed entries between two config files",
+		Args:  cobra.ExactArgs(2),
+		RunE:  runDiffConfig,
+	}
+	diffConfigCmd.Flags().BoolVar(&strictYAML, "strict-yaml", false, "reject unknown config keys instead of ignoring them")
+
+	fmtCmd := &cobra.Command{
+		Use:   "fmt FILE",
+		Short: "rewrite a config file with canonical YAML formatting, keeping comments",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runFmt,
+	}
+	fmtCmd.Flags().BoolVar(&fmtWrite, "write", false, "rewrite the file in place instead of printing to stdout")
+	fmtCmd.Flags().BoolVar(&fmtSort, "sort-keys", false, "sort datasources and variables entries by name")
+
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "check config references (datasources, variables, colors, thresholds) without generating",
+		Args:  cobra.NoArgs,
+		RunE:  runValidate,
+	}
+	validateCmd.Flags().StringVar(&cfgFile, "config", "", "path to YAML config file (default: search upward for dashboard-generator.yaml)")
+	validateCmd.Flags().BoolVar(&strictYAML, "strict-yaml", false, "reject unknown config keys instead of ignoring them")
+	validateCmd.Flags().StringVar(&envName, "env", "", "environment selecting datasource urls entries (e.g. prod, staging)")
+	validateCmd.Flags().BoolVar(&configCheck, "config-check", false, "also build every dashboard and print one OK/ERROR line per dashboard instead of grouped problems")
+
+	initCmd := &cobra.Command{
+		Use:   "init",
+		Short: "print a commented starter config to stdout",
+		Args:  cobra.NoArgs,
+		RunE:  runInit,
+	}
 
-	rootCmd.AddCommand(genCmd, discoverCmd, pushCmd, serveCmd)
+	rootCmd.AddCommand(genCmd, discoverCmd, pushCmd, diffCmd, statsCmd, listCmd, diffConfigCmd, fmtCmd, validateCmd, serveCmd, initCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
 
+// resolveConfigPath fills in cfgFile from an upward search of the working
+// directory when --config was not given.
+func resolveConfigPath() error {
+	if cfgFile != "" {
+		return nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	found, err := config.FindConfig(wd)
+	if err != nil {
+		return err
+	}
+	cfgFile = found
+	return nil
+}
+
 func loadConfig() (*config.Config, error) {
+	if err := resolveConfigPath(); err != nil {
+		return nil, err
+	}
 	cliArgs := make(map[string]string)
 	if prometheusURL != "" {
 		cliArgs["prometheus_url"] = prometheusURL
 	}
-	return config.Load(cfgFile, cliArgs)
+	if envName != "" {
+		cliArgs["env"] = envName
+	}
+	load := config.Load
+	if strictYAML {
+		load = config.LoadStrict
+	}
+	cfg, err := load(cfgFile, cliArgs)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplySets(sets); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
 
 func runGenerate(cmd *cobra.Command, args []string) error {
+	if watch {
+		return watchGenerate(cmd)
+	}
 	cfg, err := loadConfig()
 	if err != nil {
 		return err
@@ -103,6 +283,71 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 	return generateDashboards(cfg, false)
 }
 
+// watchGenerate is generate --watch: it regenerates whenever the config or
+// one of its includes changes, printing which output files changed size.
+// Errors, including config parse errors, are printed and watching goes on
+// until interrupted.
+func watchGenerate(cmd *cobra.Command) error {
+	if err := resolveConfigPath(); err != nil {
+		return err
+	}
+	run := func() error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		outDir, err := resolveOutputDir(cfg)
+		if err != nil {
+			return err
+		}
+		before := generator.FileSizes(outDir)
+		if err := generateDashboards(cfg, false); err != nil {
+			return err
+		}
+		if !dryRun && generator.WriteSizeChanges(os.Stdout, before, generator.FileSizes(outDir)) == 0 {
+			fmt.Println("  no output files changed size")
+		}
+		return nil
+	}
+	w := generator.NewWatcher(func() []string { return config.ConfigFiles(cfgFile) }, func() error {
+		fmt.Printf("[%s] generating from %s\n", time.Now().Format("15:04:05"), cfgFile)
+		return run()
+	})
+
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		close(stop)
+	}()
+	fmt.Fprintf(os.Stderr, "watching %s (ctrl-c to stop)\n", cfgFile)
+	w.Watch(stop)
+	return nil
+}
+
+// runConfigCheck is validate --config-check: a terse, hook-friendly report
+// with one line per dashboard, failing it when its references are broken or
+// it does not build, and exiting non-zero on any failure.
+func runConfigCheck(cmd *cobra.Command, cfg *config.Config, problems []config.Problem) error {
+	dashboards, order, err := selectDashboards(cfg)
+	if err != nil {
+		return err
+	}
+	builder := generator.NewDashboardBuilder(cfg, generator.NewPanelFactory(cfg, generator.NewIDGenerator()), generator.NewLayoutEngineWidth(cfg.GetGenerator().GridColumns()))
+	navLinks := builder.BuildNavigationLinks(dashboards, order)
+	discoverySections, err := buildDiscoverySections(cfg)
+	if err != nil {
+		return err
+	}
+	results := generator.MergeProblems(builder.CheckDashboards(dashboards, order, navLinks, discoverySections), problems)
+	if failed := generator.WriteConfigCheck(os.Stdout, results, generator.ColorEnabled()); failed > 0 {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d of %d dashboards failed", failed, len(results))
+	}
+	return nil
+}
+
 func runDiscover(cmd *cobra.Command, args []string) error {
 	cfg, err := loadConfig()
 	if err != nil {
@@ -115,21 +360,42 @@ func runDiscover(cmd *cobra.Command, args []string) error {
 		for name := range cfg.Datasources {
 			sources = append(sources, name)
 		}
+		sort.Strings(sources)
 	}
 	if len(sources) == 0 {
 		return fmt.Errorf("no datasources configured for discovery")
 	}
 
-	disc := generator.NewMetricDiscovery(cfg)
+	disc := newDiscovery(cfg)
 	return disc.PrintDiscovery(sources, discoveryCfg.IncludePatterns, discoveryCfg.ExcludePatterns)
 }
 
+func runInit(cmd *cobra.Command, args []string) error {
+	_, err := os.Stdout.Write(config.StarterConfig)
+	return err
+}
+
 func runServe(cmd *cobra.Command, args []string) error {
-	gURL := grafanaURL
-	if gURL == "" {
-		gURL = os.Getenv("GRAFANA_URL")
+	if err := resolveConfigPath(); err != nil {
+		return err
+	}
+	if grafanaURL == "" {
+		grafanaURL = os.Getenv("GRAFANA_URL")
+	}
+	if grafanaToken == "" {
+		grafanaToken = os.Getenv("GRAFANA_TOKEN")
 	}
-	srv, err := server.New(web.EmbeddedFS, cfgFile, gURL)
+	if grafanaUser == "" {
+		grafanaUser = os.Getenv("GRAFANA_USER")
+	}
+	if grafanaPass == "" {
+		grafanaPass = os.Getenv("GRAFANA_PASS")
+	}
+	graf, err := grafanaOptions()
+	if err != nil {
+		return err
+	}
+	srv, err := server.New(web.EmbeddedFS, cfgFile, graf)
 	if err != nil {
 		return err
 	}
@@ -145,138 +411,502 @@ func runPush(cmd *cobra.Command, args []string) error {
 	return generateDashboards(cfg, true)
 }
 
-func generateDashboards(cfg *config.Config, push bool) error {
-	gen := cfg.GetGenerator()
+func runDiff(cmd *cobra.Command, args []string) error {
+	if err := generator.ValidDiffFormat(diffFormat); err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	dashboards, order, err := selectDashboards(cfg)
+	if err != nil {
+		return err
+	}
+
+	idGen := generator.NewIDGenerator()
+	panelFactory := generator.NewPanelFactory(cfg, idGen)
+	layoutEngine := generator.NewLayoutEngineWidth(cfg.GetGenerator().GridColumns())
+	builder := generator.NewDashboardBuilder(cfg, panelFactory, layoutEngine)
+	navLinks := builder.BuildNavigationLinks(dashboards, order)
+
+	discoverySections, err := buildDiscoverySections(cfg)
+	if err != nil {
+		return err
+	}
+	graf, err := grafanaOptions()
+	if err != nil {
+		return err
+	}
+
+	var diffs []generator.DashboardDiff
+	for _, name := range order {
+		dashboard, err := builder.Build(dashboards[name], navLinks, discoverySections)
+		if err != nil {
+			return fmt.Errorf("building dashboard '%s': %w", name, err)
+		}
+		uid, _ := dashboard["uid"].(string)
+		live, _, err := generator.FetchFromGrafana(uid, graf)
+		if err != nil {
+			return fmt.Errorf("fetching '%s': %w", uid, err)
+		}
+		d, err := generator.DiffDashboard(dashboard, live)
+		if err != nil {
+			return fmt.Errorf("diffing '%s': %w", uid, err)
+		}
+		diffs = append(diffs, d)
+	}
+	return generator.WriteDiff(os.Stdout, diffs, diffFormat)
+}
+
+func runStats(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	dashboards, order, err := selectDashboards(cfg)
+	if err != nil {
+		return err
+	}
+
+	idGen := generator.NewIDGenerator()
+	panelFactory := generator.NewPanelFactory(cfg, idGen)
+	layoutEngine := generator.NewLayoutEngineWidth(cfg.GetGenerator().GridColumns())
+	builder := generator.NewDashboardBuilder(cfg, panelFactory, layoutEngine)
+	navLinks := builder.BuildNavigationLinks(dashboards, order)
+
+	start := time.Now()
+	discoverySections, err := buildDiscoverySections(cfg)
+	if err != nil {
+		return err
+	}
+	discoveryTime := time.Since(start)
+
+	stats, err := builder.BuildStats(dashboards, order, navLinks, discoverySections)
+	if err != nil {
+		return err
+	}
+	fmt.Println("grafana dashboard generator stats:")
+	if len(discoverySections) > 0 {
+		fmt.Printf("  discovery: %d sections in %s\n", len(discoverySections), discoveryTime.Round(time.Millisecond))
+	}
+	generator.WriteStats(os.Stdout, stats, statsSlowest)
+	return nil
+}
+
+// grafanaOptions builds the Grafana connection for one run from the
+// --grafana-* and --ca-file flags; every request of the run shares its client.
+func grafanaOptions() (generator.GrafanaOptions, error) {
+	return generator.NewGrafanaOptions(grafanaURL, grafanaUser, grafanaPass, grafanaToken,
+		generator.TLSOptions{Insecure: grafInsecure, CAFile: caFile})
+}
+
+// newDiscovery creates a MetricDiscovery with the TLS flags applied, tracing
+// requests to stderr when --trace is set.
+func newDiscovery(cfg *config.Config) *generator.MetricDiscovery {
+	disc := generator.NewMetricDiscovery(cfg)
+	disc.TLS = generator.TLSOptions{Insecure: promInsecure, CAFile: caFile}
+	if trace {
+		disc.Trace = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+	return disc
+}
+
+func runList(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	entries, err := generator.ListConfig(cfg, args[0], profile)
+	if err != nil {
+		return err
+	}
+	return generator.WriteList(os.Stdout, entries, listJSON)
+}
+
+func runDiffConfig(cmd *cobra.Command, args []string) error {
+	load := config.Load
+	if strictYAML {
+		load = config.LoadStrict
+	}
+	oldCfg, err := load(args[0], map[string]string{})
+	if err != nil {
+		return err
+	}
+	newCfg, err := load(args[1], map[string]string{})
+	if err != nil {
+		return err
+	}
+	changes, err := generator.DiffConfigs(oldCfg, newCfg)
+	if err != nil {
+		return err
+	}
+	return generator.WriteConfigDiff(os.Stdout, changes)
+}
+
+func runFmt(cmd *cobra.Command, args []string) error {
+	out, err := config.NewYAMLEditor(args[0]).Format(fmtSort, fmtWrite)
+	if err != nil {
+		return err
+	}
+	if !fmtWrite {
+		_, err = os.Stdout.Write(out)
+	}
+	return err
+}
 
-	// determine output directory
+// runValidate prints config reference problems, panel overlaps and unknown
+// units grouped by dashboard and fails when any has error severity. With --config-check it prints the
+// one-line-per-dashboard report instead.
+func runValidate(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	problems := cfg.Validate()
+	// dashboards that fail to resolve are already reported by Validate
+	if dashboards, order, err := selectDashboards(cfg); err == nil {
+		builder := generator.NewDashboardBuilder(cfg, generator.NewPanelFactory(cfg, generator.NewIDGenerator()), generator.NewLayoutEngineWidth(cfg.GetGenerator().GridColumns()))
+		problems = append(problems, builder.OverlapProblems(dashboards, order)...)
+		problems = append(problems, generator.UnitProblems(dashboards, order)...)
+		sort.SliceStable(problems, func(i, j int) bool { return problems[i].Dashboard < problems[j].Dashboard })
+	}
+	if configCheck {
+		return runConfigCheck(cmd, cfg, problems)
+	}
+	if errs := generator.WriteProblems(os.Stdout, problems, generator.ColorEnabled()); errs > 0 {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("%d error(s) in %s", errs, cfgFile)
+	}
+	if len(problems) == 0 {
+		fmt.Printf("%s: ok\n", cfgFile)
+	}
+	return nil
+}
+
+// resolveOutputDir returns the absolute output directory: --output-dir, else
+// generator.output_dir, relative to the config file's directory.
+func resolveOutputDir(cfg *config.Config) (string, error) {
 	outDir := outputDir
 	if outDir == "" {
-		outDir = gen.OutputDir
+		outDir = cfg.GetGenerator().OutputDir
 	}
 	if outDir == "" {
 		outDir = "."
 	}
 	if !filepath.IsAbs(outDir) {
-		configDir := filepath.Dir(cfgFile)
-		absConfig, err := filepath.Abs(configDir)
+		absConfig, err := filepath.Abs(filepath.Dir(cfgFile))
 		if err != nil {
-			return err
+			return "", err
 		}
 		outDir = filepath.Join(absConfig, outDir)
 	}
-	if !dryRun {
-		if err := os.MkdirAll(outDir, 0755); err != nil {
-			return err
-		}
+	return outDir, nil
+}
+
+func generateDashboards(cfg *config.Config, push bool) error {
+	gen := cfg.GetGenerator()
+	if outputFormat != "json" && outputFormat != "configmap" {
+		return fmt.Errorf("unknown --output-format '%s' (want json or configmap)", outputFormat)
 	}
 
-	// get dashboards
-	dashboards, err := cfg.GetDashboards(profile)
+	outDir, err := resolveOutputDir(cfg)
 	if err != nil {
 		return err
 	}
-	if len(dashboards) == 0 {
-		return fmt.Errorf("no dashboards defined in config")
-	}
-
-	// get dashboard order
-	order, err := cfg.GetDashboardOrder(profile)
+	fileMode, dirMode, err := gen.Modes()
 	if err != nil {
 		return err
 	}
-	// ensure order only includes dashboards that exist
-	var filteredOrder []string
-	for _, name := range order {
-		if _, ok := dashboards[name]; ok {
-			filteredOrder = append(filteredOrder, name)
+	if !dryRun {
+		if err := os.MkdirAll(outDir, dirMode); err != nil {
+			return err
 		}
 	}
-	// add any dashboards not in the order list
-	orderSet := make(map[string]bool)
-	for _, name := range filteredOrder {
-		orderSet[name] = true
-	}
-	var remaining []string
-	for name := range dashboards {
-		if !orderSet[name] {
-			remaining = append(remaining, name)
-		}
+
+	dashboards, filteredOrder, err := selectDashboards(cfg)
+	if err != nil {
+		return err
 	}
-	sort.Strings(remaining)
-	filteredOrder = append(filteredOrder, remaining...)
 
 	// build components
 	idGen := generator.NewIDGenerator()
 	panelFactory := generator.NewPanelFactory(cfg, idGen)
-	layoutEngine := generator.NewLayoutEngine()
+	layoutEngine := generator.NewLayoutEngineWidth(cfg.GetGenerator().GridColumns())
 	builder := generator.NewDashboardBuilder(cfg, panelFactory, layoutEngine)
 
+	// the home dashboard leads the nav links but skips discovery sections
+	var homeCfg *config.DashboardConfig
+	navOrder := filteredOrder
+	if gen.HomeDashboard != nil {
+		if _, taken := dashboards[generator.HomeDashboardName]; taken {
+			return fmt.Errorf("generator.home_dashboard: a dashboard named '%s' already exists", generator.HomeDashboardName)
+		}
+		hc := generator.HomeDashboardConfig(*gen.HomeDashboard)
+		homeCfg = &hc
+		dashboards[generator.HomeDashboardName] = hc
+		navOrder = append([]string{generator.HomeDashboardName}, filteredOrder...)
+	}
+
 	// build navigation links
-	navLinks := builder.BuildNavigationLinks(dashboards, filteredOrder)
+	navLinks := builder.BuildNavigationLinks(dashboards, navOrder)
 
-	// auto-discovery sections if enabled
-	var discoverySections []config.SectionConfig
-	discoveryCfg := cfg.GetDiscovery()
-	if discoveryCfg.Enabled && len(discoveryCfg.Sources) > 0 {
-		disc := generator.NewMetricDiscovery(cfg)
-		discoverySections, err = disc.GenerateDiscoverySections(
-			discoveryCfg.Sources,
-			discoveryCfg.IncludePatterns,
-			discoveryCfg.ExcludePatterns,
-		)
-		if err != nil {
-			return fmt.Errorf("discovery: %w", err)
-		}
+	discoverySections, err := buildDiscoverySections(cfg)
+	if err != nil {
+		return err
+	}
+	// human-readable progress goes to out; --json-summary keeps stdout clean
+	var out io.Writer = os.Stdout
+	if quiet || jsonSummary {
+		out = io.Discard
 	}
 
 	// generate dashboards
-	totalSize := 0
-	totalPanels := 0
-	fmt.Println("grafana dashboard generator:")
+	var summary generator.GenerateSummary
+	var written []string
+	var bundle []generator.ConfigMapEntry
+	pushCounts := make(map[string]int)
+	var graf generator.GrafanaOptions
+	if push && grafanaURL != "" {
+		if graf, err = grafanaOptions(); err != nil {
+			return err
+		}
+	}
+	folders := generator.NewFolderResolver(graf)
+	fmt.Fprintln(out, "grafana dashboard generator:")
 
-	for _, name := range filteredOrder {
-		dbCfg := dashboards[name]
-		dashboard, err := builder.Build(dbCfg, navLinks, discoverySections)
+	// build everything before writing so a broken config leaves no partial output
+	built, err := builder.BuildAll(dashboards, filteredOrder, navLinks, discoverySections, failFast)
+	if err != nil {
+		return err
+	}
+	if homeCfg != nil {
+		home, err := builder.Build(*homeCfg, navLinks, nil)
 		if err != nil {
-			return fmt.Errorf("building dashboard '%s': %w", name, err)
+			return fmt.Errorf("building home dashboard: %w", err)
 		}
-
-		filename := dbCfg.Filename
-		if filename == "" {
-			filename = name + ".json"
+		built[generator.HomeDashboardName] = home
+		filteredOrder = navOrder
+	}
+	// datasource reachability gating, also before anything is written
+	if gen.RequireDatasources {
+		healthDisc := newDiscovery(cfg)
+		for _, name := range filteredOrder {
+			if err := healthDisc.RequireReachable(built[name]); err != nil {
+				return fmt.Errorf("dashboard '%s': %w", name, err)
+			}
 		}
-		fpath := filepath.Join(outDir, filename)
+	}
 
-		size, err := generator.WriteDashboard(dashboard, fpath, dryRun)
-		if err != nil {
-			return err
+	for _, name := range filteredOrder {
+		dbCfg := dashboards[name]
+		dashboard := built[name]
+
+		filename := dashboardFilename(name, dbCfg)
+		switch {
+		case outputFormat == "configmap" && cmBundle:
+			bundle = append(bundle, generator.ConfigMapEntry{Key: filename, Dashboard: dashboard})
+		case outputFormat == "configmap":
+			entry := generator.ConfigMapEntry{Key: filename, Dashboard: dashboard}
+			yamlName := outputFilename(name, dbCfg)
+			sizes, err := generator.WriteConfigMaps([]generator.ConfigMapEntry{entry}, filepath.Join(outDir, yamlName), dryRun, out, fileMode)
+			if err != nil {
+				return err
+			}
+			summary.Add(yamlName, dashboard, sizes[0])
+			written = append(written, yamlName)
+		default:
+			size, err := generator.WriteDashboardTo(dashboard, filepath.Join(outDir, filename), dryRun, out, fileMode)
+			if err != nil {
+				return err
+			}
+			summary.Add(filename, dashboard, size)
+			written = append(written, filename)
 		}
-		totalSize += size
 
 		panels, _ := dashboard["panels"].([]interface{})
-		totalPanels += len(panels)
 
 		if verbose {
 			for _, p := range panels {
 				if panel, ok := p.(map[string]interface{}); ok {
 					ptype := panel["type"]
 					ptitle := panel["title"]
-					fmt.Printf("    [%v] %v\n", ptype, ptitle)
+					fmt.Fprintf(out, "    [%v] %v\n", ptype, ptitle)
 				}
 			}
 		}
 
 		if push && grafanaURL != "" {
-			if err := generator.PushToGrafana(dashboard, grafanaURL, grafanaUser, grafanaPass, grafanaToken); err != nil {
+			if backupDir != "" {
+				uid, _ := dashboard["uid"].(string)
+				saved, err := generator.BackupDashboard(uid, backupDir, graf, fileMode, dirMode)
+				if err != nil {
+					return fmt.Errorf("backing up '%s' (push aborted): %w", uid, err)
+				}
+				if saved != "" {
+					fmt.Fprintf(out, "  backed up %s -> %s\n", uid, saved)
+				}
+			}
+			folder := grafanaFolder
+			if folder == "" {
+				folder = cfg.DashboardFolder(dbCfg)
+			}
+			folderUID, err := folders.Resolve(folder)
+			if err != nil {
+				return fmt.Errorf("dashboard '%s': folder '%s': %w", name, folder, err)
+			}
+			if pushDiff {
+				status, err := generator.PushChanged(dashboard, folderUID, graf)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "  error pushing %s: %v\n", name, err)
+				} else {
+					pushCounts[status]++
+					if status == generator.PushUnchanged {
+						fmt.Fprintf(out, "  unchanged %v, skipped push\n", dashboard["uid"])
+					}
+				}
+			} else if err := generator.PushWithLibraryPanels(dashboard, folderUID, graf); err != nil {
 				fmt.Fprintf(os.Stderr, "  error pushing %s: %v\n", name, err)
 			}
 		}
 	}
+	if push && pushDiff {
+		fmt.Fprintf(out, "  push: %d created, %d updated, %d unchanged\n", pushCounts[generator.PushCreated], pushCounts[generator.PushUpdated], pushCounts[generator.PushUnchanged])
+	}
+
+	if len(bundle) > 0 {
+		sizes, err := generator.WriteConfigMaps(bundle, filepath.Join(outDir, generator.ConfigMapBundleFile), dryRun, out, fileMode)
+		if err != nil {
+			return err
+		}
+		for i, entry := range bundle {
+			summary.Add(entry.Key, entry.Dashboard, sizes[i])
+		}
+		written = append(written, generator.ConfigMapBundleFile)
+	}
+
+	if dsProvision {
+		fpath := filepath.Join(outDir, generator.DatasourceProvisioningFile)
+		if err := generator.WriteDatasourceProvisioning(cfg, fpath, dryRun, out, fileMode); err != nil {
+			return err
+		}
+	}
 
-	fmt.Printf("\n  total: %d dashboards, %d panels, %s bytes\n", len(dashboards), totalPanels, formatTotalSize(totalSize))
+	if !dryRun {
+		if clean {
+			// keep files of every configured dashboard, not just this profile
+			all, err := cfg.GetDashboards("")
+			if err != nil {
+				return err
+			}
+			var keep []string
+			if outputFormat == "configmap" && cmBundle {
+				keep = append(keep, generator.ConfigMapBundleFile)
+			} else {
+				for name, dbCfg := range all {
+					keep = append(keep, outputFilename(name, dbCfg))
+				}
+				if homeCfg != nil {
+					keep = append(keep, outputFilename(generator.HomeDashboardName, *homeCfg))
+				}
+			}
+			removed, err := generator.CleanStale(outDir, keep)
+			if err != nil {
+				return err
+			}
+			for _, f := range removed {
+				fmt.Fprintf(out, "  removed stale %s\n", f)
+			}
+		}
+		if err := generator.UpdateManifest(outDir, written); err != nil {
+			return fmt.Errorf("updating manifest: %w", err)
+		}
+	}
+
+	if jsonSummary {
+		return summary.WriteJSON(os.Stdout)
+	}
+	fmt.Fprintf(out, "\n  total: %d dashboards, %d panels, %s bytes\n", summary.Dashboards, summary.Panels, formatTotalSize(summary.Bytes))
 	return nil
 }
 
+// dashboardFilename returns the output filename for a dashboard.
+func dashboardFilename(name string, dbCfg config.DashboardConfig) string {
+	if dbCfg.Filename != "" {
+		return dbCfg.Filename
+	}
+	return name + ".json"
+}
+
+// outputFilename returns the file a dashboard is written to in the current
+// --output-format: its JSON filename, or the same name with a .yaml
+// extension for configmap.
+func outputFilename(name string, dbCfg config.DashboardConfig) string {
+	filename := dashboardFilename(name, dbCfg)
+	if outputFormat == "configmap" {
+		return strings.TrimSuffix(filename, ".json") + ".yaml"
+	}
+	return filename
+}
+
+// selectDashboards returns the dashboards for the active profile together
+// with their generation order: YAML/profile order first, then any remaining
+// dashboards sorted by name.
+func selectDashboards(cfg *config.Config) (map[string]config.DashboardConfig, []string, error) {
+	dashboards, err := cfg.GetDashboards(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(dashboards) == 0 {
+		return nil, nil, fmt.Errorf("no dashboards defined in config")
+	}
+
+	order, err := cfg.GetDashboardOrder(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	// ensure order only includes dashboards that exist
+	var filteredOrder []string
+	for _, name := range order {
+		if _, ok := dashboards[name]; ok {
+			filteredOrder = append(filteredOrder, name)
+		}
+	}
+	// add any dashboards not in the order list
+	orderSet := make(map[string]bool)
+	for _, name := range filteredOrder {
+		orderSet[name] = true
+	}
+	var remaining []string
+	for name := range dashboards {
+		if !orderSet[name] {
+			remaining = append(remaining, name)
+		}
+	}
+	sort.Strings(remaining)
+	filteredOrder = append(filteredOrder, remaining...)
+	return dashboards, filteredOrder, nil
+}
+
+// buildDiscoverySections returns auto-discovered sections when discovery is
+// enabled in config.
+func buildDiscoverySections(cfg *config.Config) ([]config.SectionConfig, error) {
+	discoveryCfg := cfg.GetDiscovery()
+	if !discoveryCfg.Enabled || len(discoveryCfg.Sources) == 0 {
+		return nil, nil
+	}
+	disc := newDiscovery(cfg)
+	sections, err := disc.GenerateDiscoverySections(
+		discoveryCfg.Sources,
+		discoveryCfg.IncludePatterns,
+		discoveryCfg.ExcludePatterns,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	return sections, nil
+}
+
 func formatTotalSize(n int) string {
 	s := fmt.Sprintf("%d", n)
 	if len(s) <= 3 {
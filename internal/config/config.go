@@ -1,9 +1,19 @@
 package config
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -11,12 +21,93 @@ import (
 
 var bracedRefRe = regexp.MustCompile(`\$\{(\w+)\}`)
 
+// selectorCallRe matches ${name(arg, ...)}. Arguments may not contain
+// parentheses or braces, so calls cannot nest.
+var selectorCallRe = regexp.MustCompile(`\$\{(\w+)\(([^(){}]*)\)\}`)
+
+// selectorNestedRe detects a reference inside a selector call's args.
+var selectorNestedRe = regexp.MustCompile(`\$\{\w+\([^)]*\$\{`)
+
+var selectorParamRe = regexp.MustCompile(`\$(\d+)`)
+
 // DatasourceDef is a datasource definition from config YAML.
 type DatasourceDef struct {
 	Type      string `yaml:"type"`
 	UID       string `yaml:"uid"`
 	URL       string `yaml:"url"`
 	IsDefault bool   `yaml:"is_default"`
+	// URLs maps environment names to URLs; the --env flag picks one,
+	// falling back to URL when the environment has no entry.
+	URLs map[string]string `yaml:"urls"`
+	// JSONData and SecureJSONData are only used for datasource
+	// provisioning output.
+	JSONData       map[string]interface{} `yaml:"json_data"`
+	SecureJSONData map[string]string      `yaml:"secure_json_data"`
+	// BearerToken or BasicAuthUser/BasicAuthPass authenticate discovery
+	// requests. Each may reference environment variables as ${NAME} or
+	// ${ENV:NAME} so secrets stay out of the config file.
+	BearerToken   string `yaml:"bearer_token"`
+	BasicAuthUser string `yaml:"basic_auth_user"`
+	BasicAuthPass string `yaml:"basic_auth_pass"`
+}
+
+// expandSecret expands ${ENV:NAME} references and the bare ${NAME} form
+// credential fields also accept.
+func expandSecret(s string) string {
+	return bracedRefRe.ReplaceAllStringFunc(ExpandEnv(s), func(m string) string {
+		return os.Getenv(bracedRefRe.FindStringSubmatch(m)[1])
+	})
+}
+
+// envRefRe matches ${ENV:NAME} and ${ENV:NAME:-fallback}.
+var envRefRe = regexp.MustCompile(`\$\{ENV:(\w+)(?::-([^}]*))?\}`)
+
+// ExpandEnv substitutes ${ENV:NAME} refs with environment variables. With
+// ${ENV:NAME:-fallback}, fallback is used when NAME is unset or empty.
+func ExpandEnv(s string) string {
+	if !strings.Contains(s, "${ENV:") {
+		return s
+	}
+	return envRefRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := envRefRe.FindStringSubmatch(m)
+		if v := os.Getenv(sub[1]); v != "" {
+			return v
+		}
+		return sub[2]
+	})
+}
+
+// expandEnvRefs resolves ${ENV:...} refs in datasource URLs, constants and
+// selectors at load time; queries and titles are expanded by ResolveRef.
+func (c *Config) expandEnvRefs() {
+	for name, ds := range c.Datasources {
+		ds.URL = ExpandEnv(ds.URL)
+		for env, u := range ds.URLs {
+			ds.URLs[env] = ExpandEnv(u)
+		}
+		c.Datasources[name] = ds
+	}
+	for k, v := range c.Constants {
+		c.Constants[k] = ExpandEnv(v)
+	}
+	for k, v := range c.Selectors {
+		c.Selectors[k] = ExpandEnv(v)
+	}
+}
+
+// AuthHeader returns the Authorization header for requests to the
+// datasource, or "" when it has no credentials. A bearer token wins over
+// basic auth.
+func (d DatasourceDef) AuthHeader() string {
+	if token := expandSecret(d.BearerToken); token != "" {
+		return "Bearer " + token
+	}
+	user := expandSecret(d.BasicAuthUser)
+	if user == "" {
+		return ""
+	}
+	creds := user + ":" + expandSecret(d.BasicAuthPass)
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
 }
 
 // DatasourceRef is a Grafana datasource reference used in panels.
@@ -33,24 +124,30 @@ type ThresholdStep struct {
 
 // VariableDef is a template variable definition.
 type VariableDef struct {
-	Type       string   `yaml:"type"`
-	Datasource string   `yaml:"datasource"`
-	Query      string   `yaml:"query"`
-	Multi      bool     `yaml:"multi"`
-	IncludeAll bool     `yaml:"include_all"`
-	Refresh    int      `yaml:"refresh"`
-	Sort       int      `yaml:"sort"`
-	Label      string   `yaml:"label"`
-	Hide       int      `yaml:"hide"`
-	Regex      string   `yaml:"regex"`
-	AllValue   string   `yaml:"all_value"`
-	ChainsFrom []string `yaml:"chains_from"`
-	Values     string   `yaml:"values"`
-	DsType     string   `yaml:"ds_type"`
-	Auto       bool     `yaml:"auto"`
-	AutoCount  int      `yaml:"auto_count"`
-	AutoMin    string   `yaml:"auto_min"`
-	Default    struct {
+	Type        string   `yaml:"type"`
+	Datasource  string   `yaml:"datasource"`
+	Query       string   `yaml:"query"`
+	Multi       bool     `yaml:"multi"`
+	IncludeAll  bool     `yaml:"include_all"`
+	Refresh     int      `yaml:"refresh"`
+	Sort        int      `yaml:"sort"`
+	Label       string   `yaml:"label"`
+	Hide        int      `yaml:"hide"`
+	Regex       string   `yaml:"regex"`
+	AllValue    string   `yaml:"all_value"`
+	ChainsFrom  []string `yaml:"chains_from"`
+	Values      string   `yaml:"values"`
+	// ValuesFile lists custom variable values one per line, relative to the
+	// config file.
+	ValuesFile  string   `yaml:"values_file"`
+	DsType      string   `yaml:"ds_type"`
+	Auto        bool     `yaml:"auto"`
+	AutoCount   int      `yaml:"auto_count"`
+	AutoMin     string   `yaml:"auto_min"`
+	Description string   `yaml:"description"`
+	// AllowCustomValue is emitted only when set; Grafana defaults to true.
+	AllowCustomValue *bool `yaml:"allow_custom_value"`
+	Default          struct {
 		Text  string `yaml:"text"`
 		Value string `yaml:"value"`
 	} `yaml:"default"`
@@ -61,11 +158,93 @@ type GeneratorSettings struct {
 	SchemaVersion int               `yaml:"schema_version"`
 	OutputDir     string            `yaml:"output_dir"`
 	Refresh       string            `yaml:"refresh"`
-	TimeRange     map[string]string `yaml:"time_range"`
+	TimeRange     TimeRange         `yaml:"time_range"`
 	Editable      *bool             `yaml:"editable"`
 	GraphTooltip  int               `yaml:"graph_tooltip"`
 	LiveNow       *bool             `yaml:"live_now"`
 	Timezone      string            `yaml:"timezone"`
+	// RequireDatasources makes generation fail when a datasource referenced
+	// by a built panel does not answer a health probe.
+	RequireDatasources bool `yaml:"require_datasources"`
+	// Kiosk hides the time picker, controls and nav links on every
+	// dashboard, for locked wallboards.
+	Kiosk bool `yaml:"kiosk"`
+	// CheckOverlaps fails a dashboard build when panel gridPos rectangles
+	// intersect (usually from manual x/y placement).
+	CheckOverlaps bool `yaml:"check_overlaps"`
+	// FileMode and DirMode are octal permission strings ("0640") for
+	// written dashboard files and created output directories.
+	FileMode string `yaml:"file_mode"`
+	DirMode  string `yaml:"dir_mode"`
+	// HomeDashboard, when set, adds a landing dashboard with a welcome text
+	// panel and links to every generated dashboard.
+	HomeDashboard *HomeDashboardSettings `yaml:"home_dashboard"`
+	// Folder is the Grafana folder title dashboards are pushed into when
+	// they set no folder of their own (default: General).
+	Folder string `yaml:"folder"`
+	// GridWidth is the number of grid columns the layout engine fills
+	// (default 24, Grafana's grid); see GridColumns.
+	GridWidth int `yaml:"grid_width"`
+}
+
+// GridColumns returns grid_width, defaulting to 24 when unset or invalid.
+func (g GeneratorSettings) GridColumns() int {
+	if g.GridWidth <= 0 {
+		return 24
+	}
+	return g.GridWidth
+}
+
+// HomeDashboardSettings configures the generated home dashboard.
+type HomeDashboardSettings struct {
+	UID      string `yaml:"uid"`
+	Title    string `yaml:"title"`
+	Filename string `yaml:"filename"`
+	// Text is the welcome panel's markdown content.
+	Text        string                   `yaml:"text"`
+	Annotations []map[string]interface{} `yaml:"annotations"`
+}
+
+// Modes parses file_mode and dir_mode, defaulting to 0644 and 0755.
+func (g GeneratorSettings) Modes() (file, dir os.FileMode, err error) {
+	file, err = parseMode(g.FileMode, 0644)
+	if err != nil {
+		return 0, 0, fmt.Errorf("generator.file_mode: %w", err)
+	}
+	dir, err = parseMode(g.DirMode, 0755)
+	if err != nil {
+		return 0, 0, fmt.Errorf("generator.dir_mode: %w", err)
+	}
+	return file, dir, nil
+}
+
+func parseMode(s string, def os.FileMode) (os.FileMode, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("invalid mode '%s': want octal like 0644", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// TimeRange is generator.time_range: a {from, to} map, or a preset name such
+// as last_24h, which YAML decoding stores under the "preset" key.
+type TimeRange map[string]string
+
+// UnmarshalYAML accepts a scalar preset name as well as the map form.
+func (t *TimeRange) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*t = TimeRange{"preset": node.Value}
+		return nil
+	}
+	var m map[string]string
+	if err := node.Decode(&m); err != nil {
+		return err
+	}
+	*t = m
+	return nil
 }
 
 // DiscoveryConfig holds metric discovery settings.
@@ -74,12 +253,29 @@ type DiscoveryConfig struct {
 	Sources         []string `yaml:"sources"`
 	IncludePatterns []string `yaml:"include_patterns"`
 	ExcludePatterns []string `yaml:"exclude_patterns"`
+	IncludeTypes    []string `yaml:"include_types"`
+	ExcludeTypes    []string `yaml:"exclude_types"`
 	AutoPanels      map[string]string `yaml:"auto_panels"`
+	// Retries is how many times a failed API request (connection error or
+	// 5xx) is retried; RetryBackoff is the first delay, doubled per retry.
+	Retries         int      `yaml:"retries"`
+	RetryBackoff    string   `yaml:"retry_backoff"`
+	// CacheTTL is how long discovery responses are reused, e.g. "5m";
+	// "0s" keeps them for the life of the process.
+	CacheTTL        string   `yaml:"cache_ttl"`
 }
 
-// ProfileDef is a named dashboard subset.
+// ProfileDef is a named dashboard subset. Include pulls in the dashboards of
+// other profiles ahead of the profile's own list.
 type ProfileDef struct {
 	Dashboards []string `yaml:"dashboards"`
+	Include    []string `yaml:"include"`
+}
+
+// LayoutSlot is one panel size in a named layout template.
+type LayoutSlot struct {
+	W int `yaml:"w"`
+	H int `yaml:"h"`
 }
 
 // SectionConfig is a dashboard section with panels.
@@ -88,6 +284,11 @@ type SectionConfig struct {
 	Collapsed bool                     `yaml:"collapsed"`
 	Repeat    string                   `yaml:"repeat"`
 	Panels    []map[string]interface{} `yaml:"panels"`
+	// LayoutTemplate names a layouts entry whose slots size the panels in order.
+	LayoutTemplate string `yaml:"layout_template"`
+	// Enabled and When skip the whole section (row included) when false.
+	Enabled *bool  `yaml:"enabled"`
+	When    string `yaml:"when"`
 }
 
 // DashboardConfig is a single dashboard definition.
@@ -100,6 +301,20 @@ type DashboardConfig struct {
 	Description string          `yaml:"description"`
 	Variables   []string        `yaml:"variables"`
 	Sections    []SectionConfig `yaml:"sections"`
+	// HideControls overrides generator.kiosk for this dashboard.
+	HideControls *bool `yaml:"hide_controls"`
+	// Annotations are extra annotation layers after the built-in one.
+	Annotations []map[string]interface{} `yaml:"annotations"`
+	// Extends names an entry in bases (or another dashboard) whose sections,
+	// variables, tags and annotations come before this dashboard's own.
+	Extends string `yaml:"extends"`
+	// Folder is the Grafana folder title to push into; overrides
+	// generator.folder.
+	Folder string `yaml:"folder"`
+	// TimeRange and Refresh override generator.time_range and
+	// generator.refresh; an empty time_range ({}) counts as unset.
+	TimeRange TimeRange `yaml:"time_range"`
+	Refresh   string    `yaml:"refresh"`
 }
 
 // Config holds the entire YAML configuration.
@@ -114,39 +329,119 @@ type Config struct {
 	Constants   map[string]string          `yaml:"constants"`
 	Discovery   DiscoveryConfig            `yaml:"discovery"`
 	Profiles    map[string]ProfileDef      `yaml:"profiles"`
+	Layouts     map[string][]LayoutSlot    `yaml:"layouts"`
+	Bases       map[string]DashboardConfig `yaml:"bases"`
 	Dashboards  map[string]DashboardConfig `yaml:"dashboards"`
+	// Includes lists config fragments merged in by Load; see resolveIncludes.
+	Includes []string `yaml:"includes"`
+	// PanelDefaults holds panel keys applied under every panel's own keys.
+	// A map value under a panel type name (timeseries: {...}) applies to
+	// that type only, over the global defaults.
+	PanelDefaults map[string]interface{} `yaml:"panel_defaults"`
 
 	palette        map[string]string
 	cliArgs        map[string]string
 	dashboardOrder []string
+	baseDir        string
 }
 
-// Load reads and parses a YAML config file.
+// Load reads and parses a config file: JSON when the path ends in .json,
+// YAML otherwise.
 func Load(path string, cliArgs map[string]string) (*Config, error) {
+	return load(path, cliArgs, false)
+}
+
+// LoadJSON reads and parses a JSON config file whatever its extension. The
+// schema is the same as YAML's (JSON is valid YAML, so the same decoder and
+// field names apply); syntax errors report their line and column, and
+// dashboard order follows the file's key order.
+func LoadJSON(path string, cliArgs map[string]string) (*Config, error) {
+	return loadFile(path, cliArgs, false, true)
+}
+
+// LoadStrict is like Load but rejects keys that do not map to a config field,
+// so typos such as `dashbords:` fail with their line instead of being ignored.
+// Free-form maps (panel configs, annotations) are not checked.
+func LoadStrict(path string, cliArgs map[string]string) (*Config, error) {
+	return load(path, cliArgs, true)
+}
+
+func load(path string, cliArgs map[string]string, strict bool) (*Config, error) {
+	return loadFile(path, cliArgs, strict, strings.EqualFold(filepath.Ext(path), ".json"))
+}
+
+func loadFile(path string, cliArgs map[string]string, strict, asJSON bool) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading config: %w", err)
 	}
+	var jsonOrder []string
+	if asJSON {
+		if err := checkJSON(data); err != nil {
+			return nil, fmt.Errorf("parsing config: %w", err)
+		}
+		jsonOrder = parseJSONDashboardKeyOrder(data)
+	}
+	data, order, err := resolveIncludes(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if asJSON && order == nil {
+		order = jsonOrder
+	}
 
-	c, err := loadFromData(data, cliArgs)
+	c, err := loadFromData(data, cliArgs, strict)
 	if err != nil {
 		return nil, err
 	}
+	if order != nil {
+		c.dashboardOrder = order
+	}
+	c.baseDir = filepath.Dir(path)
 	return c, nil
 }
 
+// DefaultConfigNames are the filenames FindConfig looks for, in order.
+var DefaultConfigNames = []string{"dashboard-generator.yaml", ".dashboards.yaml"}
+
+// FindConfig searches startDir and each of its parents for a file named in
+// DefaultConfigNames, returning the first match.
+func FindConfig(startDir string) (string, error) {
+	dir, err := filepath.Abs(startDir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, name := range DefaultConfigNames {
+			candidate := filepath.Join(dir, name)
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return "", fmt.Errorf("no config found: pass --config or create %s in this or a parent directory", DefaultConfigNames[0])
+}
+
 // LoadFromBytes parses a YAML config from raw bytes (for validation).
 func LoadFromBytes(data []byte) (*Config, error) {
-	return loadFromData(data, nil)
+	return loadFromData(data, nil, false)
 }
 
-func loadFromData(data []byte, cliArgs map[string]string) (*Config, error) {
+func loadFromData(data []byte, cliArgs map[string]string, strict bool) (*Config, error) {
 	var c Config
-	if err := yaml.Unmarshal(data, &c); err != nil {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(strict)
+	if err := dec.Decode(&c); err != nil && err != io.EOF {
 		return nil, fmt.Errorf("parsing config: %w", err)
 	}
 
 	c.dashboardOrder = parseDashboardKeyOrder(data)
+	c.expandEnvRefs()
 
 	c.cliArgs = cliArgs
 	if c.cliArgs == nil {
@@ -173,6 +468,15 @@ func (c *Config) GetGenerator() GeneratorSettings {
 	return c.Generator
 }
 
+// DashboardFolder returns the Grafana folder title a dashboard is pushed
+// into: its own folder, else generator.folder, else "" for General.
+func (c *Config) DashboardFolder(db DashboardConfig) string {
+	if db.Folder != "" {
+		return db.Folder
+	}
+	return c.Generator.Folder
+}
+
 // GetDatasource returns a DatasourceRef for a named datasource.
 func (c *Config) GetDatasource(name string) (DatasourceRef, error) {
 	ds, ok := c.Datasources[name]
@@ -182,13 +486,19 @@ func (c *Config) GetDatasource(name string) (DatasourceRef, error) {
 	return DatasourceRef{Type: ds.Type, UID: ds.UID}, nil
 }
 
-// GetDatasourceURL returns the URL for a named datasource.
+// GetDatasourceURL returns the URL for a named datasource, preferring the
+// --prometheus-url override, then the --env entry in urls, then url.
 func (c *Config) GetDatasourceURL(name string) string {
 	ds, ok := c.Datasources[name]
 	if !ok {
 		return ""
 	}
 	url := ds.URL
+	if env, ok := c.cliArgs["env"]; ok {
+		if envURL, ok := ds.URLs[env]; ok {
+			url = envURL
+		}
+	}
 	if promURL, ok := c.cliArgs["prometheus_url"]; ok && name == c.firstDSName() {
 		url = promURL
 	}
@@ -249,49 +559,238 @@ func (c *Config) GetConstant(name string) string {
 	return c.Constants[name]
 }
 
+// jsOnlyRegexRe matches JavaScript regex constructs RE2 cannot parse
+// (lookarounds, backreferences), which CheckRegex leaves to Grafana.
+var jsOnlyRegexRe = regexp.MustCompile(`\(\?<?[=!]|\\[1-9]`)
+
+// CheckRegex reports whether the variable's regex compiles. Grafana takes a
+// JavaScript regex, optionally written /pattern/flags; capture groups (named
+// with (?<text>..) and (?<value>..), or the first unnamed group) select the
+// part of each value that is kept.
+func (v VariableDef) CheckRegex() error {
+	if v.Regex == "" {
+		return nil
+	}
+	pattern := v.Regex
+	if strings.HasPrefix(pattern, "/") {
+		if end := strings.LastIndex(pattern, "/"); end > 0 {
+			pattern = pattern[1:end]
+		}
+	}
+	if _, err := regexp.Compile(pattern); err != nil && !jsOnlyRegexRe.MatchString(pattern) {
+		return fmt.Errorf("invalid regex '%s': %w", v.Regex, err)
+	}
+	return nil
+}
+
+// ResolvePath resolves a path referenced from the config against the
+// directory of the config file. Configs parsed from bytes resolve against
+// the working directory.
+func (c *Config) ResolvePath(p string) string {
+	if filepath.IsAbs(p) || c.baseDir == "" {
+		return p
+	}
+	return filepath.Join(c.baseDir, p)
+}
+
 // GetVariableDef returns a variable definition by name.
 func (c *Config) GetVariableDef(name string) (VariableDef, bool) {
 	v, ok := c.Variables[name]
 	return v, ok
 }
 
-// GetDashboards returns dashboards, optionally filtered by profile.
-func (c *Config) GetDashboards(profile string) (map[string]DashboardConfig, error) {
-	if profile == "" {
-		return c.Dashboards, nil
+// ApplySets overrides selectors or constants from key=value pairs (the CLI's
+// --set). A key naming an existing selector replaces that selector; any
+// other key sets a constant.
+func (c *Config) ApplySets(sets []string) error {
+	for _, set := range sets {
+		key, value, ok := strings.Cut(set, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return fmt.Errorf("invalid --set '%s': want key=value", set)
+		}
+		if _, isSelector := c.Selectors[key]; isSelector {
+			c.Selectors[key] = value
+			continue
+		}
+		if c.Constants == nil {
+			c.Constants = make(map[string]string)
+		}
+		c.Constants[key] = value
 	}
-	p, ok := c.Profiles[profile]
+	return nil
+}
+
+// GetLayout returns the slots of a named layout template.
+func (c *Config) GetLayout(name string) ([]LayoutSlot, error) {
+	slots, ok := c.Layouts[name]
 	if !ok {
-		return nil, fmt.Errorf("profile '%s' not defined in config", profile)
+		return nil, fmt.Errorf("layout template '%s' not defined in config", name)
 	}
-	filtered := make(map[string]DashboardConfig)
+	return slots, nil
+}
+
+// GetDashboards returns dashboards, optionally filtered by profile, with
+// extends resolved.
+func (c *Config) GetDashboards(profile string) (map[string]DashboardConfig, error) {
 	nameSet := make(map[string]bool)
-	for _, n := range p.Dashboards {
-		nameSet[n] = true
+	if profile != "" {
+		names, err := c.resolveProfile(profile, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			nameSet[n] = true
+		}
 	}
+	filtered := make(map[string]DashboardConfig)
 	for k, v := range c.Dashboards {
-		if nameSet[k] {
-			filtered[k] = v
+		if profile != "" && !nameSet[k] {
+			continue
 		}
+		db, err := c.resolveExtends(v, []string{k})
+		if err != nil {
+			return nil, fmt.Errorf("dashboard '%s': %w", k, err)
+		}
+		if db.UID == "" {
+			db.UID = GeneratedUID(k)
+		}
+		filtered[k] = db
 	}
 	return filtered, nil
 }
 
+// uidSlugRe matches runs of characters not allowed in a generated uid.
+var uidSlugRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// GeneratedUID derives a stable uid for a dashboard that omits one: its
+// slugified name, cut to leave room for a short hash of the full name so
+// names that slugify alike still get distinct uids. The result is at most
+// 40 characters, Grafana's uid limit.
+func GeneratedUID(name string) string {
+	slug := strings.Trim(uidSlugRe.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(slug) > 31 {
+		slug = strings.TrimRight(slug[:31], "-")
+	}
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:4])
+	if slug == "" {
+		return hash
+	}
+	return slug + "-" + hash
+}
+
+// resolveExtends merges a dashboard with the chain of bases it extends. Bases
+// are looked up in bases first, then in dashboards. Sections, annotations,
+// variables and tags from the base come first (the latter two deduplicated);
+// description, icon, hide_controls, folder, time_range and refresh are
+// inherited when unset. uid, title and filename are never inherited.
+func (c *Config) resolveExtends(db DashboardConfig, stack []string) (DashboardConfig, error) {
+	if db.Extends == "" {
+		return db, nil
+	}
+	if slices.Contains(stack, db.Extends) {
+		return db, fmt.Errorf("extends cycle: %s -> %s", strings.Join(stack, " -> "), db.Extends)
+	}
+	base, ok := c.Bases[db.Extends]
+	if !ok {
+		base, ok = c.Dashboards[db.Extends]
+	}
+	if !ok {
+		return db, fmt.Errorf("extends '%s': no such base or dashboard", db.Extends)
+	}
+	base, err := c.resolveExtends(base, append(stack, db.Extends))
+	if err != nil {
+		return db, err
+	}
+
+	merged := db
+	merged.Extends = ""
+	merged.Sections = append(slices.Clone(base.Sections), db.Sections...)
+	merged.Annotations = append(slices.Clone(base.Annotations), db.Annotations...)
+	merged.Variables = mergeUnique(base.Variables, db.Variables)
+	merged.Tags = mergeUnique(base.Tags, db.Tags)
+	if merged.Description == "" {
+		merged.Description = base.Description
+	}
+	if merged.Icon == "" {
+		merged.Icon = base.Icon
+	}
+	if merged.HideControls == nil {
+		merged.HideControls = base.HideControls
+	}
+	if merged.Folder == "" {
+		merged.Folder = base.Folder
+	}
+	if len(merged.TimeRange) == 0 {
+		merged.TimeRange = base.TimeRange
+	}
+	if merged.Refresh == "" {
+		merged.Refresh = base.Refresh
+	}
+	return merged, nil
+}
+
+// mergeUnique appends b to a, skipping entries already present.
+func mergeUnique(a, b []string) []string {
+	out := slices.Clone(a)
+	for _, v := range b {
+		if !slices.Contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 // GetDashboardOrder returns dashboard names in the order they appear in a profile,
 // or all dashboard names if no profile is specified.
 func (c *Config) GetDashboardOrder(profile string) ([]string, error) {
 	if profile != "" {
-		p, ok := c.Profiles[profile]
-		if !ok {
-			return nil, fmt.Errorf("profile '%s' not defined in config", profile)
-		}
-		return p.Dashboards, nil
+		return c.resolveProfile(profile, nil)
 	}
 	// Without a profile, we need to preserve YAML order.
 	// Since Go maps don't preserve order, we re-parse to get ordered keys.
 	return c.parseDashboardOrder()
 }
 
+// resolveProfile expands a profile's includes depth-first, keeping the first
+// occurrence of each dashboard. stack holds the profiles currently being
+// expanded and is used to report include cycles.
+func (c *Config) resolveProfile(name string, stack []string) ([]string, error) {
+	if slices.Contains(stack, name) {
+		return nil, fmt.Errorf("profile include cycle: %s -> %s", strings.Join(stack, " -> "), name)
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		if len(stack) > 0 {
+			return nil, fmt.Errorf("profile '%s' (included by '%s') not defined in config", name, stack[len(stack)-1])
+		}
+		return nil, fmt.Errorf("profile '%s' not defined in config", name)
+	}
+	stack = append(stack, name)
+	var names []string
+	seen := make(map[string]bool)
+	add := func(n string) {
+		if !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+	for _, inc := range p.Include {
+		members, err := c.resolveProfile(inc, stack)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range members {
+			add(n)
+		}
+	}
+	for _, n := range p.Dashboards {
+		add(n)
+	}
+	return names, nil
+}
+
 func (c *Config) parseDashboardOrder() ([]string, error) {
 	if len(c.dashboardOrder) > 0 {
 		return c.dashboardOrder, nil
@@ -334,6 +833,58 @@ func parseDashboardKeyOrder(data []byte) []string {
 	return nil
 }
 
+// checkJSON reports a JSON syntax error with its line and column.
+func checkJSON(data []byte) error {
+	var v interface{}
+	err := json.Unmarshal(data, &v)
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		line := 1 + bytes.Count(data[:syntax.Offset], []byte("\n"))
+		col := int(syntax.Offset) - bytes.LastIndexByte(data[:syntax.Offset], '\n') - 1
+		return fmt.Errorf("line %d, column %d: %w", line, col, err)
+	}
+	return err
+}
+
+// parseJSONDashboardKeyOrder is parseDashboardKeyOrder for JSON: it walks
+// the token stream, since decoding into a map loses key order.
+func parseJSONDashboardKeyOrder(data []byte) []string {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		if key != "dashboards" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil
+			}
+			continue
+		}
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return nil
+		}
+		var order []string
+		for dec.More() {
+			name, err := dec.Token()
+			if err != nil {
+				return nil
+			}
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil
+			}
+			order = append(order, fmt.Sprint(name))
+		}
+		return order
+	}
+	return nil
+}
+
 // GetDiscovery returns the discovery config.
 func (c *Config) GetDiscovery() DiscoveryConfig {
 	return c.Discovery
@@ -346,18 +897,100 @@ func (c *Config) resolveColorName(name string) string {
 	return name
 }
 
+// maxRefDepth bounds how deeply constants and selectors may reference each
+// other before ResolveRef gives up.
+const maxRefDepth = 10
+
 // ResolveRef resolves ${name} references in a string (constants and selectors).
+// Parameterized selectors are invoked as ${name(a, b)}, substituting $1, $2.
+// ${ENV:NAME} refs are replaced from the environment first. Values that
+// themselves contain references are expanded recursively; on a cycle (or a
+// chain deeper than maxRefDepth) the original string is returned unexpanded.
 func (c *Config) ResolveRef(value string) string {
-	return bracedRefRe.ReplaceAllStringFunc(value, func(match string) string {
+	value = ExpandEnv(value)
+	if selectorNestedRe.MatchString(value) {
+		fmt.Fprintf(os.Stderr, "  warning: nested selector calls are not supported: %s\n", value)
+		return value
+	}
+	resolved, err := c.resolveRefs(value, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "  warning: %v: %s\n", err, value)
+		return value
+	}
+	return resolved
+}
+
+// resolveRefs expands one level of references in value, then recurses into
+// each substituted value with the reference chain in stack.
+func (c *Config) resolveRefs(value string, stack []string) (string, error) {
+	if len(stack) > maxRefDepth {
+		return "", fmt.Errorf("reference chain deeper than %d (%s)", maxRefDepth, strings.Join(stack, " -> "))
+	}
+	var refErr error
+	expand := func(name, v string) string {
+		for _, seen := range stack {
+			if seen == name {
+				refErr = fmt.Errorf("reference cycle (%s -> %s)", strings.Join(stack, " -> "), name)
+				return v
+			}
+		}
+		out, err := c.resolveRefs(v, append(stack[:len(stack):len(stack)], name))
+		if err != nil {
+			refErr = err
+		}
+		return out
+	}
+	value = selectorCallRe.ReplaceAllStringFunc(value, func(match string) string {
+		m := selectorCallRe.FindStringSubmatch(match)
+		if v, ok := c.expandSelector(m[1], m[2]); ok {
+			return expand(m[1], v)
+		}
+		return match
+	})
+	value = bracedRefRe.ReplaceAllStringFunc(value, func(match string) string {
 		refName := bracedRefRe.FindStringSubmatch(match)[1]
 		if v := c.GetConstant(refName); v != "" {
-			return v
+			return expand(refName, v)
 		}
 		if v := c.GetSelector(refName); v != "" {
-			return v
+			return expand(refName, v)
 		}
 		return match
 	})
+	return value, refErr
+}
+
+// expandSelector substitutes positional args into a parameterized selector.
+// The call is left unexpanded when the arg count does not match the highest
+// $N placeholder.
+func (c *Config) expandSelector(name, rawArgs string) (string, bool) {
+	sel := c.GetSelector(name)
+	if sel == "" {
+		return "", false
+	}
+	var args []string
+	if strings.TrimSpace(rawArgs) != "" {
+		for _, a := range strings.Split(rawArgs, ",") {
+			args = append(args, strings.TrimSpace(a))
+		}
+	}
+	want := 0
+	for _, m := range selectorParamRe.FindAllStringSubmatch(sel, -1) {
+		if n, _ := strconv.Atoi(m[1]); n > want {
+			want = n
+		}
+	}
+	if len(args) != want {
+		fmt.Fprintf(os.Stderr, "  warning: selector '%s' takes %d args, got %d\n", name, want, len(args))
+		return "", false
+	}
+	return selectorParamRe.ReplaceAllStringFunc(sel, func(p string) string {
+		n, _ := strconv.Atoi(p[1:])
+		if n < 1 {
+			return p
+		}
+		return args[n-1]
+	}), true
 }
 
 // ResolveColor resolves a $color_name reference to a hex color.
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -122,6 +123,101 @@ dashboards:
 	}
 }
 
+func TestLoadJSON(t *testing.T) {
+	yamlPath := writeTestConfig(t, `
+palettes:
+  grafana: { green: "#73BF69", red: "#F2495C" }
+active_palette: grafana
+thresholds:
+  health:
+    - { color: "$red", value: null }
+    - { color: "$green", value: 1 }
+datasources:
+  primary: { type: prometheus, uid: prometheus, is_default: true }
+dashboards:
+  zeta:
+    uid: zeta
+    title: zeta
+    sections:
+      - title: health
+        panels:
+          - { type: stat, title: up, query: up, thresholds: $health }
+  alpha: { uid: alpha, title: alpha }
+  mid: { uid: mid, title: mid }
+`)
+	jsonPath := filepath.Join(t.TempDir(), "config.json")
+	jsonData := `{
+  "palettes": {"grafana": {"green": "#73BF69", "red": "#F2495C"}},
+  "active_palette": "grafana",
+  "thresholds": {
+    "health": [{"color": "$red", "value": null}, {"color": "$green", "value": 1}]
+  },
+  "datasources": {"primary": {"type": "prometheus", "uid": "prometheus", "is_default": true}},
+  "dashboards": {
+    "zeta": {
+      "uid": "zeta",
+      "title": "zeta",
+      "sections": [
+        {"title": "health", "panels": [{"type": "stat", "title": "up", "query": "up", "thresholds": "$health"}]}
+      ]
+    },
+    "alpha": {"uid": "alpha", "title": "alpha"},
+    "mid": {"uid": "mid", "title": "mid"}
+  }
+}`
+	if err := os.WriteFile(jsonPath, []byte(jsonData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fromYAML, err := Load(yamlPath, nil)
+	if err != nil {
+		t.Fatalf("Load yaml: %v", err)
+	}
+	fromJSON, err := Load(jsonPath, nil)
+	if err != nil {
+		t.Fatalf("Load json: %v", err)
+	}
+
+	yOrder, _ := fromYAML.GetDashboardOrder("")
+	jOrder, _ := fromJSON.GetDashboardOrder("")
+	if got := strings.Join(jOrder, ","); got != "zeta,alpha,mid" || got != strings.Join(yOrder, ",") {
+		t.Errorf("json order = %s, yaml order = %s, want zeta,alpha,mid for both", got, strings.Join(yOrder, ","))
+	}
+	jDBs, _ := fromJSON.GetDashboards("")
+	yDBs, _ := fromYAML.GetDashboards("")
+	if len(jDBs) != 3 || jDBs["zeta"].UID != yDBs["zeta"].UID || len(jDBs["zeta"].Sections[0].Panels) != 1 {
+		t.Errorf("json dashboards = %v, want the yaml fixture's", jDBs)
+	}
+	if fromJSON.GetDefaultDatasource().UID != "prometheus" {
+		t.Errorf("default datasource = %v, want prometheus", fromJSON.GetDefaultDatasource())
+	}
+	jSteps := fromJSON.ResolveThresholds(jDBs["zeta"].Sections[0].Panels[0]["thresholds"])
+	ySteps := fromYAML.ResolveThresholds(yDBs["zeta"].Sections[0].Panels[0]["thresholds"])
+	if len(jSteps) != 2 || len(ySteps) != 2 {
+		t.Fatalf("steps json=%v yaml=%v, want 2 each", jSteps, ySteps)
+	}
+	for i := range jSteps {
+		if jSteps[i].Color != ySteps[i].Color || jSteps[i].Value != ySteps[i].Value {
+			t.Errorf("step %d json=%+v yaml=%+v", i, jSteps[i], ySteps[i])
+		}
+	}
+
+	// LoadJSON ignores the extension; syntax errors carry a position
+	other := filepath.Join(t.TempDir(), "config.cfg")
+	if err := os.WriteFile(other, []byte(jsonData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadJSON(other, nil); err != nil {
+		t.Errorf("LoadJSON: %v", err)
+	}
+	if err := os.WriteFile(other, []byte("{\n  \"dashboards\": {,}\n}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadJSON(other, nil); err == nil || !strings.Contains(err.Error(), "line 2, column 18") {
+		t.Errorf("err = %v, want a line 2, column 18 syntax error", err)
+	}
+}
+
 func TestResolveRef(t *testing.T) {
 	cfg := `
 constants:
@@ -156,6 +252,43 @@ dashboards: {}
 	}
 }
 
+func TestResolveRefNested(t *testing.T) {
+	c, err := LoadFromBytes([]byte(`
+constants:
+  job: node
+  cluster: prod
+  self_loop: "x${self_loop}"
+  ping: "${pong}"
+  pong: "${ping}"
+selectors:
+  base_selector: 'job="${job}"'
+  cluster_selector: '${base_selector}, cluster="${cluster}"'
+  host_selector: '{${cluster_selector}, instance=~"$instance"}'
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+dashboards: {}
+`))
+	if err != nil {
+		t.Fatalf("LoadFromBytes error: %v", err)
+	}
+
+	tests := []struct {
+		name, input, want string
+	}{
+		{"two levels", "up{${base_selector}}", `up{job="node"}`},
+		{"three levels", "up${host_selector}", `up{job="node", cluster="prod", instance=~"$instance"}`},
+		{"self cycle", "a${self_loop}b", "a${self_loop}b"},
+		{"mutual cycle", "${ping}", "${ping}"},
+	}
+	for _, tt := range tests {
+		if got := c.ResolveRef(tt.input); got != tt.want {
+			t.Errorf("%s: ResolveRef(%q) = %q, want %q", tt.name, tt.input, got, tt.want)
+		}
+	}
+}
+
 func TestResolveColor(t *testing.T) {
 	cfg := `
 palettes:
@@ -331,3 +464,638 @@ dashboards:
 		}
 	}
 }
+
+func TestFindConfig(t *testing.T) {
+	root := t.TempDir()
+	want := filepath.Join(root, "dashboard-generator.yaml")
+	if err := os.WriteFile(want, []byte("dashboards: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(root, "a", "b", "c")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := FindConfig(nested)
+	if err != nil {
+		t.Fatalf("FindConfig error: %v", err)
+	}
+	if got != want {
+		t.Errorf("FindConfig = %s, want %s", got, want)
+	}
+
+	// nearer config wins
+	nearer := filepath.Join(root, "a", ".dashboards.yaml")
+	if err := os.WriteFile(nearer, []byte("dashboards: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = FindConfig(nested)
+	if err != nil {
+		t.Fatalf("FindConfig error: %v", err)
+	}
+	if got != nearer {
+		t.Errorf("FindConfig = %s, want %s", got, nearer)
+	}
+}
+
+func TestResolveRefParameterizedSelector(t *testing.T) {
+	cfg := `
+constants:
+  rate_interval: "5m"
+selectors:
+  pod: '{namespace="$1", pod=~"$2"}'
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+dashboards: {}
+`
+	path := writeTestConfig(t, cfg)
+	c, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+
+	tests := []struct {
+		input, want string
+	}{
+		{"up${pod(prod, web-.*)}", `up{namespace="prod", pod=~"web-.*"}`},
+		{"rate(x${pod(a,b)}[${rate_interval}])", `rate(x{namespace="a", pod=~"b"}[5m])`},
+		// wrong arg count and nested calls are left unexpanded
+		{"up${pod(prod)}", "up${pod(prod)}"},
+		{"up${pod(prod, ${pod(a, b)})}", "up${pod(prod, ${pod(a, b)})}"},
+		{"up${unknown(a)}", "up${unknown(a)}"},
+	}
+	for _, tt := range tests {
+		got := c.ResolveRef(tt.input)
+		if got != tt.want {
+			t.Errorf("ResolveRef(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestComposedProfiles(t *testing.T) {
+	cfg := `
+profiles:
+  infra:
+    dashboards: [overview, compute]
+  apps:
+    dashboards: [services, overview]
+  all:
+    include: [infra, apps]
+    dashboards: [extra]
+  loop_a:
+    include: [loop_b]
+  loop_b:
+    include: [loop_a]
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+dashboards:
+  overview: { uid: gen-overview, title: overview, sections: [] }
+  compute: { uid: gen-compute, title: compute, sections: [] }
+  services: { uid: gen-services, title: services, sections: [] }
+  extra: { uid: gen-extra, title: extra, sections: [] }
+  other: { uid: gen-other, title: other, sections: [] }
+`
+	path := writeTestConfig(t, cfg)
+	c, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+
+	order, err := c.GetDashboardOrder("all")
+	if err != nil {
+		t.Fatalf("GetDashboardOrder error: %v", err)
+	}
+	want := []string{"overview", "compute", "services", "extra"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+
+	dbs, err := c.GetDashboards("all")
+	if err != nil {
+		t.Fatalf("GetDashboards error: %v", err)
+	}
+	if len(dbs) != 4 {
+		t.Errorf("dashboard count = %d, want 4", len(dbs))
+	}
+	if _, ok := dbs["other"]; ok {
+		t.Error("other should not be in composed profile")
+	}
+
+	_, err = c.GetDashboardOrder("loop_a")
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected cycle error, got %v", err)
+	}
+}
+
+func TestLoadStrictUnknownKey(t *testing.T) {
+	cfg := `
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+dashbords:
+  overview:
+    uid: gen-overview
+`
+	path := writeTestConfig(t, cfg)
+
+	c, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if len(c.Dashboards) != 0 {
+		t.Errorf("dashboards = %d, want 0", len(c.Dashboards))
+	}
+
+	_, err = LoadStrict(path, nil)
+	if err == nil {
+		t.Fatal("expected strict load to reject unknown key")
+	}
+	if !strings.Contains(err.Error(), "dashbords") || !strings.Contains(err.Error(), "line 6") {
+		t.Errorf("error should name the key and line, got: %v", err)
+	}
+}
+
+func TestDashboardExtends(t *testing.T) {
+	cfg := `
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+bases:
+  base_overview:
+    tags: [generated]
+    variables: [datasource, instance]
+    icon: apps
+    sections:
+      - title: header
+        panels: []
+dashboards:
+  compute:
+    uid: gen-compute
+    title: compute
+    extends: base_overview
+    tags: [compute, generated]
+    variables: [instance, cpu]
+    sections:
+      - title: cpu
+        panels: []
+  loop:
+    uid: gen-loop
+    title: loop
+    extends: loop
+`
+	path := writeTestConfig(t, cfg)
+	c, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+
+	_, err = c.GetDashboards("")
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected extends cycle error, got %v", err)
+	}
+	delete(c.Dashboards, "loop")
+
+	dbs, err := c.GetDashboards("")
+	if err != nil {
+		t.Fatalf("GetDashboards error: %v", err)
+	}
+	db := dbs["compute"]
+	if len(db.Sections) != 2 || db.Sections[0].Title != "header" || db.Sections[1].Title != "cpu" {
+		t.Errorf("sections = %+v, want header then cpu", db.Sections)
+	}
+	if got := strings.Join(db.Variables, ","); got != "datasource,instance,cpu" {
+		t.Errorf("variables = %s, want datasource,instance,cpu", got)
+	}
+	if got := strings.Join(db.Tags, ","); got != "generated,compute" {
+		t.Errorf("tags = %s, want generated,compute", got)
+	}
+	if db.Icon != "apps" || db.UID != "gen-compute" {
+		t.Errorf("icon = %s, uid = %s; want inherited icon and own uid", db.Icon, db.UID)
+	}
+}
+
+func TestApplySets(t *testing.T) {
+	cfg := `
+constants:
+  rate_interval: "5m"
+selectors:
+  cluster: '{cluster="dev"}'
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+dashboards: {}
+`
+	path := writeTestConfig(t, cfg)
+	c, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if err := c.ApplySets([]string{"rate_interval=1m", `cluster={cluster="prod"}`, "tenant=acme"}); err != nil {
+		t.Fatalf("ApplySets error: %v", err)
+	}
+
+	tests := []struct {
+		input, want string
+	}{
+		{"rate(cpu[${rate_interval}])", "rate(cpu[1m])"},
+		{"up${cluster}", `up{cluster="prod"}`},
+		{"${tenant} overview", "acme overview"},
+	}
+	for _, tt := range tests {
+		if got := c.ResolveRef(tt.input); got != tt.want {
+			t.Errorf("ResolveRef(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	if err := c.ApplySets([]string{"novalue"}); err == nil {
+		t.Error("expected error for --set without '='")
+	}
+}
+
+func TestVariableCheckRegex(t *testing.T) {
+	tests := []struct {
+		regex string
+		ok    bool
+	}{
+		{"", true},
+		{`/.*instance="([^"]+)".*/`, true},
+		{`/(?<text>[^:]+):(?<value>\d+)/`, true},
+		{`/^(?!test-).*/i`, true}, // lookahead: JavaScript-only, left to Grafana
+		{`([a-z]+`, false},
+		{`/[unclosed/`, false},
+	}
+	for _, tt := range tests {
+		err := VariableDef{Regex: tt.regex}.CheckRegex()
+		if (err == nil) != tt.ok {
+			t.Errorf("CheckRegex(%q) error = %v, want ok=%v", tt.regex, err, tt.ok)
+		}
+	}
+}
+
+func TestYAMLEditorFormat(t *testing.T) {
+	path := writeTestConfig(t, `# top comment
+generator:
+    refresh: "30s"   # auto refresh
+datasources:
+    # the secondary source
+    secondary: { type: prometheus, uid: thanos }
+    primary:
+        type: 'prometheus'
+        uid: prometheus
+dashboards:
+    overview:
+        uid: overview
+        description: |
+          multi
+          line
+`)
+	if _, err := NewYAMLEditor(path).Format(true, true); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	want := `# top comment
+generator:
+  refresh: 30s # auto refresh
+
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+  # the secondary source
+  secondary: {type: prometheus, uid: thanos}
+
+dashboards:
+  overview:
+    uid: overview
+    description: |
+      multi
+      line
+`
+	if got != want {
+		t.Errorf("formatted =\n%s\nwant\n%s", got, want)
+	}
+
+	// formatting is idempotent
+	again, err := NewYAMLEditor(path).Format(true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != got {
+		t.Errorf("second format differs:\n%s", again)
+	}
+}
+
+func TestYAMLEditorFormatKeepsBoolQuotes(t *testing.T) {
+	path := writeTestConfig(t, `variables:
+  env:
+    include_all: "yes"
+    all_value: 'on'
+    label: "Yes"
+    empty: ""
+    query: "up"
+`)
+	out, err := NewYAMLEditor(path).Format(false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `variables:
+  env:
+    include_all: "yes"
+    all_value: 'on'
+    label: "Yes"
+    empty: ""
+    query: up
+`
+	if string(out) != want {
+		t.Errorf("formatted =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestValidateRepeats(t *testing.T) {
+	c, err := LoadFromBytes([]byte(`
+variables:
+  instance: { type: query, query: "label_values(up, instance)", multi: true }
+  job: { type: query, query: "label_values(up, job)" }
+dashboards:
+  overview:
+    uid: overview
+    variables: [instance, job]
+    sections:
+      - { title: per instance, repeat: instance, panels: [] }
+      - title: per foo
+        repeat: foo
+        panels:
+          - { type: stat, title: up, query: up, repeat: $bar }
+          - { type: stat, title: jobs, query: up, repeat: job }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]Problem)
+	for _, p := range c.Validate() {
+		got[p.Path] = p
+	}
+	want := map[string]Problem{
+		"dashboards.overview.sections[1].repeat":           {Severity: SeverityError, Message: "repeats over 'foo', which is not a variable of this dashboard"},
+		"dashboards.overview.sections[1].panels[0].repeat": {Severity: SeverityError, Message: "repeats over 'bar', which is not a variable of this dashboard"},
+		"dashboards.overview.sections[1].panels[1].repeat": {Severity: SeverityWarning, Message: "repeats over 'job', which is not multi-value"},
+	}
+	for path, w := range want {
+		p, ok := got[path]
+		if !ok {
+			t.Errorf("missing problem at %s", path)
+			continue
+		}
+		if p.Severity != w.Severity || p.Message != w.Message || p.Dashboard != "overview" {
+			t.Errorf("%s = %+v, want %s %q", path, p, w.Severity, w.Message)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("problems = %+v, want only the repeat problems", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c, err := LoadFromBytes([]byte(`
+datasources:
+  prom:
+    type: prometheus
+    uid: prom
+palettes:
+  grafana:
+    green: "#73BF69"
+active_palette: grafana
+thresholds:
+  health:
+    - { color: $green, value: null }
+    - { color: $crimson, value: 1 }
+variables:
+  job:
+    type: query
+    datasource: prom
+    regex: "/(prod/"
+  node:
+    type: query
+    datasource: thanos
+dashboards:
+  good:
+    uid: good
+    variables: [job]
+    sections:
+      - title: s
+        panels:
+          - { type: stat, title: a, query: up, datasource: prom, color: $green, thresholds: $health }
+  bad:
+    uid: bad
+    variables: [job, cluster]
+    sections:
+      - title: s
+        panels:
+          - { type: stat, title: a, query: up, datasource: loki }
+          - { type: stat, title: b, query: up, thresholds: $missing }
+          - type: stat
+            title: c
+            query: up
+            color: $purple
+            states:
+              - { value: 0, text: down, color: $red }
+          - { type: comparison, title: d, datasources: [prom, thanos] }
+          - type: timeseries
+            title: e
+            targets:
+              - { expr: up, datasource: nowhere }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]Problem)
+	for _, p := range c.Validate() {
+		got[p.Path] = p
+	}
+	want := map[string]string{
+		"thresholds.health[1].color":                                 "color '$crimson' is not in the active palette",
+		"variables.node.datasource":                                  "datasource 'thanos' is not defined",
+		"variables.job.regex":                                        "invalid regex '/(prod/': error parsing regexp: missing closing ): `(prod`",
+		"dashboards.bad.variables[1]":                                "variable 'cluster' is not defined",
+		"dashboards.bad.sections[0].panels[0].datasource":            "datasource 'loki' is not defined",
+		"dashboards.bad.sections[0].panels[1].thresholds":            "threshold 'missing' is not defined",
+		"dashboards.bad.sections[0].panels[2].color":                 "color '$purple' is not in the active palette",
+		"dashboards.bad.sections[0].panels[2].states[0].color":       "color '$red' is not in the active palette",
+		"dashboards.bad.sections[0].panels[3].datasources[1]":        "datasource 'thanos' is not defined",
+		"dashboards.bad.sections[0].panels[4].targets[0].datasource": "datasource 'nowhere' is not defined",
+	}
+	for path, msg := range want {
+		p, ok := got[path]
+		if !ok {
+			t.Errorf("missing problem at %s", path)
+			continue
+		}
+		if p.Message != msg || p.Severity != SeverityError {
+			t.Errorf("%s = %s %q, want error %q", path, p.Severity, p.Message, msg)
+		}
+		if strings.HasPrefix(path, "dashboards.") && p.Dashboard != "bad" {
+			t.Errorf("%s dashboard = %q, want bad", path, p.Dashboard)
+		}
+	}
+	if p, ok := got["variables.node"]; !ok || p.Severity != SeverityWarning {
+		t.Errorf("unused variable node: got %+v, want warning", p)
+	}
+	if len(got) != len(want)+1 {
+		t.Errorf("problem count = %d, want %d: %+v", len(got), len(want)+1, got)
+	}
+
+	starter, err := LoadFromBytes(StarterConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range starter.Validate() {
+		if p.Severity == SeverityError {
+			t.Errorf("starter config: %s: %s", p.Path, p.Message)
+		}
+	}
+}
+
+func TestEnvInterpolation(t *testing.T) {
+	t.Setenv("PROM_HOST", "prom.internal:9090")
+	t.Setenv("CLUSTER", "eu-1")
+	t.Setenv("EMPTY_VAR", "")
+	path := writeTestConfig(t, `
+datasources:
+  prom:
+    type: prometheus
+    uid: prom
+    url: "http://${ENV:PROM_HOST}"
+    urls:
+      staging: "http://${ENV:STAGING_HOST:-staging.local:9090}"
+  loki:
+    type: loki
+    uid: loki
+    url: "${ENV:LOKI_URL_UNSET:-http://loki:3100}"
+  blank:
+    type: prometheus
+    uid: blank
+    url: "${ENV:EMPTY_VAR:-http://fallback}"
+constants:
+  cluster: "${ENV:CLUSTER}"
+  plain: "${cluster}"
+`)
+	c, err := Load(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.GetDatasourceURL("prom"); got != "http://prom.internal:9090" {
+		t.Errorf("prom url = %s", got)
+	}
+	if got := c.GetDatasourceURL("loki"); got != "http://loki:3100" {
+		t.Errorf("missing var with default: loki url = %s", got)
+	}
+	if got := c.GetDatasourceURL("blank"); got != "http://fallback" {
+		t.Errorf("empty var with default: blank url = %s", got)
+	}
+	staging, err := Load(path, map[string]string{"env": "staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := staging.GetDatasourceURL("prom"); got != "http://staging.local:9090" {
+		t.Errorf("staging url = %s", got)
+	}
+	if got := c.GetConstant("cluster"); got != "eu-1" {
+		t.Errorf("constant cluster = %s, want eu-1", got)
+	}
+	// plain ${constant} refs are untouched by the ENV: pass
+	if got := c.GetConstant("plain"); got != "${cluster}" {
+		t.Errorf("constant plain = %s, want ${cluster}", got)
+	}
+	if got := c.ResolveRef(`up{cluster="${ENV:CLUSTER}", dc="${ENV:DC_UNSET:-dc1}"}`); got != `up{cluster="eu-1", dc="dc1"}` {
+		t.Errorf("query = %s", got)
+	}
+	if got := c.ResolveRef("${ENV:DC_UNSET}"); got != "" {
+		t.Errorf("missing var without default = %q, want empty", got)
+	}
+}
+
+func TestLoadIncludes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("parts/nodes.yaml", `
+variables:
+  instance: { type: query, query: "label_values(up, instance)" }
+datasources:
+  prom: { type: prometheus, uid: from-fragment }
+dashboards:
+  zeta: { uid: zeta, title: zeta }
+  alpha: { uid: alpha, title: alpha }
+`)
+	main := write("main.yaml", `
+includes: [parts/nodes.yaml]
+datasources:
+  prom: { type: prometheus, uid: prom, is_default: true }
+dashboards:
+  overview: { uid: overview, title: overview }
+  mid: { uid: mid, title: mid }
+`)
+
+	for i := 0; i < 3; i++ {
+		c, err := Load(main, nil)
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		order, err := c.GetDashboardOrder("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(order, ","); got != "overview,mid,zeta,alpha" {
+			t.Fatalf("order = %s, want overview,mid,zeta,alpha", got)
+		}
+		if _, ok := c.Variables["instance"]; !ok {
+			t.Error("variable from include missing")
+		}
+		if c.Datasources["prom"].UID != "prom" {
+			t.Errorf("prom uid = %s, want the including file to win", c.Datasources["prom"].UID)
+		}
+	}
+
+	if _, err := LoadStrict(main, nil); err != nil {
+		t.Errorf("LoadStrict with includes: %v", err)
+	}
+
+	files := ConfigFiles(main)
+	if len(files) != 2 || files[0] != main || files[1] != filepath.Join(dir, "parts", "nodes.yaml") {
+		t.Errorf("ConfigFiles = %v, want main.yaml and parts/nodes.yaml", files)
+	}
+
+	write("a.yaml", "includes: [b.yaml]\n")
+	write("b.yaml", "includes: [a.yaml]\n")
+	cyclic := write("cyclic.yaml", "includes: [a.yaml]\n")
+	if _, err := Load(cyclic, nil); err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("err = %v, want include cycle", err)
+	}
+	if files := ConfigFiles(cyclic); len(files) != 3 {
+		t.Errorf("ConfigFiles(cyclic) = %v, want each file once", files)
+	}
+	missing := write("missing.yaml", "includes: [nope.yaml]\n")
+	if _, err := Load(missing, nil); err == nil {
+		t.Error("expected error for missing include")
+	}
+}
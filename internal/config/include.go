@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// resolveIncludes merges the fragments listed under a config's top-level
+// includes key into it. Fragments are merged in order, each overriding
+// entries of earlier ones by key within map sections (datasources,
+// variables, dashboards, ...), and the including file is merged last.
+// The returned dashboard order lists the file's own dashboards first, then
+// those of each fragment. data is returned unchanged when there are no
+// includes.
+func resolveIncludes(path string, data []byte) ([]byte, []string, error) {
+	var head struct {
+		Includes []string `yaml:"includes"`
+	}
+	if err := yaml.Unmarshal(data, &head); err != nil || len(head.Includes) == 0 {
+		return data, nil, nil
+	}
+	merged, order, err := loadFragment(path, data, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, nil, fmt.Errorf("merging includes: %w", err)
+	}
+	return out, order, nil
+}
+
+// loadFragment parses one config file and, depth-first, the fragments it
+// includes. Paths are relative to the including file's directory; stack
+// holds the files being resolved so cycles are reported.
+func loadFragment(path string, data []byte, stack []string) (map[string]interface{}, []string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if slices.Contains(stack, abs) {
+		return nil, nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), abs)
+	}
+	stack = append(stack, abs)
+	if data == nil {
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, nil, fmt.Errorf("reading include: %w", err)
+		}
+	}
+
+	doc := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	var includes []string
+	if list, ok := doc["includes"].([]interface{}); ok {
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("%s: includes entries must be paths", path)
+			}
+			includes = append(includes, s)
+		}
+	}
+	delete(doc, "includes")
+
+	merged := make(map[string]interface{})
+	order := parseDashboardKeyOrder(data)
+	for _, inc := range includes {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(path), inc)
+		}
+		frag, fragOrder, err := loadFragment(inc, nil, stack)
+		if err != nil {
+			return nil, nil, err
+		}
+		mergeConfigMaps(merged, frag)
+		for _, name := range fragOrder {
+			if !slices.Contains(order, name) {
+				order = append(order, name)
+			}
+		}
+	}
+	mergeConfigMaps(merged, doc)
+	return merged, order, nil
+}
+
+// mergeConfigMaps copies src over dst. Top-level sections that are maps in
+// both are merged by key; anything else is replaced.
+func mergeConfigMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sm, ok := v.(map[string]interface{})
+		dm, dok := dst[k].(map[string]interface{})
+		if !ok || !dok {
+			dst[k] = v
+			continue
+		}
+		for kk, vv := range sm {
+			dm[kk] = vv
+		}
+	}
+}
+
+// ConfigFiles returns the absolute paths of a config file and every fragment
+// it includes, transitively, for watching. It is best effort: fragments that
+// are missing or do not parse are still listed (so fixing them is noticed)
+// but not descended into, and cycles are followed once.
+func ConfigFiles(path string) []string {
+	var files []string
+	var walk func(p string)
+	walk = func(p string) {
+		abs, err := filepath.Abs(p)
+		if err != nil || slices.Contains(files, abs) {
+			return
+		}
+		files = append(files, abs)
+		data, err := os.ReadFile(abs)
+		if err != nil {
+			return
+		}
+		var head struct {
+			Includes []string `yaml:"includes"`
+		}
+		if err := yaml.Unmarshal(data, &head); err != nil {
+			return
+		}
+		for _, inc := range head.Includes {
+			if !filepath.IsAbs(inc) {
+				inc = filepath.Join(filepath.Dir(abs), inc)
+			}
+			walk(inc)
+		}
+	}
+	walk(path)
+	return files
+}
@@ -0,0 +1,8 @@
+package config
+
+import _ "embed"
+
+// StarterConfig is the commented starter config written by the init command.
+//
+//go:embed starter.yaml
+var StarterConfig []byte
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Severity classifies a validation problem.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Problem is one finding from Validate. Dashboard is empty for problems
+// outside the dashboards section.
+type Problem struct {
+	Dashboard string
+	Path      string
+	Message   string
+	Severity  Severity
+}
+
+// Validate checks references the generator would otherwise resolve silently:
+// panel and variable datasources, variable regexes, dashboard variables, row
+// and panel repeats, $color references and $threshold references. Problems outside
+// dashboards come first, then each dashboard's in name order.
+func (c *Config) Validate() []Problem {
+	var problems []Problem
+	add := func(dashboard, path string, sev Severity, format string, args ...interface{}) {
+		problems = append(problems, Problem{Dashboard: dashboard, Path: path, Message: fmt.Sprintf(format, args...), Severity: sev})
+	}
+
+	if len(c.Palettes) > 0 && c.ActivePalette != "" {
+		if _, ok := c.Palettes[c.ActivePalette]; !ok {
+			add("", "active_palette", SeverityError, "palette '%s' is not defined", c.ActivePalette)
+		}
+	}
+
+	for _, name := range sortedNames(c.Thresholds) {
+		for i, step := range c.Thresholds[name] {
+			c.checkColor(fmt.Sprintf("thresholds.%s[%d].color", name, i), step.Color, func(path, msg string) {
+				add("", path, SeverityError, "%s", msg)
+			})
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, name := range sortedNames(c.Dashboards) {
+		db, err := c.resolveExtends(c.Dashboards[name], []string{name})
+		if err != nil {
+			add(name, "dashboards."+name+".extends", SeverityError, "%v", err)
+			continue
+		}
+		prefix := "dashboards." + name
+		for i, v := range db.Variables {
+			used[v] = true
+			if _, ok := c.Variables[v]; !ok {
+				add(name, fmt.Sprintf("%s.variables[%d]", prefix, i), SeverityError, "variable '%s' is not defined", v)
+			}
+		}
+		checkRepeat := func(path string, repeat interface{}) {
+			v, _ := repeat.(string)
+			v = strings.TrimPrefix(v, "$")
+			switch def, defined := c.Variables[v]; {
+			case v == "":
+			case !slices.Contains(db.Variables, v):
+				add(name, path, SeverityError, "repeats over '%s', which is not a variable of this dashboard", v)
+			case defined && !def.Multi && !def.IncludeAll:
+				add(name, path, SeverityWarning, "repeats over '%s', which is not multi-value", v)
+			}
+		}
+		for si, section := range db.Sections {
+			checkRepeat(fmt.Sprintf("%s.sections[%d].repeat", prefix, si), section.Repeat)
+			for pi, panel := range section.Panels {
+				path := fmt.Sprintf("%s.sections[%d].panels[%d]", prefix, si, pi)
+				checkRepeat(path+".repeat", panel["repeat"])
+				c.validatePanel(path, panel, func(path, msg string) {
+					add(name, path, SeverityError, "%s", msg)
+				})
+			}
+		}
+	}
+
+	for _, name := range sortedNames(c.Variables) {
+		v := c.Variables[name]
+		if v.Datasource != "" {
+			if _, ok := c.Datasources[v.Datasource]; !ok {
+				add("", "variables."+name+".datasource", SeverityError, "datasource '%s' is not defined", v.Datasource)
+			}
+		}
+		if err := v.CheckRegex(); err != nil {
+			add("", "variables."+name+".regex", SeverityError, "%v", err)
+		}
+		if !used[name] {
+			add("", "variables."+name, SeverityWarning, "variable is not used by any dashboard")
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Dashboard < problems[j].Dashboard
+	})
+	return problems
+}
+
+// validatePanel reports unknown datasources (panel, comparison list and
+// per-target), unknown $threshold refs and unresolvable $colors.
+func (c *Config) validatePanel(path string, panel map[string]interface{}, report func(path, msg string)) {
+	checkDS := func(path string, v interface{}) {
+		if name, ok := v.(string); ok && name != "" {
+			if _, ok := c.Datasources[name]; !ok {
+				report(path, fmt.Sprintf("datasource '%s' is not defined", name))
+			}
+		}
+	}
+	checkDS(path+".datasource", panel["datasource"])
+	if list, ok := panel["datasources"].([]interface{}); ok {
+		for i, ds := range list {
+			checkDS(fmt.Sprintf("%s.datasources[%d]", path, i), ds)
+		}
+	}
+	if targets, ok := panel["targets"].([]interface{}); ok {
+		for i, t := range targets {
+			if tm, ok := t.(map[string]interface{}); ok {
+				checkDS(fmt.Sprintf("%s.targets[%d].datasource", path, i), tm["datasource"])
+			}
+		}
+	}
+	if ref, ok := panel["thresholds"].(string); ok && strings.HasPrefix(ref, "$") {
+		if _, ok := c.Thresholds[ref[1:]]; !ok {
+			report(path+".thresholds", fmt.Sprintf("threshold '%s' is not defined", ref[1:]))
+		}
+	}
+	c.walkColors(path, panel, report)
+}
+
+// walkColors checks every string under a color key, at any depth.
+func (c *Config) walkColors(path string, v interface{}, report func(path, msg string)) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedNames(val) {
+			child := path + "." + k
+			if s, ok := val[k].(string); ok && (k == "color" || strings.HasSuffix(k, "_color")) {
+				c.checkColor(child, s, report)
+				continue
+			}
+			c.walkColors(child, val[k], report)
+		}
+	case []interface{}:
+		for i, item := range val {
+			c.walkColors(fmt.Sprintf("%s[%d]", path, i), item, report)
+		}
+	}
+}
+
+func (c *Config) checkColor(path, value string, report func(path, msg string)) {
+	if !strings.HasPrefix(value, "$") {
+		return
+	}
+	if _, ok := c.palette[value[1:]]; !ok {
+		report(path, fmt.Sprintf("color '%s' is not in the active palette", value))
+	}
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -347,3 +350,121 @@ func findMappingKeyIndex(mapping *yaml.Node, key string) int {
 	}
 	return -1
 }
+
+// formatSortedSections are the top-level mappings whose entries Format may
+// sort by name.
+var formatSortedSections = []string{"datasources", "variables"}
+
+// Format re-emits the config canonically: 2-space indent and quotes only
+// where YAML needs them (block scalars and flow collections are kept).
+// With sortKeys, datasources and variables entries are sorted by name.
+// Comments travel with their nodes. The file is rewritten when write is
+// set; the formatted bytes are returned either way.
+func (e *YAMLEditor) Format(sortKeys, write bool) ([]byte, error) {
+	doc, root, err := e.load()
+	if err != nil {
+		return nil, err
+	}
+	normalizeQuoting(doc)
+	if sortKeys {
+		for _, section := range formatSortedSections {
+			if m := findMappingKey(root, section); m != nil {
+				sortMapping(m)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("encoding config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("encoding config: %w", err)
+	}
+	out := separateTopLevel(buf.Bytes())
+	if write {
+		mode := os.FileMode(0644)
+		if info, err := os.Stat(e.path); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := os.WriteFile(e.path, out, mode); err != nil {
+			return nil, fmt.Errorf("writing config: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// separateTopLevel puts a blank line before each top-level key (and the
+// comment block heading it), which the YAML encoder would otherwise drop.
+func separateTopLevel(data []byte) []byte {
+	lines := strings.SplitAfter(string(data), "\n")
+	var out strings.Builder
+	for i, line := range lines {
+		if i > 0 && isTopLevelStart(lines, i) && strings.TrimSpace(lines[i-1]) != "" {
+			out.WriteString("\n")
+		}
+		out.WriteString(line)
+	}
+	return []byte(out.String())
+}
+
+// isTopLevelStart reports whether line i begins a top-level entry: a key at
+// column 0, or the first line of the comment block directly above one.
+func isTopLevelStart(lines []string, i int) bool {
+	if strings.HasPrefix(lines[i-1], "#") {
+		return false
+	}
+	for j := i; j < len(lines); j++ {
+		line := lines[j]
+		switch {
+		case strings.HasPrefix(line, "#"):
+			continue
+		case line == "" || line[0] == ' ' || line[0] == '\n' || strings.HasPrefix(line, "-"):
+			return false
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// yaml11Keywords are plain scalars YAML 1.1 readers take as bools or null.
+// The encoder leaves most of them unquoted, so quotes on them are kept.
+var yaml11Keywords = map[string]bool{
+	"": true, "~": true, "null": true, "Null": true, "NULL": true,
+	"y": true, "Y": true, "yes": true, "Yes": true, "YES": true,
+	"n": true, "N": true, "no": true, "No": true, "NO": true,
+	"true": true, "True": true, "TRUE": true,
+	"false": true, "False": true, "FALSE": true,
+	"on": true, "On": true, "ON": true,
+	"off": true, "Off": true, "OFF": true,
+}
+
+// normalizeQuoting drops explicit single/double quoting from string scalars
+// so the encoder quotes consistently, only where a plain scalar would be
+// misread. Quotes on YAML 1.1 bool and null words ("yes", 'on') stay.
+func normalizeQuoting(n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!str" && !yaml11Keywords[n.Value] {
+		n.Style &^= yaml.SingleQuotedStyle | yaml.DoubleQuotedStyle
+	}
+	for _, c := range n.Content {
+		normalizeQuoting(c)
+	}
+}
+
+// sortMapping orders a mapping's key/value pairs by key.
+func sortMapping(m *yaml.Node) {
+	if m.Kind != yaml.MappingNode {
+		return
+	}
+	pairs := make([][2]*yaml.Node, 0, len(m.Content)/2)
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		pairs = append(pairs, [2]*yaml.Node{m.Content[i], m.Content[i+1]})
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i][0].Value < pairs[j][0].Value })
+	m.Content = m.Content[:0]
+	for _, p := range pairs {
+		m.Content = append(m.Content, p[0], p[1])
+	}
+}
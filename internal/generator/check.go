@@ -0,0 +1,143 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+)
+
+// CheckResult is the outcome of one dashboard for validate --config-check.
+type CheckResult struct {
+	Name string
+	UID  string
+	Err  error
+}
+
+// CheckDashboards builds every dashboard in order, collecting each one's
+// error instead of stopping at the first.
+func (db *DashboardBuilder) CheckDashboards(dashboards map[string]config.DashboardConfig, order []string, navLinks []interface{}, discoverySections []config.SectionConfig) []CheckResult {
+	var results []CheckResult
+	for _, name := range order {
+		dbCfg, ok := dashboards[name]
+		if !ok {
+			continue
+		}
+		_, err := db.Build(dbCfg, navLinks, discoverySections)
+		results = append(results, CheckResult{Name: name, UID: dbCfg.UID, Err: err})
+	}
+	return results
+}
+
+// OverlapProblems lays out every dashboard in order and reports panels whose
+// gridPos rectangles intersect, as errors when generator.check_overlaps
+// would fail the build and as warnings otherwise. Dashboards that don't
+// build are skipped; --config-check reports those.
+func (db *DashboardBuilder) OverlapProblems(dashboards map[string]config.DashboardConfig, order []string) []config.Problem {
+	sev := config.SeverityWarning
+	if db.Config.GetGenerator().CheckOverlaps {
+		sev = config.SeverityError
+	}
+	var problems []config.Problem
+	for _, name := range order {
+		dbCfg, ok := dashboards[name]
+		if !ok {
+			continue
+		}
+		db.Factory.IDGen.Reset()
+		db.Layout.Reset()
+		panels, err := db.buildPanels(dbCfg, nil)
+		if err != nil {
+			continue
+		}
+		for _, o := range FindOverlaps(panels) {
+			problems = append(problems, config.Problem{Dashboard: name, Path: "dashboards." + name + ".sections", Message: "panel " + o.String(), Severity: sev})
+		}
+	}
+	return problems
+}
+
+// MergeProblems folds error-severity validation problems into build
+// results: each joins its dashboard's error, and problems outside any
+// dashboard lead as a "config" result. Warnings are left out.
+func MergeProblems(results []CheckResult, problems []config.Problem) []CheckResult {
+	byDashboard := make(map[string][]error)
+	for _, p := range problems {
+		if p.Severity == config.SeverityError {
+			byDashboard[p.Dashboard] = append(byDashboard[p.Dashboard], fmt.Errorf("%s: %s", p.Path, p.Message))
+		}
+	}
+	var merged []CheckResult
+	if errs := byDashboard[""]; len(errs) > 0 {
+		merged = append(merged, CheckResult{Name: "config", Err: errors.Join(errs...)})
+	}
+	for _, r := range results {
+		if errs := byDashboard[r.Name]; len(errs) > 0 {
+			r.Err = errors.Join(append([]error{r.Err}, errs...)...)
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// ColorEnabled reports whether output may be colorized; any non-empty
+// NO_COLOR disables it (https://no-color.org).
+func ColorEnabled() bool {
+	return os.Getenv("NO_COLOR") == ""
+}
+
+// paint wraps s in the ANSI color code when color is set.
+func paint(color bool, code, s string) string {
+	if !color {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+// WriteConfigCheck prints one OK or ERROR line per result and returns the
+// number of failures. Multi-line errors are folded onto their line.
+func WriteConfigCheck(w io.Writer, results []CheckResult, color bool) int {
+	failed := 0
+	for _, r := range results {
+		name := r.Name
+		if r.UID != "" {
+			name += " (" + r.UID + ")"
+		}
+		if r.Err == nil {
+			fmt.Fprintf(w, "%s %s\n", paint(color, "32", "OK   "), name)
+			continue
+		}
+		failed++
+		msg := strings.Join(strings.Fields(strings.ReplaceAll(r.Err.Error(), "\n", "; ")), " ")
+		fmt.Fprintf(w, "%s %s: %s\n", paint(color, "31", "ERROR"), name, msg)
+	}
+	return failed
+}
+
+// WriteProblems prints validation problems grouped under their dashboard
+// (problems outside dashboards under "config") and returns the number of
+// error-severity problems.
+func WriteProblems(w io.Writer, problems []config.Problem, color bool) int {
+	errs := 0
+	group := "\x00"
+	for _, p := range problems {
+		if p.Dashboard != group {
+			group = p.Dashboard
+			if group == "" {
+				fmt.Fprintln(w, "config:")
+			} else {
+				fmt.Fprintf(w, "dashboard %s:\n", group)
+			}
+		}
+		label := paint(color, "33", "WARNING")
+		if p.Severity == config.SeverityError {
+			label = paint(color, "31", "ERROR  ")
+			errs++
+		}
+		fmt.Fprintf(w, "  %s %s: %s\n", label, p.Path, p.Message)
+	}
+	return errs
+}
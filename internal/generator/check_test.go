@@ -0,0 +1,190 @@
+package generator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+)
+
+func TestConfigCheck(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+dashboards:
+  good: { uid: good, title: good }
+  bad:
+    uid: bad
+    title: bad
+    sections:
+      - { title: per foo, repeat: foo, panels: [] }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs, _ := cfg.GetDashboards("")
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+	// the undefined repeat only warns at build; validate reports it
+	results := MergeProblems(builder.CheckDashboards(dbs, []string{"good", "bad"}, nil, nil), cfg.Validate())
+
+	t.Setenv("NO_COLOR", "1")
+	var buf bytes.Buffer
+	if failed := WriteConfigCheck(&buf, results, ColorEnabled()); failed != 1 {
+		t.Errorf("failed = %d, want 1", failed)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines = %q, want one per dashboard", lines)
+	}
+	if !strings.HasPrefix(lines[0], "OK") || !strings.Contains(lines[0], "good") {
+		t.Errorf("line 0 = %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "ERROR") || !strings.Contains(lines[1], "repeats over 'foo'") {
+		t.Errorf("line 1 = %q", lines[1])
+	}
+	if strings.Contains(buf.String(), "\033[") {
+		t.Error("NO_COLOR output contains escape codes")
+	}
+
+	t.Setenv("NO_COLOR", "")
+	buf.Reset()
+	WriteConfigCheck(&buf, results, ColorEnabled())
+	if !strings.Contains(buf.String(), "\033[31mERROR") {
+		t.Errorf("colored output = %q, want red ERROR", buf.String())
+	}
+}
+
+func TestWriteProblems(t *testing.T) {
+	problems := []config.Problem{
+		{Path: "variables.node", Message: "variable is not used by any dashboard", Severity: config.SeverityWarning},
+		{Dashboard: "bad", Path: "dashboards.bad.variables[0]", Message: "variable 'x' is not defined", Severity: config.SeverityError},
+		{Dashboard: "bad", Path: "dashboards.bad.sections[0].panels[0].datasource", Message: "datasource 'y' is not defined", Severity: config.SeverityError},
+	}
+	var buf bytes.Buffer
+	if errs := WriteProblems(&buf, problems, false); errs != 2 {
+		t.Errorf("errors = %d, want 2", errs)
+	}
+	want := "config:\n" +
+		"  WARNING variables.node: variable is not used by any dashboard\n" +
+		"dashboard bad:\n" +
+		"  ERROR   dashboards.bad.variables[0]: variable 'x' is not defined\n" +
+		"  ERROR   dashboards.bad.sections[0].panels[0].datasource: datasource 'y' is not defined\n"
+	if buf.String() != want {
+		t.Errorf("output =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestMergeProblems(t *testing.T) {
+	results := []CheckResult{{Name: "good", UID: "good"}, {Name: "bad", UID: "bad"}}
+	problems := []config.Problem{
+		{Path: "active_palette", Message: "palette 'x' is not defined", Severity: config.SeverityError},
+		{Path: "variables.node", Message: "variable is not used by any dashboard", Severity: config.SeverityWarning},
+		{Dashboard: "bad", Path: "dashboards.bad.variables[0]", Message: "variable 'x' is not defined", Severity: config.SeverityError},
+	}
+	var buf bytes.Buffer
+	if failed := WriteConfigCheck(&buf, MergeProblems(results, problems), false); failed != 2 {
+		t.Errorf("failed = %d, want 2", failed)
+	}
+	want := "ERROR config: active_palette: palette 'x' is not defined\n" +
+		"OK    good (good)\n" +
+		"ERROR bad (bad): dashboards.bad.variables[0]: variable 'x' is not defined\n"
+	if buf.String() != want {
+		t.Errorf("output =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestOverlapProblems(t *testing.T) {
+	load := func(gen string) (*DashboardBuilder, map[string]config.DashboardConfig) {
+		t.Helper()
+		cfg, err := config.LoadFromBytes([]byte(gen + `
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+dashboards:
+  manual:
+    uid: manual
+    title: manual
+    sections:
+      - title: one
+        panels:
+          - { type: stat, title: left, query: up, x: 0, y: 1, width: 12, height: 4 }
+      - title: two
+        panels:
+          - { type: stat, title: right, query: up, x: 8, y: 2, width: 12, height: 4 }
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dbs, _ := cfg.GetDashboards("")
+		return NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine()), dbs
+	}
+
+	find := func(problems []config.Problem) *config.Problem {
+		for i, p := range problems {
+			if strings.Contains(p.Message, "'left' overlaps 'right'") {
+				return &problems[i]
+			}
+		}
+		return nil
+	}
+
+	builder, dbs := load("")
+	p := find(builder.OverlapProblems(dbs, []string{"manual"}))
+	if p == nil || p.Dashboard != "manual" || p.Severity != config.SeverityWarning {
+		t.Errorf("problem = %+v, want warning naming left and right", p)
+	}
+
+	// with the build gate on, validate reports overlaps as errors
+	builder, dbs = load("generator:\n  check_overlaps: true\n")
+	p = find(builder.OverlapProblems(dbs, []string{"manual"}))
+	if p == nil || p.Severity != config.SeverityError {
+		t.Errorf("check_overlaps problem = %+v, want error", p)
+	}
+}
+
+func TestUnitProblems(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+dashboards:
+  net:
+    uid: net
+    title: net
+    sections:
+      - title: traffic
+        panels:
+          - { type: stat, title: ok, query: up, unit: bytes }
+          - type: timeseries
+            title: rx
+            query: up
+            unit: bites
+            unit_overrides: { rate: byts }
+            axis_overrides:
+              - { series_regex: errors, unit: percent }
+              - { series_regex: drops, unit: pps2 }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs, _ := cfg.GetDashboards("")
+	got := map[string]string{}
+	for _, p := range UnitProblems(dbs, []string{"net"}) {
+		if p.Dashboard != "net" || p.Severity != config.SeverityWarning {
+			t.Errorf("problem = %+v, want a warning on net", p)
+		}
+		got[p.Path] = p.Message
+	}
+	want := map[string]string{
+		"dashboards.net.sections[0].panels[1].unit":                   "unknown unit 'bites'",
+		"dashboards.net.sections[0].panels[1].unit_overrides.rate":    "unknown unit 'byts'",
+		"dashboards.net.sections[0].panels[1].axis_overrides[1].unit": "unknown unit 'pps2'",
+	}
+	if len(got) != len(want) {
+		t.Errorf("problems = %v, want %v", got, want)
+	}
+	for path, msg := range want {
+		if got[path] != msg {
+			t.Errorf("%s = %q, want %q", path, got[path], msg)
+		}
+	}
+}
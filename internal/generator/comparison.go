@@ -0,0 +1,66 @@
+package generator
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// ComparisonFormats lists the supported output formats for WriteComparison.
+var ComparisonFormats = []string{"csv", "markdown"}
+
+// WriteComparison renders CompareAll results as one row per metric with a
+// membership column per datasource. Shared metrics come first, then each
+// datasource's exclusive metrics in dsNames order.
+func WriteComparison(w io.Writer, format string, dsNames []string, shared map[string]MetricInfo, exclusive map[string]map[string]MetricInfo) error {
+	header := append([]string{"metric", "type", "scope"}, dsNames...)
+	var rows [][]string
+	for _, m := range sortedKeys(shared) {
+		row := []string{m, shared[m].Type, "shared"}
+		for range dsNames {
+			row = append(row, "yes")
+		}
+		rows = append(rows, row)
+	}
+	for _, ds := range dsNames {
+		for _, m := range sortedKeys(exclusive[ds]) {
+			row := []string{m, exclusive[ds][m].Type, "exclusive"}
+			for _, other := range dsNames {
+				if other == ds {
+					row = append(row, "yes")
+				} else {
+					row = append(row, "")
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	switch format {
+	case "csv", "":
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.WriteAll(rows)
+		return cw.Error()
+	case "markdown":
+		fmt.Fprintf(w, "| %s |\n", strings.Join(header, " | "))
+		fmt.Fprintf(w, "|%s\n", strings.Repeat("---|", len(header)))
+		for _, row := range rows {
+			fmt.Fprintf(w, "| %s |\n", strings.Join(row, " | "))
+		}
+		return nil
+	default:
+		return ValidComparisonFormat(format)
+	}
+}
+
+// ValidComparisonFormat returns an error unless format is one of
+// ComparisonFormats.
+func ValidComparisonFormat(format string) error {
+	if !slices.Contains(ComparisonFormats, format) {
+		return fmt.Errorf("unknown comparison format '%s' (want %s)", format, strings.Join(ComparisonFormats, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package generator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteComparisonCSV(t *testing.T) {
+	shared := map[string]MetricInfo{"up": {Type: "gauge"}}
+	exclusive := map[string]map[string]MetricInfo{
+		"a": {"node_load1": {Type: "gauge"}},
+		"b": {"http_requests_total": {Type: "counter"}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteComparison(&buf, "csv", []string{"a", "b"}, shared, exclusive); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"metric,type,scope,a,b",
+		"up,gauge,shared,yes,yes",
+		"node_load1,gauge,exclusive,yes,",
+		"http_requests_total,counter,exclusive,,yes",
+	}, "\n") + "\n"
+	if buf.String() != want {
+		t.Errorf("csv =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := WriteComparison(&buf, "markdown", []string{"a", "b"}, shared, exclusive); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "| node_load1 | gauge | exclusive | yes |  |") {
+		t.Errorf("markdown missing exclusive row:\n%s", buf.String())
+	}
+
+	if err := WriteComparison(&buf, "xml", nil, nil, nil); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if err := ValidComparisonFormat("markdown"); err != nil {
+		t.Errorf("ValidComparisonFormat(markdown) = %v", err)
+	}
+	if err := ValidComparisonFormat("xml"); err == nil {
+		t.Error("ValidComparisonFormat accepted xml")
+	}
+}
@@ -0,0 +1,159 @@
+package generator
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigChange is one added, removed or changed entry between two configs.
+// Kind is "datasource", "variable", "dashboard" or "panel"; panels are named
+// "<dashboard>/<section>/<title>".
+type ConfigChange struct {
+	Kind    string   `json:"kind"`
+	Name    string   `json:"name"`
+	Status  string   `json:"status"` // "added", "removed" or "changed"
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// DiffConfigs compares two configs structurally, so key order, formatting
+// and comments don't matter. Dashboards are compared after extends is
+// resolved, and their panels are matched by section and title.
+func DiffConfigs(oldCfg, newCfg *config.Config) ([]ConfigChange, error) {
+	var result []ConfigChange
+	add := func(kind string, o, n map[string]interface{}) error {
+		changes, err := diffNamed(kind, o, n)
+		result = append(result, changes...)
+		return err
+	}
+	if err := add("datasource", toAny(oldCfg.Datasources), toAny(newCfg.Datasources)); err != nil {
+		return nil, err
+	}
+	if err := add("variable", toAny(oldCfg.Variables), toAny(newCfg.Variables)); err != nil {
+		return nil, err
+	}
+
+	oldDBs, err := oldCfg.GetDashboards("")
+	if err != nil {
+		return nil, fmt.Errorf("old config: %w", err)
+	}
+	newDBs, err := newCfg.GetDashboards("")
+	if err != nil {
+		return nil, fmt.Errorf("new config: %w", err)
+	}
+	oldHeads, newHeads := map[string]interface{}{}, map[string]interface{}{}
+	oldPanels, newPanels := map[string]interface{}{}, map[string]interface{}{}
+	for name, db := range oldDBs {
+		oldHeads[name] = dashboardHead(db)
+		collectPanels(name, db, oldPanels)
+	}
+	for name, db := range newDBs {
+		newHeads[name] = dashboardHead(db)
+		collectPanels(name, db, newPanels)
+	}
+	if err := add("dashboard", oldHeads, newHeads); err != nil {
+		return nil, err
+	}
+	if err := add("panel", oldPanels, newPanels); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func toAny[V any](m map[string]V) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// dashboardHead is a dashboard without its sections, whose panels are
+// compared separately.
+func dashboardHead(db config.DashboardConfig) config.DashboardConfig {
+	db.Sections = nil
+	return db
+}
+
+// collectPanels keys each panel by dashboard, section and title, numbering
+// repeated titles so they still pair up in order.
+func collectPanels(dbName string, db config.DashboardConfig, into map[string]interface{}) {
+	for _, sec := range db.Sections {
+		for i, p := range sec.Panels {
+			title := getString(p, "title", fmt.Sprintf("#%d", i+1))
+			key := dbName + "/" + sec.Title + "/" + title
+			for n := 2; ; n++ {
+				if _, dup := into[key]; !dup {
+					break
+				}
+				key = fmt.Sprintf("%s/%s/%s (%d)", dbName, sec.Title, title, n)
+			}
+			into[key] = p
+		}
+	}
+}
+
+func diffNamed(kind string, prev, next map[string]interface{}) ([]ConfigChange, error) {
+	names := map[string]bool{}
+	for k := range prev {
+		names[k] = true
+	}
+	for k := range next {
+		names[k] = true
+	}
+	var result []ConfigChange
+	for _, name := range sortedKeys(names) {
+		ov, inOld := prev[name]
+		nv, inNew := next[name]
+		switch {
+		case !inOld:
+			result = append(result, ConfigChange{Kind: kind, Name: name, Status: "added"})
+		case !inNew:
+			result = append(result, ConfigChange{Kind: kind, Name: name, Status: "removed"})
+		default:
+			o, err := normalizeYAML(ov)
+			if err != nil {
+				return nil, err
+			}
+			n, err := normalizeYAML(nv)
+			if err != nil {
+				return nil, err
+			}
+			if changes := diffValues("", o, n, nil); len(changes) > 0 {
+				result = append(result, ConfigChange{Kind: kind, Name: name, Status: "changed", Changes: changes})
+			}
+		}
+	}
+	return result, nil
+}
+
+// normalizeYAML round-trips a config value through YAML so structs compare
+// by their config keys.
+func normalizeYAML(v interface{}) (interface{}, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling config value: %w", err)
+	}
+	var out interface{}
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("unmarshaling config value: %w", err)
+	}
+	return out, nil
+}
+
+// WriteConfigDiff prints one line per added (+), removed (-) or changed (~)
+// entry, followed by the changed keys.
+func WriteConfigDiff(w io.Writer, changes []ConfigChange) error {
+	marks := map[string]string{"added": "+", "removed": "-", "changed": "~"}
+	for _, c := range changes {
+		if _, err := fmt.Fprintf(w, "%s %s %s\n", marks[c.Status], c.Kind, c.Name); err != nil {
+			return err
+		}
+		for _, ch := range c.Changes {
+			fmt.Fprintf(w, "    %s: %s -> %s\n", ch.Path, compactJSON(ch.Old), compactJSON(ch.New))
+		}
+	}
+	return nil
+}
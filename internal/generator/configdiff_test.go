@@ -0,0 +1,92 @@
+package generator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	load := func(body string) *config.Config {
+		t.Helper()
+		cfg, err := config.LoadFromBytes([]byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	old := load(`
+datasources:
+  prom: {type: prometheus, uid: prom}
+dashboards:
+  node:
+    uid: node
+    title: Node
+    sections:
+      - title: memory
+        panels:
+          - {type: stat, title: used, query: up, unit: bytes}
+`)
+	// same content with reordered keys and a comment
+	same := load(`
+dashboards:
+  node:
+    title: Node
+    uid: node
+    sections:
+      - panels:
+          - {unit: bytes, query: up, title: used, type: stat}  # comment
+        title: memory
+datasources:
+  prom: {uid: prom, type: prometheus}
+`)
+	changed := load(`
+datasources:
+  prom: {type: prometheus, uid: prom}
+dashboards:
+  node:
+    uid: node
+    title: Node
+    sections:
+      - title: memory
+        panels:
+          - {type: stat, title: used, query: up, unit: decbytes}
+  disk:
+    uid: disk
+    title: Disk
+`)
+
+	changes, err := DiffConfigs(old, same)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("reordered config changes = %+v, want none", changes)
+	}
+
+	changes, err = DiffConfigs(old, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("changes = %+v, want 2", changes)
+	}
+	if c := changes[0]; c.Kind != "dashboard" || c.Name != "disk" || c.Status != "added" {
+		t.Errorf("changes[0] = %+v, want added dashboard disk", c)
+	}
+	c := changes[1]
+	if c.Kind != "panel" || c.Name != "node/memory/used" || c.Status != "changed" ||
+		len(c.Changes) != 1 || c.Changes[0].Path != "unit" || c.Changes[0].New != "decbytes" {
+		t.Errorf("changes[1] = %+v, want panel unit bytes -> decbytes", c)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteConfigDiff(&buf, changes); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, "+ dashboard disk") || !strings.Contains(out, `unit: "bytes" -> "decbytes"`) {
+		t.Errorf("output = %q", out)
+	}
+}
@@ -0,0 +1,105 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigMapBundleFile is the file generate --output-format configmap
+// --configmap-bundle writes every manifest to.
+const ConfigMapBundleFile = "dashboards-configmap.yaml"
+
+// configMapNameRe matches runs of characters not allowed in a ConfigMap name.
+var configMapNameRe = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// ConfigMapEntry is one dashboard to wrap; Key is the data key, normally the
+// dashboard's JSON filename.
+type ConfigMapEntry struct {
+	Key       string
+	Dashboard map[string]interface{}
+}
+
+type configMapMetadata struct {
+	Name   string            `yaml:"name"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+type configMap struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   configMapMetadata `yaml:"metadata"`
+	Data       map[string]string `yaml:"data"`
+}
+
+// ConfigMapName derives a Kubernetes object name from a data key:
+// grafana-dashboard-<key without .json>, lowercased.
+func ConfigMapName(key string) string {
+	base := strings.TrimSuffix(strings.ToLower(key), ".json")
+	base = strings.Trim(configMapNameRe.ReplaceAllString(base, "-"), "-.")
+	return "grafana-dashboard-" + base
+}
+
+// ConfigMapManifest wraps a dashboard's JSON in a ConfigMap carrying the
+// grafana_dashboard: "1" label the Grafana sidecar watches. It returns the
+// manifest and the size of the embedded JSON.
+func ConfigMapManifest(entry ConfigMapEntry) ([]byte, int, error) {
+	data, err := json.MarshalIndent(entry.Dashboard, "", "  ")
+	if err != nil {
+		return nil, 0, fmt.Errorf("marshaling dashboard: %w", err)
+	}
+	data = append(data, '\n')
+	cm := configMap{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata: configMapMetadata{
+			Name:   ConfigMapName(entry.Key),
+			Labels: map[string]string{"grafana_dashboard": "1"},
+		},
+		Data: map[string]string{entry.Key: string(data)},
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(cm); err != nil {
+		return nil, 0, fmt.Errorf("marshaling configmap: %w", err)
+	}
+	return buf.Bytes(), len(data), nil
+}
+
+// WriteConfigMaps writes one ConfigMap per entry to fpath as a multi-document
+// YAML file, reporting each entry on out, and returns the JSON size of each.
+// Entries over the 750KB ConfigMap limit are warned about on stderr.
+func WriteConfigMaps(entries []ConfigMapEntry, fpath string, dryRun bool, out io.Writer, mode os.FileMode) ([]int, error) {
+	var buf bytes.Buffer
+	sizes := make([]int, 0, len(entries))
+	for i, entry := range entries {
+		manifest, size, err := ConfigMapManifest(entry)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(manifest)
+		sizes = append(sizes, size)
+		warnConfigMapSize(entry.Key, size)
+		fmt.Fprintf(out, "  %s (%s): %d panels, %s bytes\n", entry.Key, filepath.Base(fpath), countPanels(entry.Dashboard), formatSize(size))
+	}
+	if !dryRun {
+		if err := os.WriteFile(fpath, buf.Bytes(), mode); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", fpath, err)
+		}
+		if err := os.Chmod(fpath, mode); err != nil {
+			return nil, fmt.Errorf("setting mode on %s: %w", fpath, err)
+		}
+	}
+	return sizes, nil
+}
@@ -1,7 +1,12 @@
 package generator
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/wcatz/dashboard-generator/internal/config"
 )
@@ -46,12 +51,18 @@ func (db *DashboardBuilder) BuildVariable(name string) (map[string]interface{},
 	if !ok {
 		return nil, fmt.Errorf("variable '%s' not defined in config", name)
 	}
+	if err := v.CheckRegex(); err != nil {
+		return nil, fmt.Errorf("variable '%s': %w", name, err)
+	}
 
 	vtype := v.Type
 	if vtype == "" {
 		vtype = "query"
 	}
 	query := db.Config.ResolveRef(v.Query)
+	if len(v.ChainsFrom) > 0 && vtype == "query" {
+		query = db.chainQuery(name, query, v.ChainsFrom)
+	}
 	label := v.Label
 	if label == "" {
 		label = name
@@ -104,10 +115,24 @@ func (db *DashboardBuilder) BuildVariable(name string) (map[string]interface{},
 	if v.AllValue != "" {
 		varDef["allValue"] = v.AllValue
 	}
+	if v.Description != "" {
+		varDef["description"] = v.Description
+	}
+	if v.AllowCustomValue != nil {
+		varDef["allowCustomValue"] = *v.AllowCustomValue
+	}
 
 	switch vtype {
 	case "custom":
 		varDef["query"] = v.Values
+		if v.ValuesFile != "" {
+			values, err := readValuesFile(db.Config.ResolvePath(v.ValuesFile))
+			if err != nil {
+				return nil, fmt.Errorf("variable '%s': %w", name, err)
+			}
+			varDef["query"] = customQuery(values)
+			varDef["options"] = customOptions(values, current)
+		}
 		delete(varDef, "datasource")
 		delete(varDef, "definition")
 	case "datasource":
@@ -142,6 +167,95 @@ func (db *DashboardBuilder) BuildVariable(name string) (map[string]interface{},
 	return varDef, nil
 }
 
+// matcherLabelRe captures the label name of each matcher in a series
+// selector's brace set.
+var matcherLabelRe = regexp.MustCompile(`(^|[\s,{])([a-zA-Z_]\w*)\s*(=|!=|=~|!~)`)
+
+// chainQuery adds a matcher per upstream variable to a label_values query so
+// its options follow the upstream selection. Matchers go into the series'
+// existing brace set, or a new one; labels the query already matches on are
+// left alone. Multi-value and include-all upstreams match with =~.
+func (db *DashboardBuilder) chainQuery(name, query string, chains []string) string {
+	m := labelValuesRe.FindStringSubmatch(query)
+	if m == nil {
+		fmt.Fprintf(os.Stderr, "  warning: variable '%s': chains_from needs a label_values(...) query, leaving it unchanged\n", name)
+		return query
+	}
+	series, label := m[1], m[2]
+	metric, matchers := series, ""
+	if i := strings.Index(series, "{"); i >= 0 && strings.HasSuffix(series, "}") {
+		metric, matchers = series[:i], strings.TrimSpace(series[i+1:len(series)-1])
+	}
+
+	matched := make(map[string]bool)
+	for _, lm := range matcherLabelRe.FindAllStringSubmatch(matchers, -1) {
+		matched[lm[2]] = true
+	}
+	var added []string
+	for _, up := range chains {
+		if matched[up] {
+			continue
+		}
+		op := "="
+		if def, ok := db.Config.GetVariableDef(up); ok && (def.Multi || def.IncludeAll) {
+			op = "=~"
+		}
+		added = append(added, fmt.Sprintf(`%s%s"$%s"`, up, op, up))
+	}
+	if len(added) == 0 {
+		return query
+	}
+	if matchers != "" {
+		added = append([]string{matchers}, added...)
+	}
+	return fmt.Sprintf("label_values(%s{%s}, %s)", metric, strings.Join(added, ", "), label)
+}
+
+// readValuesFile reads custom variable values, one per line. Blank lines and
+// lines starting with # are skipped.
+func readValuesFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading values_file: %w", err)
+	}
+	var values []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		values = append(values, line)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("values_file %s has no values", path)
+	}
+	return values, nil
+}
+
+// customQuery joins values into a custom variable query, escaping commas
+// the way Grafana expects.
+func customQuery(values []string) string {
+	escaped := make([]string, len(values))
+	for i, v := range values {
+		escaped[i] = strings.ReplaceAll(v, ",", "\\,")
+	}
+	return strings.Join(escaped, ",")
+}
+
+// customOptions pre-populates the option list so the values are selectable
+// before Grafana re-parses the query.
+func customOptions(values []string, current map[string]interface{}) []interface{} {
+	options := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		options = append(options, map[string]interface{}{
+			"selected": v == current["value"],
+			"text":     v,
+			"value":    v,
+		})
+	}
+	return options
+}
+
 // BuildVariables creates variable dicts for a list of names.
 func (db *DashboardBuilder) BuildVariables(varNames []string) ([]interface{}, error) {
 	var vars []interface{}
@@ -162,22 +276,54 @@ func (db *DashboardBuilder) BuildVariables(varNames []string) ([]interface{}, er
 func (db *DashboardBuilder) BuildSection(section config.SectionConfig) ([]interface{}, error) {
 	var panels []interface{}
 
+	enabled := section.Enabled == nil || *section.Enabled
+	ok, err := db.included(enabled, section.When)
+	if err != nil {
+		return nil, fmt.Errorf("section '%s': %w", section.Title, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	section.Panels, err = db.includedPanels(section.Panels)
+	if err != nil {
+		return nil, fmt.Errorf("section '%s': %w", section.Title, err)
+	}
+
+	var slots []config.LayoutSlot
+	if section.LayoutTemplate != "" {
+		slots, err = db.Config.GetLayout(section.LayoutTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("section '%s': %w", section.Title, err)
+		}
+	}
+
+	// size each config entry from its layout slot, then expand companions
+	var cfgs []map[string]interface{}
+	for i, pcfg := range section.Panels {
+		for _, c := range withLogVolume(applyLayoutSlot(pcfg, slots, i)) {
+			// defaults here too so a default width/height reaches the layout
+			cfgs = append(cfgs, db.Factory.WithDefaults(c))
+		}
+	}
+
 	if section.Collapsed {
-		innerLayout := NewLayoutEngine()
+		innerLayout := NewLayoutEngineWidth(db.Layout.GridWidth)
 		var innerPanels []interface{}
-		for _, pcfg := range section.Panels {
-			ptype := getString(pcfg, "type", "")
-			ds := DefaultSizes[ptype]
-			if ds == [2]int{} {
-				ds = [2]int{6, 4}
+		var placed []gridRect
+		for _, pcfg := range cfgs {
+			sized, w, h, err := innerLayout.panelSize(pcfg)
+			if err != nil {
+				return nil, fmt.Errorf("panel '%s': %w", getString(pcfg, "title", "?"), err)
 			}
-			w := getInt(pcfg, "width", ds[0])
-			h := getInt(pcfg, "height", ds[1])
+			pcfg = sized
 
 			var px, py int
 			if hasKey(pcfg, "x") && hasKey(pcfg, "y") {
 				px = getInt(pcfg, "x", 0)
 				py = getInt(pcfg, "y", 0)
+				placed = append(placed, gridRect{getString(pcfg, "title", "?"), px, py, w, h})
+			} else if getString(pcfg, "repeat", "") != "" {
+				px, py = innerLayout.PlaceAlone(w, h)
 			} else {
 				px, py = innerLayout.Place(w, h)
 			}
@@ -188,28 +334,33 @@ func (db *DashboardBuilder) BuildSection(section config.SectionConfig) ([]interf
 			}
 			innerPanels = append(innerPanels, panel)
 		}
+		if err := innerLayout.validatePlaced(placed); err != nil {
+			return nil, fmt.Errorf("section '%s': %w", section.Title, err)
+		}
 
 		rowY := db.Layout.AddRow()
 		innerPanelIfaces := make([]interface{}, len(innerPanels))
 		copy(innerPanelIfaces, innerPanels)
-		panels = append(panels, db.Factory.Row(section.Title, rowY, true, innerPanelIfaces, section.Repeat))
+		panels = append(panels, db.row(section.Title, rowY, true, innerPanelIfaces, section.Repeat))
 	} else {
 		rowY := db.Layout.AddRow()
-		panels = append(panels, db.Factory.Row(section.Title, rowY, false, nil, section.Repeat))
+		panels = append(panels, db.row(section.Title, rowY, false, nil, section.Repeat))
 
-		for _, pcfg := range section.Panels {
-			ptype := getString(pcfg, "type", "")
-			ds := DefaultSizes[ptype]
-			if ds == [2]int{} {
-				ds = [2]int{6, 4}
+		var placed []gridRect
+		for _, pcfg := range cfgs {
+			sized, w, h, err := db.Layout.panelSize(pcfg)
+			if err != nil {
+				return nil, fmt.Errorf("panel '%s': %w", getString(pcfg, "title", "?"), err)
 			}
-			w := getInt(pcfg, "width", ds[0])
-			h := getInt(pcfg, "height", ds[1])
+			pcfg = sized
 
 			var px, py int
 			if hasKey(pcfg, "x") && hasKey(pcfg, "y") {
 				px = getInt(pcfg, "x", 0)
 				py = getInt(pcfg, "y", 0)
+				placed = append(placed, gridRect{getString(pcfg, "title", "?"), px, py, w, h})
+			} else if getString(pcfg, "repeat", "") != "" {
+				px, py = db.Layout.PlaceAlone(w, h)
 			} else {
 				px, py = db.Layout.Place(w, h)
 			}
@@ -220,6 +371,9 @@ func (db *DashboardBuilder) BuildSection(section config.SectionConfig) ([]interf
 			}
 			panels = append(panels, panel)
 		}
+		if err := db.Layout.validatePlaced(placed); err != nil {
+			return nil, fmt.Errorf("section '%s': %w", section.Title, err)
+		}
 
 		db.Layout.FinishSection()
 	}
@@ -227,6 +381,169 @@ func (db *DashboardBuilder) BuildSection(section config.SectionConfig) ([]interf
 	return panels, nil
 }
 
+// row creates a section's row panel spanning the layout's grid width.
+func (db *DashboardBuilder) row(title string, y int, collapsed bool, panels []interface{}, repeat string) map[string]interface{} {
+	row := db.Factory.Row(title, y, collapsed, panels, repeat)
+	row["gridPos"].(map[string]interface{})["w"] = db.Layout.GridWidth
+	return row
+}
+
+// withLogVolume expands a logs panel with with_volume: true into a bar-style
+// timeseries of sum(count_over_time(...[$__interval])) per query, followed
+// by the logs panel itself. The volume panel matches the logs width and is
+// volume_height tall (default 5); explicit x/y shift the logs panel below it.
+func withLogVolume(pcfg map[string]interface{}) []map[string]interface{} {
+	if getString(pcfg, "type", "") != "logs" || !getBool(pcfg, "with_volume", false) {
+		return []map[string]interface{}{pcfg}
+	}
+	vh := getInt(pcfg, "volume_height", 5)
+	var targets []interface{}
+	if q := getString(pcfg, "query", ""); q != "" {
+		targets = append(targets, map[string]interface{}{
+			"expr": fmt.Sprintf("sum(count_over_time(%s [$__interval]))", q), "legend": "volume",
+		})
+	}
+	list, _ := pcfg["targets"].([]interface{})
+	for _, raw := range list {
+		item, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		t := map[string]interface{}{
+			"expr":   fmt.Sprintf("sum(count_over_time(%s [$__interval]))", getString(item, "expr", "")),
+			"legend": getString(item, "legend", "volume"),
+		}
+		if ds, ok := item["datasource"]; ok {
+			t["datasource"] = ds
+		}
+		targets = append(targets, t)
+	}
+	volume := map[string]interface{}{
+		"type":         "timeseries",
+		"title":        getString(pcfg, "title", "logs") + " volume",
+		"targets":      targets,
+		"draw_style":   "bars",
+		"fill_opacity": 80,
+		"show_legend":  false,
+		"unit":         "short",
+		"width":        getInt(pcfg, "width", DefaultSizes["logs"][0]),
+		"height":       vh,
+	}
+	for _, k := range []string{"datasource", "description", "transparent"} {
+		if v, ok := pcfg[k]; ok {
+			volume[k] = v
+		}
+	}
+	logs := make(map[string]interface{}, len(pcfg))
+	for k, v := range pcfg {
+		logs[k] = v
+	}
+	if hasKey(pcfg, "x") && hasKey(pcfg, "y") {
+		volume["x"], volume["y"] = pcfg["x"], pcfg["y"]
+		logs["y"] = getInt(pcfg, "y", 0) + vh
+	}
+	return []map[string]interface{}{volume, logs}
+}
+
+// timeRangePresets are the names accepted by time_range in place of a
+// from/to map.
+var timeRangePresets = map[string][2]string{
+	"last_5m":    {"now-5m", "now"},
+	"last_15m":   {"now-15m", "now"},
+	"last_30m":   {"now-30m", "now"},
+	"last_1h":    {"now-1h", "now"},
+	"last_3h":    {"now-3h", "now"},
+	"last_6h":    {"now-6h", "now"},
+	"last_12h":   {"now-12h", "now"},
+	"last_24h":   {"now-24h", "now"},
+	"last_2d":    {"now-2d", "now"},
+	"last_7d":    {"now-7d", "now"},
+	"last_30d":   {"now-30d", "now"},
+	"last_90d":   {"now-90d", "now"},
+	"today":      {"now/d", "now/d"},
+	"yesterday":  {"now-1d/d", "now-1d/d"},
+	"this_week":  {"now/w", "now/w"},
+	"this_month": {"now/M", "now/M"},
+}
+
+// ResolveTimeRange returns the dashboard time as a from/to map, expanding a
+// preset name and defaulting to the last 30 minutes.
+func ResolveTimeRange(tr config.TimeRange) (map[string]string, error) {
+	if tr == nil {
+		return map[string]string{"from": "now-30m", "to": "now"}, nil
+	}
+	name, ok := tr["preset"]
+	if !ok {
+		return tr, nil
+	}
+	p, ok := timeRangePresets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown time_range preset '%s' (want one of %s)", name, strings.Join(sortedKeys(timeRangePresets), ", "))
+	}
+	return map[string]string{"from": p[0], "to": p[1]}, nil
+}
+
+// includedPanels drops panels switched off by enabled: false or a false when.
+func (db *DashboardBuilder) includedPanels(cfgs []map[string]interface{}) ([]map[string]interface{}, error) {
+	var out []map[string]interface{}
+	for _, pcfg := range cfgs {
+		ok, err := db.included(getBool(pcfg, "enabled", true), getString(pcfg, "when", ""))
+		if err != nil {
+			return nil, fmt.Errorf("panel '%s': %w", getString(pcfg, "title", "?"), err)
+		}
+		if ok {
+			out = append(out, pcfg)
+		}
+	}
+	return out, nil
+}
+
+// included evaluates enabled and an optional when condition of the form
+// "a==b" or "a!=b". Each side may use ${ENV:NAME} for environment variables
+// and the usual ${constant} refs; both are compared as trimmed strings.
+func (db *DashboardBuilder) included(enabled bool, when string) (bool, error) {
+	if !enabled {
+		return false, nil
+	}
+	if when == "" {
+		return true, nil
+	}
+	op := "=="
+	lhs, rhs, found := strings.Cut(when, op)
+	if !found {
+		op = "!="
+		lhs, rhs, found = strings.Cut(when, op)
+	}
+	if !found {
+		return false, fmt.Errorf("when '%s': expected 'a==b' or 'a!=b'", when)
+	}
+	resolve := func(side string) string {
+		return strings.TrimSpace(db.Config.ResolveRef(side))
+	}
+	equal := resolve(lhs) == resolve(rhs)
+	return equal == (op == "=="), nil
+}
+
+// applyLayoutSlot sizes the i-th panel of a section from its layout template
+// slot. Explicit width/height on the panel still win. The panel config is
+// copied, not modified.
+func applyLayoutSlot(pcfg map[string]interface{}, slots []config.LayoutSlot, i int) map[string]interface{} {
+	if i >= len(slots) {
+		return pcfg
+	}
+	out := make(map[string]interface{}, len(pcfg)+2)
+	for k, v := range pcfg {
+		out[k] = v
+	}
+	if _, ok := out["width"]; !ok && slots[i].W > 0 {
+		out["width"] = slots[i].W
+	}
+	if _, ok := out["height"]; !ok && slots[i].H > 0 {
+		out["height"] = slots[i].H
+	}
+	return out
+}
+
 // Build assembles a complete Grafana dashboard.
 func (db *DashboardBuilder) Build(dbCfg config.DashboardConfig, navLinks []interface{}, discoverySections []config.SectionConfig) (map[string]interface{}, error) {
 	db.Factory.IDGen.Reset()
@@ -238,26 +555,21 @@ func (db *DashboardBuilder) Build(dbCfg config.DashboardConfig, navLinks []inter
 	if err != nil {
 		return nil, err
 	}
+	db.checkRepeats(dbCfg)
 
-	var allPanels []interface{}
-	for _, section := range dbCfg.Sections {
-		panels, err := db.BuildSection(section)
-		if err != nil {
-			return nil, err
-		}
-		allPanels = append(allPanels, panels...)
+	allPanels, err := db.buildPanels(dbCfg, discoverySections)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, section := range discoverySections {
-		panels, err := db.BuildSection(section)
-		if err != nil {
-			return nil, err
+	if gen.CheckOverlaps {
+		if overlaps := FindOverlaps(allPanels); len(overlaps) > 0 {
+			msgs := make([]string, len(overlaps))
+			for i, o := range overlaps {
+				msgs[i] = o.String()
+			}
+			return nil, fmt.Errorf("dashboard '%s': overlapping panels: %s", dbCfg.Title, strings.Join(msgs, "; "))
 		}
-		allPanels = append(allPanels, panels...)
-	}
-
-	if allPanels == nil {
-		allPanels = []interface{}{}
 	}
 
 	editable := true
@@ -268,41 +580,49 @@ func (db *DashboardBuilder) Build(dbCfg config.DashboardConfig, navLinks []inter
 	if gen.LiveNow != nil {
 		liveNow = *gen.LiveNow
 	}
-	refresh := gen.Refresh
-	if refresh == "" {
-		refresh = "30s"
-	}
+	refresh := defaultStr(dbCfg.Refresh, defaultStr(gen.Refresh, "30s"))
 	schemaVersion := gen.SchemaVersion
 	if schemaVersion == 0 {
 		schemaVersion = 39
 	}
-	timeRange := gen.TimeRange
-	if timeRange == nil {
-		timeRange = map[string]string{"from": "now-30m", "to": "now"}
+	tr := gen.TimeRange
+	if len(dbCfg.TimeRange) > 0 {
+		tr = dbCfg.TimeRange
+	}
+	timeRange, err := ResolveTimeRange(tr)
+	if err != nil {
+		return nil, err
 	}
 	graphTooltip := gen.GraphTooltip
 	if graphTooltip == 0 {
 		graphTooltip = 1
 	}
 
+	hideControls := gen.Kiosk
+	if dbCfg.HideControls != nil {
+		hideControls = *dbCfg.HideControls
+	}
+	timepicker := map[string]interface{}{
+		"refresh_intervals": []interface{}{"5s", "10s", "30s", "1m", "5m", "15m", "30m"},
+	}
+	if hideControls {
+		// kiosk wallboards: locked, no time picker, no nav links
+		editable = false
+		timepicker["hidden"] = true
+		navLinks = nil
+	}
+
 	if navLinks == nil {
 		navLinks = []interface{}{}
 	}
 
-	return map[string]interface{}{
-		"annotations": map[string]interface{}{
-			"list": []interface{}{
-				map[string]interface{}{
-					"builtIn":    1,
-					"datasource": map[string]interface{}{"type": "grafana", "uid": "-- Grafana --"},
-					"enable":     true,
-					"hide":       true,
-					"iconColor":  "rgba(0, 211, 255, 1)",
-					"name":       "Annotations & Alerts",
-					"type":       "dashboard",
-				},
-			},
-		},
+	annotations, err := db.buildAnnotations(dbCfg.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	dashboard := map[string]interface{}{
+		"annotations":          map[string]interface{}{"list": annotations},
 		"description":          dbCfg.Description,
 		"editable":             editable,
 		"fiscalYearStartMonth": 0,
@@ -316,13 +636,255 @@ func (db *DashboardBuilder) Build(dbCfg config.DashboardConfig, navLinks []inter
 		"tags":                 toInterfaceSlice(dbCfg.Tags),
 		"templating":           map[string]interface{}{"list": variables},
 		"time":                 timeRange,
-		"timepicker": map[string]interface{}{
-			"refresh_intervals": []interface{}{"5s", "10s", "30s", "1m", "5m", "15m", "30m"},
+		"timepicker":           timepicker,
+		"timezone":             gen.Timezone,
+		"title":                dbCfg.Title,
+		"uid":                  dbCfg.UID,
+		"version":              1,
+	}
+	if hideControls {
+		dashboard["hideControls"] = true
+	}
+	return dashboard, nil
+}
+
+// HomeDashboardName is the dashboards key used for the home dashboard.
+const HomeDashboardName = "home"
+
+// HomeDashboardConfig expands generator.home_dashboard into a dashboard with
+// a single welcome text panel. Build gives it the usual nav links.
+func HomeDashboardConfig(home config.HomeDashboardSettings) config.DashboardConfig {
+	title := defaultStr(home.Title, "Home")
+	text := home.Text
+	if text == "" {
+		text = "# " + title + "\n\nPick a dashboard from the links above."
+	}
+	return config.DashboardConfig{
+		UID:         defaultStr(home.UID, HomeDashboardName),
+		Title:       title,
+		Filename:    home.Filename,
+		Icon:        "dashboard",
+		Annotations: home.Annotations,
+		Sections: []config.SectionConfig{{
+			Title: "welcome",
+			Panels: []map[string]interface{}{
+				{"type": "text", "title": "", "content": text, "height": 8},
+			},
+		}},
+	}
+}
+
+// normalizePanels puts generated arrays into a canonical order so that
+// regenerating an unchanged config yields byte-identical JSON: panels by
+// gridPos and each panel's targets by refId. Overrides, links and other
+// arrays keep config order, which Grafana treats as significant.
+func normalizePanels(panels []interface{}) {
+	sortByGridPos(panels)
+	var walk func(panels []interface{})
+	walk = func(panels []interface{}) {
+		for _, p := range panels {
+			m, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if targets, ok := m["targets"].([]interface{}); ok {
+				sort.SliceStable(targets, func(i, j int) bool {
+					return refIDLess(targetRefID(targets[i]), targetRefID(targets[j]))
+				})
+			}
+			if inner, ok := m["panels"].([]interface{}); ok {
+				walk(inner)
+			}
+		}
+	}
+	walk(panels)
+}
+
+func targetRefID(t interface{}) string {
+	m, _ := t.(map[string]interface{})
+	id, _ := m["refId"].(string)
+	return id
+}
+
+// refIDLess orders refIds like spreadsheet columns: A..Z before AA.
+func refIDLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// buildPanels lays out the dashboard's sections, then the discovery
+// sections, into its normalized top-level panel list.
+func (db *DashboardBuilder) buildPanels(dbCfg config.DashboardConfig, discoverySections []config.SectionConfig) ([]interface{}, error) {
+	allPanels := []interface{}{}
+	for _, section := range dbCfg.Sections {
+		panels, err := db.BuildSection(section)
+		if err != nil {
+			return nil, err
+		}
+		assignLibraryUIDs(panels, dbCfg.UID, section.Title)
+		allPanels = append(allPanels, panels...)
+	}
+
+	for _, section := range discoverySections {
+		panels, err := db.BuildSection(section)
+		if err != nil {
+			return nil, err
+		}
+		allPanels = append(allPanels, panels...)
+	}
+
+	normalizePanels(allPanels)
+	return allPanels, nil
+}
+
+// checkRepeats warns about row and panel repeat values that aren't
+// variables of the dashboard, which Grafana silently ignores, and about
+// repeats over single-value variables, which yield a single copy. validate
+// reports the former as errors.
+func (db *DashboardBuilder) checkRepeats(dbCfg config.DashboardConfig) {
+	onDashboard := make(map[string]bool, len(dbCfg.Variables))
+	for _, v := range dbCfg.Variables {
+		onDashboard[v] = true
+	}
+	check := func(repeat, where string) {
+		name := strings.TrimPrefix(repeat, "$")
+		if name == "" {
+			return
+		}
+		if !onDashboard[name] {
+			fmt.Fprintf(os.Stderr, "  warning: %s repeats over '%s', which is not a variable of this dashboard\n", where, name)
+			return
+		}
+		if def, ok := db.Config.GetVariableDef(name); ok && !def.Multi && !def.IncludeAll {
+			fmt.Fprintf(os.Stderr, "  warning: %s repeats over '%s', which is not multi-value\n", where, name)
+		}
+	}
+	for _, sec := range dbCfg.Sections {
+		check(sec.Repeat, fmt.Sprintf("section '%s'", sec.Title))
+		for _, p := range sec.Panels {
+			check(getString(p, "repeat", ""), fmt.Sprintf("panel '%s'", getString(p, "title", "?")))
+		}
+	}
+}
+
+// BuildAll builds the dashboards in order. Every dashboard is attempted and
+// all build errors are returned together, unless failFast stops at the first.
+func (db *DashboardBuilder) BuildAll(dashboards map[string]config.DashboardConfig, order []string, navLinks []interface{}, discoverySections []config.SectionConfig, failFast bool) (map[string]map[string]interface{}, error) {
+	built := make(map[string]map[string]interface{})
+	var errs []error
+	for _, name := range order {
+		dbCfg, ok := dashboards[name]
+		if !ok {
+			continue
+		}
+		dashboard, err := db.Build(dbCfg, navLinks, discoverySections)
+		if err != nil {
+			err = fmt.Errorf("building dashboard '%s': %w", name, err)
+			if failFast {
+				return built, err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		built[name] = dashboard
+	}
+	return built, errors.Join(errs...)
+}
+
+// buildAnnotations returns the built-in annotation layer followed by the
+// dashboard's configured layers.
+func (db *DashboardBuilder) buildAnnotations(cfgs []map[string]interface{}) ([]interface{}, error) {
+	list := []interface{}{
+		map[string]interface{}{
+			"builtIn":    1,
+			"datasource": map[string]interface{}{"type": "grafana", "uid": "-- Grafana --"},
+			"enable":     true,
+			"hide":       true,
+			"iconColor":  "rgba(0, 211, 255, 1)",
+			"name":       "Annotations & Alerts",
+			"type":       "dashboard",
 		},
-		"timezone": gen.Timezone,
-		"title":    dbCfg.Title,
-		"uid":      dbCfg.UID,
-		"version":  1,
+	}
+	for _, a := range cfgs {
+		atype := getString(a, "type", "")
+		if atype == "" && hasKey(a, "expr") {
+			atype = "query"
+		}
+		switch atype {
+		case "alert_state":
+			list = append(list, db.alertStateAnnotation(a))
+		case "query":
+			q, err := db.queryAnnotation(a)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, q)
+		default:
+			return nil, fmt.Errorf("unknown annotation type '%s'", atype)
+		}
+	}
+	return list, nil
+}
+
+// alertStateAnnotation shows Grafana alert state changes. With tags it
+// matches alert annotations carrying any of them; without, the alerts
+// linked to this dashboard.
+func (db *DashboardBuilder) alertStateAnnotation(a map[string]interface{}) map[string]interface{} {
+	target := map[string]interface{}{
+		"limit": getInt(a, "limit", 100),
+		"type":  "dashboard",
+	}
+	if tags := getStringSliceAsStrings(a, "tags"); len(tags) > 0 {
+		target["type"] = "tags"
+		target["tags"] = toInterfaceSlice(tags)
+		target["matchAny"] = true
+	}
+	return map[string]interface{}{
+		"datasource": map[string]interface{}{"type": "grafana", "uid": "-- Grafana --"},
+		"enable":     getBool(a, "enable", true),
+		"hide":       getBool(a, "hide", false),
+		"iconColor":  db.Config.ResolveColor(getString(a, "color", "red")),
+		"name":       getString(a, "name", "alert state"),
+		"target":     target,
+	}
+}
+
+// queryAnnotation marks events from a datasource query, such as deploys
+// from a deploy_timestamp metric. datasource names a configured datasource
+// (default: the default one); expr resolves refs like panel queries.
+func (db *DashboardBuilder) queryAnnotation(a map[string]interface{}) (map[string]interface{}, error) {
+	name := getString(a, "name", "annotations")
+	def := db.Config.GetDefaultDatasource()
+	ds := map[string]interface{}{"type": def.Type, "uid": def.UID}
+	if dsName := getString(a, "datasource", ""); dsName != "" {
+		ref, err := db.Config.GetDatasource(dsName)
+		if err != nil {
+			return nil, fmt.Errorf("annotation '%s': %w", name, err)
+		}
+		ds = map[string]interface{}{"type": ref.Type, "uid": ref.UID}
+	}
+	expr := getString(a, "expr", "")
+	if expr == "" {
+		return nil, fmt.Errorf("annotation '%s': query annotations need an expr", name)
+	}
+	tagKeys := getString(a, "tag_keys", "")
+	if keys := getStringSliceAsStrings(a, "tag_keys"); len(keys) > 0 {
+		tagKeys = strings.Join(keys, ",")
+	}
+	return map[string]interface{}{
+		"datasource":      ds,
+		"enable":          getBool(a, "enable", true),
+		"expr":            db.Config.ResolveRef(expr),
+		"hide":            getBool(a, "hide", false),
+		"iconColor":       db.Config.ResolveColor(getString(a, "icon_color", getString(a, "color", "blue"))),
+		"name":            name,
+		"step":            getString(a, "step", "60s"),
+		"tagKeys":         tagKeys,
+		"textFormat":      getString(a, "text_format", ""),
+		"titleFormat":     getString(a, "title_format", ""),
+		"useValueForTime": getBool(a, "use_value_for_time", false),
 	}, nil
 }
 
@@ -1,8 +1,11 @@
 package generator
 
 import (
+	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/wcatz/dashboard-generator/internal/config"
@@ -49,6 +52,8 @@ variables:
     refresh: 2
     sort: 1
     label: namespace
+    description: "kubernetes namespace"
+    allow_custom_value: false
   instance:
     type: query
     datasource: primary
@@ -167,6 +172,12 @@ func TestBuildVariable(t *testing.T) {
 	if v["includeAll"] != true {
 		t.Error("includeAll should be true")
 	}
+	if v["description"] != "kubernetes namespace" {
+		t.Errorf("description = %v, want kubernetes namespace", v["description"])
+	}
+	if v["allowCustomValue"] != false {
+		t.Errorf("allowCustomValue = %v, want false", v["allowCustomValue"])
+	}
 
 	// Test interval variable
 	iv, err := builder.BuildVariable("interval")
@@ -179,6 +190,9 @@ func TestBuildVariable(t *testing.T) {
 	if iv["auto"] != true {
 		t.Error("auto should be true")
 	}
+	if _, ok := iv["allowCustomValue"]; ok {
+		t.Error("allowCustomValue should be omitted when not set")
+	}
 	// interval shouldn't have datasource
 	if _, ok := iv["datasource"]; ok {
 		t.Error("interval variable should not have datasource")
@@ -191,6 +205,179 @@ func TestBuildVariable(t *testing.T) {
 	}
 }
 
+func TestBuildVariableChainsFrom(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  prom:
+    type: prometheus
+    uid: prom
+    is_default: true
+variables:
+  cluster:
+    query: label_values(up, cluster)
+  namespace:
+    query: label_values(kube_pod_info{cluster="$cluster"}, namespace)
+    chains_from: [cluster]
+    multi: true
+  pod:
+    query: label_values(up, pod)
+    chains_from: [cluster, namespace]
+  container:
+    query: label_values(kube_pod_container_info{job="kube-state-metrics"}, container)
+    chains_from: [cluster, namespace]
+  bare:
+    query: label_values(node)
+    chains_from: [cluster]
+  deployment:
+    query: label_values(kube_deployment_labels{job="ksm",namespace!="kube-system"}, deployment)
+    chains_from: [cluster, namespace]
+`))
+	if err != nil {
+		t.Fatalf("LoadFromBytes error: %v", err)
+	}
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+
+	tests := []struct {
+		name, want string
+	}{
+		// not chained: untouched
+		{"cluster", "label_values(up, cluster)"},
+		// matcher for cluster already present
+		{"namespace", `label_values(kube_pod_info{cluster="$cluster"}, namespace)`},
+		// bare metric; namespace is multi so it matches with =~
+		{"pod", `label_values(up{cluster="$cluster", namespace=~"$namespace"}, pod)`},
+		// existing matchers are kept and extended
+		{"container", `label_values(kube_pod_container_info{job="kube-state-metrics", cluster="$cluster", namespace=~"$namespace"}, container)`},
+		{"bare", `label_values({cluster="$cluster"}, node)`},
+		// a later matcher on an upstream label is found too
+		{"deployment", `label_values(kube_deployment_labels{job="ksm",namespace!="kube-system", cluster="$cluster"}, deployment)`},
+	}
+	for _, tt := range tests {
+		v, err := builder.BuildVariable(tt.name)
+		if err != nil {
+			t.Fatalf("BuildVariable(%s) error: %v", tt.name, err)
+		}
+		if v["definition"] != tt.want {
+			t.Errorf("%s definition = %v, want %s", tt.name, v["definition"], tt.want)
+		}
+		if q := v["query"].(map[string]interface{})["query"]; q != tt.want {
+			t.Errorf("%s query = %v, want %s", tt.name, q, tt.want)
+		}
+	}
+}
+
+func TestBuildVariableValuesFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "clusters.txt"), []byte("# clusters\nprod-eu\n\nprod-us\nlab,a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	data := `
+datasources:
+  prom:
+    type: prometheus
+    uid: prom
+    is_default: true
+variables:
+  cluster:
+    type: custom
+    values_file: clusters.txt
+    default:
+      text: prod-us
+      value: prod-us
+  missing:
+    type: custom
+    values_file: nope.txt
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+
+	v, err := builder.BuildVariable("cluster")
+	if err != nil {
+		t.Fatalf("BuildVariable error: %v", err)
+	}
+	if v["query"] != `prod-eu,prod-us,lab\,a` {
+		t.Errorf("query = %v", v["query"])
+	}
+	options := v["options"].([]interface{})
+	if len(options) != 3 {
+		t.Fatalf("option count = %d, want 3", len(options))
+	}
+	for i, want := range []string{"prod-eu", "prod-us", "lab,a"} {
+		opt := options[i].(map[string]interface{})
+		if opt["value"] != want || opt["text"] != want {
+			t.Errorf("option[%d] = %v, want %s", i, opt, want)
+		}
+		if opt["selected"] != (want == "prod-us") {
+			t.Errorf("option[%d] selected = %v", i, opt["selected"])
+		}
+	}
+
+	if _, err := builder.BuildVariable("missing"); err == nil {
+		t.Error("expected error for missing values_file")
+	}
+}
+
+func TestBuildGeneratedUID(t *testing.T) {
+	data := `
+datasources:
+  prom:
+    type: prometheus
+    uid: prom
+    is_default: true
+dashboards:
+  node_overview:
+    title: Node Overview
+    filename: node.json
+    sections: []
+  a_dashboard_name_well_past_the_forty_character_uid_limit:
+    title: Long
+    filename: long.json
+    sections: []
+`
+	cfg, err := config.LoadFromBytes([]byte(data))
+	if err != nil {
+		t.Fatalf("LoadFromBytes: %v", err)
+	}
+	dbs, err := cfg.GetDashboards("")
+	if err != nil {
+		t.Fatalf("GetDashboards: %v", err)
+	}
+	uid := dbs["node_overview"].UID
+	if !strings.HasPrefix(uid, "node-overview-") {
+		t.Errorf("uid = %q, want node-overview- prefix", uid)
+	}
+	if uid != config.GeneratedUID("node_overview") {
+		t.Errorf("uid = %q is not stable", uid)
+	}
+	long := dbs["a_dashboard_name_well_past_the_forty_character_uid_limit"].UID
+	if len(long) > 40 {
+		t.Errorf("long uid %q exceeds 40 chars", long)
+	}
+	if config.GeneratedUID("node-overview") == uid {
+		t.Error("names that slugify alike should get distinct uids")
+	}
+
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+	links := builder.BuildNavigationLinks(dbs, []string{"node_overview"})
+	if got := links[0].(map[string]interface{})["url"]; got != "/d/"+uid {
+		t.Errorf("nav link url = %v, want /d/%s", got, uid)
+	}
+	dashboard, err := builder.Build(dbs["node_overview"], links, nil)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if dashboard["uid"] != uid {
+		t.Errorf("dashboard uid = %v, want %s", dashboard["uid"], uid)
+	}
+}
+
 func TestBuildDashboard(t *testing.T) {
 	cfg := loadFullTestConfig(t)
 	idGen := NewIDGenerator()
@@ -272,3 +459,787 @@ func TestBuildDashboard(t *testing.T) {
 		t.Errorf("tag count = %d, want 2", len(tags))
 	}
 }
+
+func TestStarterConfigGenerates(t *testing.T) {
+	cfg, err := config.LoadFromBytes(config.StarterConfig)
+	if err != nil {
+		t.Fatalf("starter config does not load: %v", err)
+	}
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+	le := NewLayoutEngine()
+	builder := NewDashboardBuilder(cfg, pf, le)
+
+	dbs, err := cfg.GetDashboards("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dbs) == 0 {
+		t.Fatal("starter config has no dashboards")
+	}
+	order, _ := cfg.GetDashboardOrder("")
+	links := builder.BuildNavigationLinks(dbs, order)
+	for name, dbCfg := range dbs {
+		dashboard, err := builder.Build(dbCfg, links, nil)
+		if err != nil {
+			t.Fatalf("Build(%s) error: %v", name, err)
+		}
+		if panels := dashboard["panels"].([]interface{}); len(panels) == 0 {
+			t.Errorf("dashboard %s has no panels", name)
+		}
+	}
+}
+
+func TestBuildHideControls(t *testing.T) {
+	cfg := loadFullTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+	le := NewLayoutEngine()
+	builder := NewDashboardBuilder(cfg, pf, le)
+
+	dbs, _ := cfg.GetDashboards("")
+	dbCfg := dbs["overview"]
+	hide := true
+	dbCfg.HideControls = &hide
+	navLinks := []interface{}{
+		map[string]interface{}{"title": "overview", "url": "/d/gen-overview"},
+	}
+
+	dashboard, err := builder.Build(dbCfg, navLinks, nil)
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	timepicker := dashboard["timepicker"].(map[string]interface{})
+	if timepicker["hidden"] != true {
+		t.Error("hide_controls should hide the timepicker")
+	}
+	if dashboard["hideControls"] != true {
+		t.Error("hideControls should be set")
+	}
+	if dashboard["editable"] != false {
+		t.Error("hide_controls dashboards should not be editable")
+	}
+	if links := dashboard["links"].([]interface{}); len(links) != 0 {
+		t.Errorf("links = %d, want 0", len(links))
+	}
+}
+
+func TestBuildSectionLayoutTemplate(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+    is_default: true
+layouts:
+  top_row:
+    - { w: 8, h: 3 }
+    - { w: 16, h: 3 }
+    - { w: 24, h: 5 }
+dashboards: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+	le := NewLayoutEngine()
+	builder := NewDashboardBuilder(cfg, pf, le)
+
+	panels, err := builder.BuildSection(config.SectionConfig{
+		Title:          "health",
+		LayoutTemplate: "top_row",
+		Panels: []map[string]interface{}{
+			{"type": "stat", "title": "a", "query": "up"},
+			{"type": "stat", "title": "b", "query": "up"},
+			{"type": "timeseries", "title": "c", "query": "up"},
+			{"type": "stat", "title": "d", "query": "up"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BuildSection error: %v", err)
+	}
+
+	want := []map[string]interface{}{
+		{"x": 0, "y": 1, "w": 8, "h": 3},
+		{"x": 8, "y": 1, "w": 16, "h": 3},
+		{"x": 0, "y": 4, "w": 24, "h": 5},
+		// beyond the template: type default size
+		{"x": 0, "y": 9, "w": DefaultSizes["stat"][0], "h": DefaultSizes["stat"][1]},
+	}
+	for i, w := range want {
+		gp := panels[i+1].(map[string]interface{})["gridPos"].(map[string]interface{})
+		for _, k := range []string{"x", "y", "w", "h"} {
+			if gp[k] != w[k] {
+				t.Errorf("panel %d %s = %v, want %v", i, k, gp[k], w[k])
+			}
+		}
+	}
+
+	_, err = builder.BuildSection(config.SectionConfig{Title: "bad", LayoutTemplate: "missing"})
+	if err == nil {
+		t.Error("expected error for undefined layout template")
+	}
+}
+
+func TestBuildAlertStateAnnotation(t *testing.T) {
+	cfg := loadFullTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+	le := NewLayoutEngine()
+	builder := NewDashboardBuilder(cfg, pf, le)
+
+	dbs, _ := cfg.GetDashboards("")
+	dbCfg := dbs["overview"]
+	dbCfg.Annotations = []map[string]interface{}{
+		{"type": "alert_state", "tags": []interface{}{"node", "disk"}},
+	}
+
+	dashboard, err := builder.Build(dbCfg, nil, nil)
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	list := dashboard["annotations"].(map[string]interface{})["list"].([]interface{})
+	if len(list) != 2 {
+		t.Fatalf("annotations = %d, want 2 (built-in + alert state)", len(list))
+	}
+	a := list[1].(map[string]interface{})
+	ds := a["datasource"].(map[string]interface{})
+	if ds["type"] != "grafana" || ds["uid"] != "-- Grafana --" {
+		t.Errorf("datasource = %v, want grafana", ds)
+	}
+	target := a["target"].(map[string]interface{})
+	if target["type"] != "tags" || target["matchAny"] != true {
+		t.Errorf("target = %v, want tags/matchAny", target)
+	}
+	if tags := target["tags"].([]interface{}); len(tags) != 2 || tags[0] != "node" {
+		t.Errorf("tags = %v, want [node disk]", tags)
+	}
+
+	dbCfg.Annotations = []map[string]interface{}{{"type": "bogus"}}
+	if _, err := builder.Build(dbCfg, nil, nil); err == nil {
+		t.Error("expected error for unknown annotation type")
+	}
+}
+
+func TestBuildQueryAnnotation(t *testing.T) {
+	cfg := loadFullTestConfig(t)
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+
+	dbs, _ := cfg.GetDashboards("")
+	dbCfg := dbs["overview"]
+	dbCfg.Annotations = []map[string]interface{}{
+		{"type": "alert_state"},
+		{
+			"name":         "deploys",
+			"datasource":   "primary",
+			"expr":         "changes(deploy_timestamp${host}[${rate_interval}]) > 0",
+			"title_format": "deploy {{app}}",
+			"tag_keys":     []interface{}{"app", "version"},
+			"icon_color":   "$blue",
+		},
+	}
+
+	dashboard, err := builder.Build(dbCfg, nil, nil)
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	list := dashboard["annotations"].(map[string]interface{})["list"].([]interface{})
+	if len(list) != 3 {
+		t.Fatalf("annotations = %d, want built-in, alert state and query", len(list))
+	}
+	if list[0].(map[string]interface{})["builtIn"] != 1 {
+		t.Errorf("first annotation = %v, want the built-in one", list[0])
+	}
+	a := list[2].(map[string]interface{})
+	ds := a["datasource"].(map[string]interface{})
+	if ds["type"] != "prometheus" || ds["uid"] != "prometheus" {
+		t.Errorf("datasource = %v, want prometheus uid", ds)
+	}
+	if a["expr"] != `changes(deploy_timestamp{instance=~"$instance"}[5m]) > 0` {
+		t.Errorf("expr = %v, want resolved refs", a["expr"])
+	}
+	if a["name"] != "deploys" || a["titleFormat"] != "deploy {{app}}" || a["tagKeys"] != "app,version" {
+		t.Errorf("annotation = %v", a)
+	}
+	if a["enable"] != true || a["iconColor"] != "#5794F2" {
+		t.Errorf("enable/iconColor = %v/%v, want true/#5794F2", a["enable"], a["iconColor"])
+	}
+
+	dbCfg.Annotations = []map[string]interface{}{{"name": "x", "datasource": "missing", "expr": "up"}}
+	if _, err := builder.Build(dbCfg, nil, nil); err == nil {
+		t.Error("expected error for unknown annotation datasource")
+	}
+}
+
+func TestBuildAllAggregatesErrors(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+dashboards:
+  good:
+    uid: gen-good
+    title: good
+    sections:
+      - title: ok
+        panels:
+          - { type: stat, title: up, query: up }
+  broken_a:
+    uid: gen-broken-a
+    title: broken a
+    sections:
+      - title: bad
+        panels:
+          - { type: nope, title: a }
+  broken_b:
+    uid: gen-broken-b
+    title: broken b
+    variables: [missing]
+    sections: []
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+	le := NewLayoutEngine()
+	builder := NewDashboardBuilder(cfg, pf, le)
+	dbs, _ := cfg.GetDashboards("")
+	order, _ := cfg.GetDashboardOrder("")
+
+	built, err := builder.BuildAll(dbs, order, nil, nil, false)
+	if err == nil {
+		t.Fatal("expected aggregated error")
+	}
+	for _, name := range []string{"broken_a", "broken_b"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("aggregated error missing %s: %v", name, err)
+		}
+	}
+	if _, ok := built["good"]; !ok {
+		t.Error("good dashboard should still be built")
+	}
+
+	_, err = builder.BuildAll(dbs, order, nil, nil, true)
+	if err == nil || strings.Contains(err.Error(), "broken_b") {
+		t.Errorf("fail-fast should stop at broken_a, got: %v", err)
+	}
+}
+
+func TestBuildSectionConditionalPanels(t *testing.T) {
+	t.Setenv("DG_TEST_TIER", "prod")
+	cfg := loadFullTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+	le := NewLayoutEngine()
+	builder := NewDashboardBuilder(cfg, pf, le)
+
+	panels, err := builder.BuildSection(config.SectionConfig{
+		Title: "health",
+		Panels: []map[string]interface{}{
+			{"type": "stat", "title": "always", "query": "up"},
+			{"type": "stat", "title": "disabled", "query": "up", "enabled": false},
+			{"type": "stat", "title": "prod only", "query": "up", "when": "${ENV:DG_TEST_TIER}==prod"},
+			{"type": "stat", "title": "staging only", "query": "up", "when": "${ENV:DG_TEST_TIER} == staging"},
+			{"type": "stat", "title": "not dev", "query": "up", "when": "${ENV:DG_TEST_TIER}!=dev"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BuildSection error: %v", err)
+	}
+	var titles []string
+	for _, p := range panels[1:] {
+		titles = append(titles, p.(map[string]interface{})["title"].(string))
+	}
+	if got := strings.Join(titles, ","); got != "always,prod only,not dev" {
+		t.Errorf("panels = %s, want always,prod only,not dev", got)
+	}
+
+	off := false
+	panels, err = builder.BuildSection(config.SectionConfig{Title: "off", Enabled: &off,
+		Panels: []map[string]interface{}{{"type": "stat", "title": "x", "query": "up"}}})
+	if err != nil || len(panels) != 0 {
+		t.Errorf("disabled section = %d panels, err %v; want none", len(panels), err)
+	}
+
+	_, err = builder.BuildSection(config.SectionConfig{Title: "bad", When: "prod"})
+	if err == nil {
+		t.Error("expected error for malformed when")
+	}
+}
+
+func TestBuildCheckOverlaps(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+generator:
+  check_overlaps: true
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+dashboards:
+  manual:
+    uid: manual
+    title: manual
+    sections:
+      - title: placed
+        panels:
+          - { type: stat, title: left, query: up, x: 0, y: 1, width: 12, height: 4 }
+          - { type: stat, title: right, query: up, x: 8, y: 2, width: 12, height: 4 }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+	dbs, _ := cfg.GetDashboards("")
+	_, err = builder.Build(dbs["manual"], nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "'left' overlaps 'right'") {
+		t.Errorf("expected overlap error naming both panels, got %v", err)
+	}
+}
+
+func TestBuildDashboardTimeOverrides(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+generator:
+  refresh: 1m
+  time_range: { from: now-30m, to: now }
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+dashboards:
+  slo:
+    uid: slo
+    title: slo
+    time_range: { from: now-7d, to: now }
+    refresh: 5m
+  nodes:
+    uid: nodes
+    title: nodes
+  empty:
+    uid: empty
+    title: empty
+    time_range: {}
+  preset:
+    uid: preset
+    title: preset
+    time_range: last_24h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+	dbs, _ := cfg.GetDashboards("")
+
+	tests := []struct {
+		name, from, refresh string
+	}{
+		{"slo", "now-7d", "5m"},
+		{"nodes", "now-30m", "1m"},
+		{"empty", "now-30m", "1m"},
+		{"preset", "now-24h", "1m"},
+	}
+	for _, tt := range tests {
+		dashboard, err := builder.Build(dbs[tt.name], nil, nil)
+		if err != nil {
+			t.Fatalf("%s: Build error: %v", tt.name, err)
+		}
+		tr := dashboard["time"].(map[string]string)
+		if tr["from"] != tt.from || tr["to"] != "now" {
+			t.Errorf("%s: time = %v, want %s to now", tt.name, tr, tt.from)
+		}
+		if dashboard["refresh"] != tt.refresh {
+			t.Errorf("%s: refresh = %v, want %s", tt.name, dashboard["refresh"], tt.refresh)
+		}
+	}
+}
+
+func TestBuildCustomGridWidth(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+generator:
+  grid_width: 12
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngineWidth(cfg.GetGenerator().GridColumns()))
+	panels, err := builder.BuildSection(config.SectionConfig{
+		Title: "narrow",
+		Panels: []map[string]interface{}{
+			{"type": "timeseries", "title": "a", "query": "up"},
+			{"type": "timeseries", "title": "b", "query": "up"},
+			{"type": "table", "title": "c", "query": "up"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BuildSection error: %v", err)
+	}
+	want := []map[string]int{
+		{"x": 0, "y": 0, "w": 12},  // row spans the grid
+		{"x": 0, "y": 1, "w": 12},  // timeseries default width fills the line
+		{"x": 0, "y": 8, "w": 12},  // and the next wraps below it
+		{"x": 0, "y": 15, "w": 12}, // table default 24 clamped to 12
+	}
+	for i, wp := range want {
+		gp := panels[i].(map[string]interface{})["gridPos"].(map[string]interface{})
+		for k, v := range wp {
+			if gp[k] != v {
+				t.Errorf("panel %d %s = %v, want %d", i, k, gp[k], v)
+			}
+		}
+	}
+
+	_, err = builder.BuildSection(config.SectionConfig{
+		Title:  "wide",
+		Panels: []map[string]interface{}{{"type": "stat", "title": "too wide", "query": "up", "width": 16}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "'too wide': width 16 exceeds the 12-column grid") {
+		t.Errorf("err = %v, want width error naming the panel", err)
+	}
+	if cfg.GetGenerator().GridColumns() != 12 {
+		t.Errorf("GridColumns = %d, want 12", cfg.GetGenerator().GridColumns())
+	}
+}
+
+func TestBuildExplicitPlacement(t *testing.T) {
+	build := func(panels string) error {
+		cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+dashboards:
+  manual:
+    uid: manual
+    title: manual
+    sections:
+      - title: placed
+        panels:
+` + panels))
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+		dbs, _ := cfg.GetDashboards("")
+		_, err = builder.Build(dbs["manual"], nil, nil)
+		return err
+	}
+
+	// overlaps are rejected without generator.check_overlaps
+	err := build(`
+          - { type: stat, title: left, query: up, x: 0, y: 1, width: 12, height: 4 }
+          - { type: stat, title: right, query: up, x: 8, y: 2, width: 12, height: 4 }
+`)
+	if err == nil || !strings.Contains(err.Error(), "'left' overlaps 'right'") {
+		t.Errorf("overlap: got %v, want error naming left and right", err)
+	}
+
+	err = build(`
+          - { type: stat, title: wide, query: up, x: 16, y: 1, width: 12, height: 4 }
+`)
+	if err == nil || !strings.Contains(err.Error(), "'wide' at x=16 with width 12") {
+		t.Errorf("over-wide: got %v, want error naming wide", err)
+	}
+
+	// side by side, and stacked, plus auto-placed panels
+	err = build(`
+          - { type: stat, title: a, query: up, x: 0, y: 1, width: 12, height: 4 }
+          - { type: stat, title: b, query: up, x: 12, y: 1, width: 12, height: 4 }
+          - { type: stat, title: c, query: up, x: 0, y: 5, width: 24, height: 4 }
+          - { type: stat, title: auto1, query: up }
+          - { type: stat, title: auto2, query: up }
+`)
+	if err != nil {
+		t.Errorf("valid manual layout: %v", err)
+	}
+}
+
+func TestBuildTimeRangePreset(t *testing.T) {
+	load := func(timeRange string) (map[string]interface{}, error) {
+		cfg, err := config.LoadFromBytes([]byte(`
+generator:
+  time_range: ` + timeRange + `
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+dashboards:
+  overview: { uid: overview, title: overview }
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+		dbs, _ := cfg.GetDashboards("")
+		return builder.Build(dbs["overview"], nil, nil)
+	}
+
+	dashboard, err := load("last_24h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := dashboard["time"].(map[string]string)
+	if tr["from"] != "now-24h" || tr["to"] != "now" {
+		t.Errorf("time = %v, want now-24h to now", tr)
+	}
+
+	dashboard, err = load("{ from: now-6h, to: now-1h }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr := dashboard["time"].(map[string]string); tr["from"] != "now-6h" || tr["to"] != "now-1h" {
+		t.Errorf("explicit time = %v, want now-6h to now-1h", tr)
+	}
+
+	if _, err := load("last_fortnight"); err == nil {
+		t.Error("expected error for unknown preset")
+	}
+}
+
+func TestBuildHomeDashboard(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+generator:
+  home_dashboard:
+    title: Ops Home
+    text: "# Welcome to ops"
+    annotations:
+      - { type: alert_state, tags: [ops] }
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+dashboards:
+  overview: { uid: overview, title: overview }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := HomeDashboardConfig(*cfg.GetGenerator().HomeDashboard)
+	if home.UID != "home" || home.Title != "Ops Home" {
+		t.Errorf("home uid/title = %s/%s, want home/Ops Home", home.UID, home.Title)
+	}
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+	dbs, _ := cfg.GetDashboards("")
+	dbs[HomeDashboardName] = home
+	navLinks := builder.BuildNavigationLinks(dbs, []string{HomeDashboardName, "overview"})
+	dashboard, err := builder.Build(home, navLinks, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text map[string]interface{}
+	for _, p := range dashboard["panels"].([]interface{}) {
+		if panel := p.(map[string]interface{}); panel["type"] == "text" {
+			text = panel
+		}
+	}
+	if text == nil || text["options"].(map[string]interface{})["content"] != "# Welcome to ops" {
+		t.Errorf("welcome text panel = %v", text)
+	}
+	if links := dashboard["links"].([]interface{}); len(links) != 2 {
+		t.Errorf("links = %d, want 2", len(links))
+	}
+	if list := dashboard["annotations"].(map[string]interface{})["list"].([]interface{}); len(list) != 2 {
+		t.Errorf("annotations = %d, want built-in plus alert_state", len(list))
+	}
+
+	def := HomeDashboardConfig(config.HomeDashboardSettings{})
+	if content := def.Sections[0].Panels[0]["content"].(string); !strings.HasPrefix(content, "# Home") {
+		t.Errorf("default text = %q", content)
+	}
+}
+
+func TestBuildRepeatUndefinedVariableWarns(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+variables:
+  instance: { type: query, query: "label_values(up, instance)", multi: true }
+dashboards:
+  good:
+    uid: good
+    title: good
+    variables: [instance]
+    sections:
+      - { title: per instance, repeat: instance, panels: [] }
+  bad:
+    uid: bad
+    title: bad
+    variables: [instance]
+    sections:
+      - title: per foo
+        repeat: foo
+        panels:
+          - { type: stat, title: up, query: up, repeat: $bar }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+	dbs, _ := cfg.GetDashboards("")
+
+	if _, err := builder.Build(dbs["good"], nil, nil); err != nil {
+		t.Errorf("repeat over a dashboard variable: %v", err)
+	}
+	// validate reports undefined repeat variables; the build only warns
+	if _, err := builder.Build(dbs["bad"], nil, nil); err != nil {
+		t.Errorf("repeat over undefined variables should only warn, got %v", err)
+	}
+}
+
+func TestBuildReproducible(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+dashboards:
+  overview:
+    uid: overview
+    title: overview
+    sections:
+      - title: pinned
+        panels:
+          - { type: stat, title: right, query: up, x: 12, y: 1, width: 6, height: 4 }
+          - { type: stat, title: left, query: up, x: 0, y: 1, width: 6, height: 4 }
+          - type: timeseries
+            title: multi
+            x: 0
+            y: 5
+            unit_overrides: { rx: bytes, tx: bytes, errors: short }
+            targets:
+              - { expr: "rate(rx[5m])", legend: rx }
+              - { expr: "rate(tx[5m])", legend: tx }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs, _ := cfg.GetDashboards("")
+	build := func() []byte {
+		builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+		dashboard, err := builder.Build(dbs["overview"], nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.MarshalIndent(dashboard, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	first := build()
+	for i := 0; i < 5; i++ {
+		if again := build(); !bytes.Equal(first, again) {
+			t.Fatalf("build %d differs from the first", i+2)
+		}
+	}
+
+	var dashboard struct {
+		Panels []struct {
+			Title string `json:"title"`
+		} `json:"panels"`
+	}
+	if err := json.Unmarshal(first, &dashboard); err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, p := range dashboard.Panels {
+		titles = append(titles, p.Title)
+	}
+	if got := strings.Join(titles, ","); got != "pinned,left,right,multi" {
+		t.Errorf("panel order = %s, want pinned,left,right,multi", got)
+	}
+}
+
+func TestBuildSectionRepeatedPanel(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+variables:
+  instance: { type: query, query: "label_values(up, instance)", multi: true }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+	panels, err := builder.BuildSection(config.SectionConfig{
+		Title: "per instance",
+		Panels: []map[string]interface{}{
+			{"type": "stat", "title": "total", "query": "up", "width": 4, "height": 4},
+			{"type": "stat", "title": "cpu", "query": "up", "width": 6, "height": 4, "repeat": "$instance", "max_per_row": 4},
+			{"type": "stat", "title": "after", "query": "up", "width": 4, "height": 4},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := func(i int) (int, int) {
+		gp := panels[i].(map[string]interface{})["gridPos"].(map[string]interface{})
+		return gp["x"].(int), gp["y"].(int)
+	}
+	repeated := panels[2].(map[string]interface{})
+	if repeated["repeat"] != "instance" || repeated["repeatDirection"] != "h" || repeated["maxPerRow"] != 4 {
+		t.Errorf("repeat fields = %v/%v/%v, want instance/h/4", repeated["repeat"], repeated["repeatDirection"], repeated["maxPerRow"])
+	}
+	if x, y := pos(2); x != 0 || y != 5 {
+		t.Errorf("repeated panel at (%d,%d), want (0,5) on a fresh line", x, y)
+	}
+	if x, y := pos(3); x != 0 || y != 9 {
+		t.Errorf("static panel after repeat at (%d,%d), want (0,9) on a new line", x, y)
+	}
+
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+	vertical, err := pf.FromConfig(map[string]interface{}{"type": "stat", "title": "x", "query": "up", "repeat": "instance", "repeat_direction": "v", "max_per_row": 4}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vertical["repeat"] != "instance" || vertical["repeatDirection"] != "v" {
+		t.Errorf("vertical repeat fields = %v/%v, want instance/v", vertical["repeat"], vertical["repeatDirection"])
+	}
+	if _, ok := vertical["maxPerRow"]; ok {
+		t.Error("maxPerRow should only be set for horizontal repeats")
+	}
+	if _, err := pf.FromConfig(map[string]interface{}{"type": "stat", "title": "x", "query": "up", "repeat": "instance", "repeat_direction": "x"}, 0, 0); err == nil {
+		t.Error("expected error for repeat_direction x")
+	}
+}
+
+func TestBuildSectionLogVolume(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+  loki: { type: loki, uid: loki }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+	panels, err := builder.BuildSection(config.SectionConfig{
+		Title: "logs",
+		Panels: []map[string]interface{}{
+			{"type": "logs", "title": "app logs", "datasource": "loki", "query": `{app="api"} |= "error"`, "with_volume": true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(panels) != 3 {
+		t.Fatalf("panels = %d, want row + volume + logs", len(panels))
+	}
+	volume := panels[1].(map[string]interface{})
+	logs := panels[2].(map[string]interface{})
+	if volume["type"] != "timeseries" || volume["title"] != "app logs volume" {
+		t.Errorf("volume panel = %v %v", volume["type"], volume["title"])
+	}
+	target := volume["targets"].([]interface{})[0].(map[string]interface{})
+	if target["expr"] != `sum(count_over_time({app="api"} |= "error" [$__interval]))` {
+		t.Errorf("volume expr = %v", target["expr"])
+	}
+	if ds := target["datasource"].(map[string]interface{}); ds["uid"] != "loki" {
+		t.Errorf("volume datasource = %v, want loki", ds)
+	}
+	custom := volume["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["custom"].(map[string]interface{})
+	if custom["drawStyle"] != "bars" {
+		t.Errorf("volume drawStyle = %v, want bars", custom["drawStyle"])
+	}
+	if logs["type"] != "logs" {
+		t.Errorf("second panel type = %v, want logs", logs["type"])
+	}
+	vpos := volume["gridPos"].(map[string]interface{})
+	lpos := logs["gridPos"].(map[string]interface{})
+	if vpos["w"] != lpos["w"] || lpos["y"].(int) != vpos["y"].(int)+vpos["h"].(int) {
+		t.Errorf("volume %v should sit directly above logs %v", vpos, lpos)
+	}
+}
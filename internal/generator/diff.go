@@ -0,0 +1,211 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// diffIgnoredKeys are top-level dashboard keys Grafana manages itself; they
+// always differ between generated and live JSON and are skipped.
+var diffIgnoredKeys = map[string]bool{
+	"id":      true,
+	"version": true,
+}
+
+// Change is a single differing value between two dashboards. Old is nil for
+// added paths and New is nil for removed ones.
+type Change struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// DashboardDiff is the comparison result for one dashboard.
+type DashboardDiff struct {
+	UID     string   `json:"uid"`
+	Title   string   `json:"title"`
+	Status  string   `json:"status"` // "new", "changed" or "unchanged"
+	Changes []Change `json:"changes"`
+}
+
+// DiffDashboard compares a generated dashboard against the live copy. A nil
+// live dashboard means it does not exist in Grafana yet.
+func DiffDashboard(generated, live map[string]interface{}) (DashboardDiff, error) {
+	uid, _ := generated["uid"].(string)
+	title, _ := generated["title"].(string)
+	d := DashboardDiff{UID: uid, Title: title, Changes: []Change{}}
+	if live == nil {
+		d.Status = "new"
+		return d, nil
+	}
+
+	// round-trip through JSON so both sides use the same number types
+	gen, err := normalizeJSON(generated)
+	if err != nil {
+		return d, err
+	}
+	cur, err := normalizeJSON(live)
+	if err != nil {
+		return d, err
+	}
+	genMap, _ := gen.(map[string]interface{})
+	curMap, _ := cur.(map[string]interface{})
+	for k := range diffIgnoredKeys {
+		delete(genMap, k)
+		delete(curMap, k)
+	}
+
+	d.Changes = diffValues("", curMap, genMap, d.Changes)
+	d.Status = "unchanged"
+	if len(d.Changes) > 0 {
+		d.Status = "changed"
+	}
+	return d, nil
+}
+
+func normalizeJSON(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling dashboard: %w", err)
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("unmarshaling dashboard: %w", err)
+	}
+	return out, nil
+}
+
+func diffValues(path string, old, new interface{}, changes []Change) []Change {
+	switch o := old.(type) {
+	case map[string]interface{}:
+		n, ok := new.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range o {
+			keys[k] = true
+		}
+		for k := range n {
+			keys[k] = true
+		}
+		for _, k := range sortedKeys(keys) {
+			ov, inOld := o[k]
+			nv, inNew := n[k]
+			p := joinPath(path, k)
+			switch {
+			case !inOld:
+				changes = append(changes, Change{Path: p, New: nv})
+			case !inNew:
+				changes = append(changes, Change{Path: p, Old: ov})
+			default:
+				changes = diffValues(p, ov, nv, changes)
+			}
+		}
+		return changes
+	case []interface{}:
+		n, ok := new.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(o) || i < len(n); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(o):
+				changes = append(changes, Change{Path: p, New: n[i]})
+			case i >= len(n):
+				changes = append(changes, Change{Path: p, Old: o[i]})
+			default:
+				changes = diffValues(p, o[i], n[i], changes)
+			}
+		}
+		return changes
+	}
+	oj, _ := json.Marshal(old)
+	nj, _ := json.Marshal(new)
+	if string(oj) != string(nj) {
+		changes = append(changes, Change{Path: path, Old: old, New: new})
+	}
+	return changes
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// DiffFormats lists the supported output formats for WriteDiff.
+var DiffFormats = []string{"unified", "json", "summary"}
+
+// WriteDiff renders dashboard diffs in the given format.
+func WriteDiff(w io.Writer, diffs []DashboardDiff, format string) error {
+	switch format {
+	case "unified", "":
+		return writeUnifiedDiff(w, diffs)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diffs)
+	case "summary":
+		return writeSummaryDiff(w, diffs)
+	default:
+		return ValidDiffFormat(format)
+	}
+}
+
+// ValidDiffFormat returns an error unless format is one of DiffFormats or
+// empty (unified).
+func ValidDiffFormat(format string) error {
+	if format != "" && !slices.Contains(DiffFormats, format) {
+		return fmt.Errorf("unknown diff format '%s' (want %s)", format, strings.Join(DiffFormats, ", "))
+	}
+	return nil
+}
+
+func writeUnifiedDiff(w io.Writer, diffs []DashboardDiff) error {
+	for _, d := range diffs {
+		switch d.Status {
+		case "unchanged":
+			continue
+		case "new":
+			fmt.Fprintf(w, "--- /dev/null\n+++ generated/%s\n@@ new dashboard: %s @@\n", d.UID, d.Title)
+			continue
+		}
+		fmt.Fprintf(w, "--- live/%s\n+++ generated/%s\n", d.UID, d.UID)
+		for _, c := range d.Changes {
+			fmt.Fprintf(w, "@@ %s @@\n", c.Path)
+			if c.Old != nil {
+				fmt.Fprintf(w, "-%s\n", compactJSON(c.Old))
+			}
+			if c.New != nil {
+				fmt.Fprintf(w, "+%s\n", compactJSON(c.New))
+			}
+		}
+	}
+	return nil
+}
+
+func writeSummaryDiff(w io.Writer, diffs []DashboardDiff) error {
+	counts := map[string]int{}
+	changes := 0
+	for _, d := range diffs {
+		counts[d.Status]++
+		changes += len(d.Changes)
+	}
+	_, err := fmt.Fprintf(w, "%d changed (%d changes), %d new, %d unchanged\n",
+		counts["changed"], changes, counts["new"], counts["unchanged"])
+	return err
+}
+
+func compactJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
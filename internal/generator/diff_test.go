@@ -0,0 +1,94 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDiffDashboard(t *testing.T) {
+	generated := map[string]interface{}{
+		"uid":     "gen-overview",
+		"title":   "overview",
+		"version": 1,
+		"panels": []interface{}{
+			map[string]interface{}{"id": 1, "title": "targets up", "gridPos": map[string]interface{}{"w": 4}},
+		},
+	}
+	live := map[string]interface{}{
+		"uid":     "gen-overview",
+		"title":   "overview",
+		"version": 7.0,
+		"panels": []interface{}{
+			map[string]interface{}{"id": 1.0, "title": "targets", "gridPos": map[string]interface{}{"w": 4.0}},
+		},
+	}
+
+	d, err := DiffDashboard(generated, live)
+	if err != nil {
+		t.Fatalf("DiffDashboard error: %v", err)
+	}
+	if d.Status != "changed" {
+		t.Errorf("status = %s, want changed", d.Status)
+	}
+	if len(d.Changes) != 1 {
+		t.Fatalf("changes = %d, want 1: %+v", len(d.Changes), d.Changes)
+	}
+	if d.Changes[0].Path != "panels[0].title" {
+		t.Errorf("path = %s, want panels[0].title", d.Changes[0].Path)
+	}
+
+	same, err := DiffDashboard(generated, generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same.Status != "unchanged" {
+		t.Errorf("identical status = %s, want unchanged", same.Status)
+	}
+
+	created, _ := DiffDashboard(generated, nil)
+	if created.Status != "new" {
+		t.Errorf("missing live status = %s, want new", created.Status)
+	}
+}
+
+func TestWriteDiffJSON(t *testing.T) {
+	diffs := []DashboardDiff{{
+		UID:     "gen-overview",
+		Status:  "changed",
+		Changes: []Change{{Path: "panels[0].title", Old: "targets", New: "targets up"}},
+	}}
+
+	var buf bytes.Buffer
+	if err := WriteDiff(&buf, diffs, "json"); err != nil {
+		t.Fatalf("WriteDiff error: %v", err)
+	}
+	var decoded []DashboardDiff
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON output: %v", err)
+	}
+	if len(decoded) != 1 || len(decoded[0].Changes) != 1 || decoded[0].Changes[0].Path != "panels[0].title" {
+		t.Errorf("decoded = %+v, want one change at panels[0].title", decoded)
+	}
+
+	buf.Reset()
+	if err := WriteDiff(&buf, diffs, "summary"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(buf.String(), "\n") != 1 || !strings.HasPrefix(buf.String(), "1 changed") {
+		t.Errorf("summary = %q, want a single line starting with '1 changed'", buf.String())
+	}
+
+	if err := WriteDiff(&buf, diffs, "xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	for _, format := range append([]string{""}, DiffFormats...) {
+		if err := ValidDiffFormat(format); err != nil {
+			t.Errorf("ValidDiffFormat(%q) = %v", format, err)
+		}
+	}
+	if err := ValidDiffFormat("xml"); err == nil {
+		t.Error("ValidDiffFormat accepted xml")
+	}
+}
@@ -4,30 +4,73 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/wcatz/dashboard-generator/internal/config"
+	"gopkg.in/yaml.v3"
 )
 
 // MetricDiscovery queries Prometheus API for available metrics.
 type MetricDiscovery struct {
 	Config *config.Config
-	cache  map[string]interface{}
+	// Trace, when set, receives one record per API request with its path,
+	// status and duration.
+	Trace *slog.Logger
+	// TLS applies to every Prometheus request. It is read once, when the
+	// first request builds the shared client.
+	TLS   TLSOptions
+	cache *discoveryCache
+
+	clientOnce sync.Once
+	client     *http.Client
+	clientErr  error
 }
 
-// NewMetricDiscovery creates a new discovery instance.
+// NewMetricDiscovery creates a new discovery instance. Responses are cached
+// for discovery.cache_ttl (default 5m).
 func NewMetricDiscovery(cfg *config.Config) *MetricDiscovery {
-	return &MetricDiscovery{Config: cfg, cache: make(map[string]interface{})}
+	ttl := defaultCacheTTL
+	if v := cfg.GetDiscovery().CacheTTL; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			fmt.Fprintf(os.Stderr, "  warning: discovery.cache_ttl: invalid duration '%s', using %s\n", v, defaultCacheTTL)
+		} else {
+			ttl = d
+		}
+	}
+	return &MetricDiscovery{Config: cfg, cache: newDiscoveryCache(ttl)}
+}
+
+// FlushCache drops all cached responses so the next request re-fetches.
+func (md *MetricDiscovery) FlushCache() {
+	md.cache.flush()
+}
+
+// cached returns a cached response that has not outlived the TTL.
+func (md *MetricDiscovery) cached(key string) (interface{}, bool) {
+	return md.cache.get(key)
+}
+
+func (md *MetricDiscovery) store(key string, v interface{}) {
+	md.cache.put(key, v)
 }
 
 // MetricInfo holds type and help text for a discovered metric.
 type MetricInfo struct {
 	Type string
 	Help string
+	// Buckets marks a classic histogram family (name_bucket with an le
+	// label, plus name_sum/name_count) collapsed to its base name.
+	Buckets bool
 }
 
 // TargetInfo holds information about a single Prometheus scrape target.
@@ -52,17 +95,27 @@ type JobSummary struct {
 	Targets     []TargetInfo
 }
 
-func (md *MetricDiscovery) get(baseURL, path string) (interface{}, error) {
+// httpClient returns the client shared by every request of this discovery
+// instance, so connections are kept alive and the CA file is read once.
+func (md *MetricDiscovery) httpClient() (*http.Client, error) {
+	md.clientOnce.Do(func() {
+		md.client, md.clientErr = NewHTTPClient(30*time.Second, md.TLS)
+	})
+	return md.client, md.clientErr
+}
+
+// get queries a datasource API path, authenticating with the datasource's
+// configured credentials.
+func (md *MetricDiscovery) get(dsName, baseURL, path string) (interface{}, error) {
 	url := strings.TrimRight(baseURL, "/") + path
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Get(url)
+	client, err := md.httpClient()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "  error querying %s: %v\n", url, err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	auth := md.Config.Datasources[dsName].AuthHeader()
+	body, err := md.doWithRetry(client, baseURL, path, auth)
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "  error querying %s: %v\n", url, err)
 		return nil, err
 	}
 	var result map[string]interface{}
@@ -75,17 +128,235 @@ func (md *MetricDiscovery) get(baseURL, path string) (interface{}, error) {
 	return result["data"], nil
 }
 
-// FetchMetrics retrieves all metric names from a datasource.
+// defaultRetryBackoff is the first retry delay when discovery.retry_backoff
+// is unset.
+const defaultRetryBackoff = 500 * time.Millisecond
+
+// doWithRetry GETs baseURL+path, sending auth as the Authorization header
+// when set, and returns the response body. Connection
+// errors and 5xx responses are retried up to discovery.retries times with
+// exponential backoff; other non-2xx responses fail immediately.
+func (md *MetricDiscovery) doWithRetry(client *http.Client, baseURL, path, auth string) ([]byte, error) {
+	disc := md.Config.GetDiscovery()
+	backoff := defaultRetryBackoff
+	if disc.RetryBackoff != "" {
+		d, err := time.ParseDuration(disc.RetryBackoff)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("discovery.retry_backoff: invalid duration '%s'", disc.RetryBackoff)
+		}
+		backoff = d
+	}
+
+	url := strings.TrimRight(baseURL, "/") + path
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		body, status, err := fetchBody(client, url, auth)
+		md.trace(baseURL, path, status, start, err)
+		if err == nil {
+			if status >= 200 && status < 300 {
+				return body, nil
+			}
+			err = fmt.Errorf("HTTP %d", status)
+			if status < 500 {
+				return nil, err
+			}
+		}
+		if attempt >= disc.Retries {
+			return nil, err
+		}
+		delay := backoff << attempt
+		fmt.Fprintf(os.Stderr, "  warning: querying %s failed (%v), retrying in %s\n", url, err, delay)
+		time.Sleep(delay)
+	}
+}
+
+// fetchBody performs one GET, returning the body and status code (0 when the
+// request itself failed).
+func fetchBody(client *http.Client, url, auth string) ([]byte, int, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return body, resp.StatusCode, err
+}
+
+func (md *MetricDiscovery) trace(baseURL, path string, status int, start time.Time, err error) {
+	if md.Trace == nil {
+		return
+	}
+	attrs := []any{"url", baseURL, "path", path, "status", status, "duration", time.Since(start)}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	md.Trace.Info("discovery request", attrs...)
+}
+
+// lokiStreamLabel is the label whose values stand in for metric names when
+// discovering a Loki datasource: each value becomes a {job="..."} stream.
+const lokiStreamLabel = "job"
+
+// isLoki reports whether a datasource is configured with type: loki.
+func (md *MetricDiscovery) isLoki(dsName string) bool {
+	return md.Config.Datasources[dsName].Type == "loki"
+}
+
+// apiPath maps a Prometheus API path to the datasource's path family; Loki
+// serves the same label and series endpoints under /loki.
+func (md *MetricDiscovery) apiPath(dsName, path string) string {
+	if md.isLoki(dsName) {
+		return "/loki" + path
+	}
+	return path
+}
+
+// healthPaths maps a datasource type to its health endpoint; an empty type
+// is treated as Prometheus.
+var healthPaths = map[string]string{
+	"":              "/-/healthy",
+	"prometheus":    "/-/healthy",
+	"loki":          "/ready",
+	"influxdb":      "/ping",
+	"elasticsearch": "/_cluster/health",
+}
+
+// CanCheckHealth reports whether CheckHealth knows how to probe a
+// datasource's type.
+func (md *MetricDiscovery) CanCheckHealth(dsName string) bool {
+	_, ok := healthPaths[md.Config.Datasources[dsName].Type]
+	return ok
+}
+
+// CheckHealth probes a datasource's health endpoint for its type: /-/healthy
+// for Prometheus, /ready for Loki, /ping for InfluxDB and /_cluster/health
+// for Elasticsearch. Other types cannot be probed and report no error.
+// Results are cached per discovery instance so each datasource is probed at
+// most once.
+func (md *MetricDiscovery) CheckHealth(dsName string) error {
+	path, ok := healthPaths[md.Config.Datasources[dsName].Type]
+	if !ok {
+		return nil
+	}
+	key := "health:" + dsName
+	if cached, ok := md.cached(key); ok {
+		if cached == nil {
+			return nil
+		}
+		return cached.(error)
+	}
+	baseURL := md.Config.GetDatasourceURL(dsName)
+	if baseURL == "" {
+		return fmt.Errorf("no URL configured for datasource '%s'", dsName)
+	}
+	shared, err := md.httpClient()
+	if err != nil {
+		return err
+	}
+	// health probes fail fast, over the same connections as everything else
+	client := *shared
+	client.Timeout = 5 * time.Second
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(baseURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	if auth := md.Config.Datasources[dsName].AuthHeader(); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	var herr error
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		md.trace(baseURL, path, 0, start, err)
+		herr = fmt.Errorf("datasource '%s' unreachable: %w", dsName, err)
+	} else {
+		md.trace(baseURL, path, resp.StatusCode, start, nil)
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			herr = fmt.Errorf("datasource '%s' unhealthy: HTTP %d", dsName, resp.StatusCode)
+		}
+	}
+	md.store(key, herr)
+	return herr
+}
+
+// RequireReachable probes every configured datasource referenced by a built
+// dashboard and returns the first failure. Datasources without a URL, or of a
+// type CheckHealth cannot probe, are skipped.
+func (md *MetricDiscovery) RequireReachable(dashboard map[string]interface{}) error {
+	uids := make(map[string]bool)
+	collectDatasourceUIDs(dashboard["panels"], uids)
+
+	names := make([]string, 0, len(md.Config.Datasources))
+	for name, ds := range md.Config.Datasources {
+		if uids[ds.UID] && md.Config.GetDatasourceURL(name) != "" && md.CanCheckHealth(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := md.CheckHealth(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectDatasourceUIDs walks panels (including collapsed row children) and
+// their targets, recording every datasource uid it finds.
+func collectDatasourceUIDs(v interface{}, uids map[string]bool) {
+	panels, ok := v.([]interface{})
+	if !ok {
+		return
+	}
+	for _, p := range panels {
+		panel, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if ds, ok := panel["datasource"].(map[string]interface{}); ok {
+			if uid, ok := ds["uid"].(string); ok {
+				uids[uid] = true
+			}
+		}
+		if targets, ok := panel["targets"].([]interface{}); ok {
+			for _, t := range targets {
+				if target, ok := t.(map[string]interface{}); ok {
+					if ds, ok := target["datasource"].(map[string]interface{}); ok {
+						if uid, ok := ds["uid"].(string); ok {
+							uids[uid] = true
+						}
+					}
+				}
+			}
+		}
+		collectDatasourceUIDs(panel["panels"], uids)
+	}
+}
+
+// FetchMetrics retrieves all metric names from a datasource. For Loki
+// datasources it returns one {job="..."} stream selector per job instead.
 func (md *MetricDiscovery) FetchMetrics(dsName string) (map[string]bool, error) {
 	url := md.Config.GetDatasourceURL(dsName)
 	if url == "" {
 		return nil, fmt.Errorf("no URL configured for datasource '%s'", dsName)
 	}
 	key := "metrics:" + dsName
-	if cached, ok := md.cache[key]; ok {
+	if cached, ok := md.cached(key); ok {
 		return cached.(map[string]bool), nil
 	}
-	data, err := md.get(url, "/api/v1/label/__name__/values")
+	label := "__name__"
+	if md.isLoki(dsName) {
+		label = lokiStreamLabel
+	}
+	data, err := md.get(dsName, url, md.apiPath(dsName, fmt.Sprintf("/api/v1/label/%s/values", label)))
 	if err != nil {
 		return nil, err
 	}
@@ -93,25 +364,40 @@ func (md *MetricDiscovery) FetchMetrics(dsName string) (map[string]bool, error)
 	if list, ok := data.([]interface{}); ok {
 		for _, item := range list {
 			if s, ok := item.(string); ok {
+				if md.isLoki(dsName) {
+					s = fmt.Sprintf("{%s=%q}", label, s)
+				}
 				metrics[s] = true
 			}
 		}
 	}
-	md.cache[key] = metrics
+	md.store(key, metrics)
 	return metrics, nil
 }
 
-// FetchMetadata retrieves metric metadata from a datasource.
+// FetchMetadata retrieves metric metadata from a datasource. Loki has no
+// metadata endpoint; its streams are all typed "logs".
 func (md *MetricDiscovery) FetchMetadata(dsName string) (map[string]MetricInfo, error) {
 	url := md.Config.GetDatasourceURL(dsName)
 	if url == "" {
 		return map[string]MetricInfo{}, nil
 	}
+	if md.isLoki(dsName) {
+		streams, err := md.FetchMetrics(dsName)
+		if err != nil {
+			return nil, err
+		}
+		meta := make(map[string]MetricInfo, len(streams))
+		for s := range streams {
+			meta[s] = MetricInfo{Type: "logs", Help: "log stream"}
+		}
+		return meta, nil
+	}
 	key := "metadata:" + dsName
-	if cached, ok := md.cache[key]; ok {
+	if cached, ok := md.cached(key); ok {
 		return cached.(map[string]MetricInfo), nil
 	}
-	data, err := md.get(url, "/api/v1/metadata")
+	data, err := md.get(dsName, url, "/api/v1/metadata")
 	if err != nil {
 		return nil, err
 	}
@@ -132,7 +418,7 @@ func (md *MetricDiscovery) FetchMetadata(dsName string) (map[string]MetricInfo,
 			}
 		}
 	}
-	md.cache[key] = meta
+	md.store(key, meta)
 	return meta, nil
 }
 
@@ -142,7 +428,7 @@ func (md *MetricDiscovery) FetchLabels(dsName string) ([]string, error) {
 	if url == "" {
 		return nil, nil
 	}
-	data, err := md.get(url, "/api/v1/labels")
+	data, err := md.get(dsName, url, md.apiPath(dsName, "/api/v1/labels"))
 	if err != nil {
 		return nil, err
 	}
@@ -163,7 +449,7 @@ func (md *MetricDiscovery) FetchLabelValues(dsName, label string) ([]string, err
 	if url == "" {
 		return nil, nil
 	}
-	data, err := md.get(url, fmt.Sprintf("/api/v1/label/%s/values", label))
+	data, err := md.get(dsName, url, md.apiPath(dsName, fmt.Sprintf("/api/v1/label/%s/values", label)))
 	if err != nil {
 		return nil, err
 	}
@@ -178,6 +464,63 @@ func (md *MetricDiscovery) FetchLabelValues(dsName, label string) ([]string, err
 	return values, nil
 }
 
+// LabelValue is one option of a query variable with the number of series
+// carrying it (0 when the query has no series selector).
+type LabelValue struct {
+	Value string
+	Count int
+}
+
+var labelValuesRe = regexp.MustCompile(`^\s*label_values\(\s*(?:(.+?)\s*,\s*)?([a-zA-Z_][a-zA-Z0-9_]*)\s*\)\s*$`)
+
+// FetchVariableValues runs a label_values() variable query against a
+// datasource. Selectors still containing dashboard variables ($instance)
+// cannot be evaluated outside Grafana, so those fall back to all values of
+// the label.
+func (md *MetricDiscovery) FetchVariableValues(dsName, query string) ([]LabelValue, error) {
+	m := labelValuesRe.FindStringSubmatch(query)
+	if m == nil {
+		return nil, fmt.Errorf("unsupported variable query '%s' (want label_values(...))", query)
+	}
+	match, label := m[1], m[2]
+
+	if match == "" || strings.Contains(match, "$") {
+		values, err := md.FetchLabelValues(dsName, label)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]LabelValue, 0, len(values))
+		for _, v := range values {
+			out = append(out, LabelValue{Value: v})
+		}
+		return out, nil
+	}
+
+	baseURL := md.Config.GetDatasourceURL(dsName)
+	if baseURL == "" {
+		return nil, fmt.Errorf("no URL configured for datasource '%s'", dsName)
+	}
+	data, err := md.get(dsName, baseURL, md.apiPath(dsName, "/api/v1/series?match[]="+url.QueryEscape(match)))
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	if list, ok := data.([]interface{}); ok {
+		for _, item := range list {
+			if series, ok := item.(map[string]interface{}); ok {
+				if v, ok := series[label].(string); ok {
+					counts[v]++
+				}
+			}
+		}
+	}
+	out := make([]LabelValue, 0, len(counts))
+	for _, v := range sortedKeys(counts) {
+		out = append(out, LabelValue{Value: v, Count: counts[v]})
+	}
+	return out, nil
+}
+
 // FetchSeriesMetrics returns metric names that have a specific label=value pair.
 // Uses /api/v1/series?match[]={label="value"} to find matching series.
 func (md *MetricDiscovery) FetchSeriesMetrics(dsName, label, value string) (map[string]bool, error) {
@@ -186,7 +529,7 @@ func (md *MetricDiscovery) FetchSeriesMetrics(dsName, label, value string) (map[
 		return nil, fmt.Errorf("no URL configured for datasource '%s'", dsName)
 	}
 	path := fmt.Sprintf("/api/v1/series?match[]={%s=%q}", label, value)
-	data, err := md.get(baseURL, path)
+	data, err := md.get(dsName, baseURL, md.apiPath(dsName, path))
 	if err != nil {
 		return nil, err
 	}
@@ -209,7 +552,10 @@ func (md *MetricDiscovery) FetchTargets(dsName string) ([]TargetInfo, error) {
 	if baseURL == "" {
 		return nil, fmt.Errorf("no URL configured for datasource '%s'", dsName)
 	}
-	data, err := md.get(baseURL, "/api/v1/targets?state=active")
+	if md.isLoki(dsName) {
+		return nil, fmt.Errorf("datasource '%s' is loki, which has no scrape targets", dsName)
+	}
+	data, err := md.get(dsName, baseURL, "/api/v1/targets?state=active")
 	if err != nil {
 		return nil, err
 	}
@@ -313,22 +659,14 @@ func GroupTargetsByJob(targets []TargetInfo) []JobSummary {
 
 // Categorize compares metrics between two datasources.
 func (md *MetricDiscovery) Categorize(dsA, dsB string) (map[string]map[string]MetricInfo, error) {
-	metricsA, err := md.FetchMetrics(dsA)
-	if err != nil {
-		return nil, err
-	}
-	metricsB, err := md.FetchMetrics(dsB)
-	if err != nil {
-		return nil, err
-	}
-	metaA, err := md.FetchMetadata(dsA)
-	if err != nil {
-		return nil, err
-	}
-	metaB, err := md.FetchMetadata(dsB)
-	if err != nil {
-		return nil, err
+	allMetrics, allMeta, metricErrs, metaErrs := md.fetchAll([]string{dsA, dsB})
+	for _, err := range append(metricErrs, metaErrs...) {
+		if err != nil {
+			return nil, err
+		}
 	}
+	metricsA, metricsB := allMetrics[dsA], allMetrics[dsB]
+	metaA, metaB := allMeta[dsA], allMeta[dsB]
 
 	shared := make(map[string]MetricInfo)
 	onlyA := make(map[string]MetricInfo)
@@ -362,21 +700,14 @@ func (md *MetricDiscovery) CompareAll(dsNames []string) (shared map[string]Metri
 		return nil, nil, fmt.Errorf("need at least 2 datasources")
 	}
 
-	allMetrics := make(map[string]map[string]bool)
-	allMeta := make(map[string]map[string]MetricInfo)
-
-	for _, ds := range dsNames {
-		metrics, ferr := md.FetchMetrics(ds)
-		if ferr != nil {
-			return nil, nil, fmt.Errorf("fetching metrics from %s: %v", ds, ferr)
+	allMetrics, allMeta, metricErrs, _ := md.fetchAll(dsNames)
+	for i, ds := range dsNames {
+		if metricErrs[i] != nil {
+			return nil, nil, fmt.Errorf("fetching metrics from %s: %v", ds, metricErrs[i])
 		}
-		allMetrics[ds] = metrics
-
-		meta, _ := md.FetchMetadata(ds)
-		if meta == nil {
-			meta = make(map[string]MetricInfo)
+		if allMeta[ds] == nil {
+			allMeta[ds] = make(map[string]MetricInfo)
 		}
-		allMeta[ds] = meta
 	}
 
 	// Shared = intersection of all metric sets
@@ -434,6 +765,45 @@ func (md *MetricDiscovery) CompareAll(dsNames []string) (shared map[string]Metri
 	return shared, exclusive, nil
 }
 
+// compareWorkers bounds how many datasources fetchAll queries at once.
+var compareWorkers = 4
+
+// fetchAll fetches metrics and metadata from each datasource concurrently,
+// at most compareWorkers at a time. Errors are returned per datasource, in
+// dsNames order, so callers can report the first one.
+func (md *MetricDiscovery) fetchAll(dsNames []string) (map[string]map[string]bool, map[string]map[string]MetricInfo, []error, []error) {
+	allMetrics := make(map[string]map[string]bool)
+	allMeta := make(map[string]map[string]MetricInfo)
+	metricErrs := make([]error, len(dsNames))
+	metaErrs := make([]error, len(dsNames))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(compareWorkers, 1))
+	for i, ds := range dsNames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			metrics, err := md.FetchMetrics(ds)
+			if err != nil {
+				metricErrs[i] = err
+				return
+			}
+			meta, err := md.FetchMetadata(ds)
+			metaErrs[i] = err
+			mu.Lock()
+			allMetrics[ds] = metrics
+			allMeta[ds] = meta
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return allMetrics, allMeta, metricErrs, metaErrs
+}
+
 func lookupMeta(name string, primary, fallback map[string]MetricInfo) MetricInfo {
 	if info, ok := primary[name]; ok {
 		return info
@@ -444,6 +814,65 @@ func lookupMeta(name string, primary, fallback map[string]MetricInfo) MetricInfo
 	return MetricInfo{Type: "untyped"}
 }
 
+// familySuffixes are the series names a histogram or summary expands to.
+var familySuffixes = []string{"_bucket", "_sum", "_count"}
+
+// familyType returns a metric's type, taking untyped derived series
+// (foo_bucket, foo_sum, foo_count) from their family's metadata.
+func familyType(name string, info MetricInfo, meta map[string]MetricInfo) string {
+	if info.Type != "" && info.Type != "untyped" {
+		return info.Type
+	}
+	for _, suffix := range familySuffixes {
+		if base, ok := strings.CutSuffix(name, suffix); ok {
+			if fam, ok := meta[base]; ok {
+				return fam.Type
+			}
+		}
+	}
+	return "untyped"
+}
+
+// FilterByType filters metrics by include/exclude metric type lists,
+// applied after the name filters.
+func FilterByType(metrics, meta map[string]MetricInfo, include, exclude []string) map[string]MetricInfo {
+	if len(include) == 0 && len(exclude) == 0 {
+		return metrics
+	}
+	filtered := make(map[string]MetricInfo)
+	for m, info := range metrics {
+		t := familyType(m, info, meta)
+		if len(include) > 0 && !slices.Contains(include, t) {
+			continue
+		}
+		if slices.Contains(exclude, t) {
+			continue
+		}
+		filtered[m] = info
+	}
+	return filtered
+}
+
+// filterTypes applies discovery.include_types/exclude_types using the
+// metadata of the given datasources (earlier ones win).
+func (md *MetricDiscovery) filterTypes(metrics map[string]MetricInfo, dsNames ...string) map[string]MetricInfo {
+	disc := md.Config.GetDiscovery()
+	if len(disc.IncludeTypes) == 0 && len(disc.ExcludeTypes) == 0 {
+		return metrics
+	}
+	meta := make(map[string]MetricInfo)
+	for i := len(dsNames) - 1; i >= 0; i-- {
+		m, err := md.FetchMetadata(dsNames[i])
+		if err != nil {
+			continue
+		}
+		for k, v := range m {
+			meta[k] = v
+		}
+	}
+	return FilterByType(metrics, meta, disc.IncludeTypes, disc.ExcludeTypes)
+}
+
 // FilterMetrics filters a metric set by include/exclude glob patterns.
 func FilterMetrics(metrics map[string]bool, include, exclude []string) map[string]bool {
 	if len(include) == 0 {
@@ -506,12 +935,15 @@ func matchGlob(pattern, s string) bool {
 }
 
 // GroupByPrefix groups metrics by first two underscore-delimited segments.
+// Log streams are grouped together under "logs".
 func GroupByPrefix(metrics map[string]MetricInfo) map[string]map[string]MetricInfo {
 	groups := make(map[string]map[string]MetricInfo)
 	for metric, info := range metrics {
 		parts := strings.SplitN(metric, "_", 3)
 		var prefix string
-		if len(parts) >= 2 {
+		if info.Type == "logs" {
+			prefix = "logs"
+		} else if len(parts) >= 2 {
 			prefix = parts[0] + "_" + parts[1]
 		} else {
 			prefix = parts[0]
@@ -524,6 +956,117 @@ func GroupByPrefix(metrics map[string]MetricInfo) map[string]map[string]MetricIn
 	return groups
 }
 
+// ClassifyMetricFamily collapses classic histogram families: each name_bucket
+// for which hasLE reports an le label replaces itself and its name_sum and
+// name_count siblings with one histogram entry under the base name, which
+// SuggestPanel turns into a heatmap. Other metrics pass through unchanged.
+func ClassifyMetricFamily(metrics map[string]MetricInfo, hasLE func(bucket string) bool) map[string]MetricInfo {
+	out := make(map[string]MetricInfo, len(metrics))
+	for name, info := range metrics {
+		out[name] = info
+	}
+	for _, name := range sortedMetricKeys(metrics) {
+		base, ok := strings.CutSuffix(name, "_bucket")
+		if !ok || base == "" || !hasLE(name) {
+			continue
+		}
+		info := MetricInfo{Type: "histogram", Help: metrics[name].Help, Buckets: true}
+		if existing, ok := metrics[base]; ok && existing.Help != "" {
+			info.Help = existing.Help
+		}
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			delete(out, base+suffix)
+		}
+		out[base] = info
+	}
+	return out
+}
+
+// hasLELabel returns an le-label probe for ClassifyMetricFamily. A family
+// the metadata types as histogram counts without asking, and one typed as
+// anything else does not. Only untyped families are checked against the
+// datasource, using the leMetrics set fetched once per discovery cache.
+// Probe failures count as no le label, leaving the series as plain metrics.
+func (md *MetricDiscovery) hasLELabel(dsName string, meta map[string]MetricInfo) func(string) bool {
+	var withLE map[string]bool
+	return func(bucket string) bool {
+		switch meta[strings.TrimSuffix(bucket, "_bucket")].Type {
+		case "histogram":
+			return true
+		case "", "untyped", "unknown":
+		default:
+			return false
+		}
+		if withLE == nil {
+			withLE = md.leMetrics(dsName)
+		}
+		return withLE[bucket]
+	}
+}
+
+// leMetrics returns the names of metrics that carried an le label during
+// the last hour, asked for in a single label values request.
+func (md *MetricDiscovery) leMetrics(dsName string) map[string]bool {
+	key := "le:" + dsName
+	if cached, ok := md.cached(key); ok {
+		return cached.(map[string]bool)
+	}
+	names := make(map[string]bool)
+	baseURL := md.Config.GetDatasourceURL(dsName)
+	if baseURL == "" || md.isLoki(dsName) {
+		return names
+	}
+	path := fmt.Sprintf("/api/v1/label/__name__/values?match[]=%s&start=%d",
+		url.QueryEscape(`{le!=""}`), time.Now().Add(-time.Hour).Unix())
+	data, err := md.get(dsName, baseURL, path)
+	if err != nil {
+		return names
+	}
+	list, _ := data.([]interface{})
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			names[s] = true
+		}
+	}
+	md.store(key, names)
+	return names
+}
+
+// SuggestPanel returns a suggested panel config (type, title, query) for a
+// discovered metric. Histogram bucket families get a heatmap over the
+// per-le bucket rates with y_unit inferred from the base name.
+func SuggestPanel(metric string, info MetricInfo) map[string]interface{} {
+	if info.Buckets {
+		return map[string]interface{}{
+			"type":   "heatmap",
+			"title":  metric,
+			"query":  fmt.Sprintf("sum by (le) (rate(%s_bucket[5m]))", metric),
+			"legend": "{{le}}",
+			"y_unit": bucketUnit(metric),
+		}
+	}
+	return map[string]interface{}{
+		"type":  SuggestPanelType(info.Type),
+		"title": metric,
+		"query": SuggestQuery(metric, info.Type),
+	}
+}
+
+// bucketUnit infers a Grafana unit from a histogram's base metric name
+// following Prometheus naming conventions.
+func bucketUnit(metric string) string {
+	switch {
+	case strings.HasSuffix(metric, "_seconds"):
+		return "s"
+	case strings.HasSuffix(metric, "_milliseconds"):
+		return "ms"
+	case strings.HasSuffix(metric, "_bytes"):
+		return "bytes"
+	default:
+		return "short"
+	}
+}
+
 // SuggestPanelType returns a suggested panel type for a metric type.
 func SuggestPanelType(metricType string) string {
 	switch metricType {
@@ -535,12 +1078,15 @@ func SuggestPanelType(metricType string) string {
 		return "heatmap"
 	case "summary":
 		return "timeseries"
+	case "logs":
+		return "logs"
 	default:
 		return "timeseries"
 	}
 }
 
-// SuggestQuery returns a suggested PromQL query for a metric.
+// SuggestQuery returns a suggested PromQL query for a metric. Log streams are
+// already LogQL selectors and are returned unchanged.
 func SuggestQuery(metricName, metricType string) string {
 	if metricType == "counter" {
 		return fmt.Sprintf("rate(%s[5m])", metricName)
@@ -556,7 +1102,10 @@ func (md *MetricDiscovery) PrintDiscovery(sources, includePatterns, excludePatte
 	if len(sources) == 2 {
 		return md.printComparisonDiscovery(sources, includePatterns, excludePatterns)
 	}
-	return fmt.Errorf("discovery supports 1 or 2 datasources, got %d", len(sources))
+	if len(sources) > 2 {
+		return md.printMultiDiscovery(sources, includePatterns, excludePatterns)
+	}
+	return fmt.Errorf("discovery needs at least 1 datasource")
 }
 
 func (md *MetricDiscovery) printSingleDiscovery(dsName string, include, exclude []string) error {
@@ -578,8 +1127,10 @@ func (md *MetricDiscovery) printSingleDiscovery(dsName string, include, exclude
 			enriched[m] = MetricInfo{Type: "untyped"}
 		}
 	}
+	enriched = ClassifyMetricFamily(enriched, md.hasLELabel(dsName, meta))
+	enriched = md.filterTypes(enriched, dsName)
 
-	fmt.Printf("\n=== Metrics from %s: %d total ===\n\n", dsName, len(metrics))
+	fmt.Printf("\n=== Metrics from %s: %d total ===\n\n", dsName, len(enriched))
 	grouped := GroupByPrefix(enriched)
 	prefixes := sortedKeys(grouped)
 	for _, prefix := range prefixes {
@@ -587,7 +1138,7 @@ func (md *MetricDiscovery) printSingleDiscovery(dsName string, include, exclude
 		fmt.Printf("# %s_* (%d metrics)\n", prefix, len(items))
 		for _, m := range sortedMetricKeys(items) {
 			info := items[m]
-			panel := SuggestPanelType(info.Type)
+			panel := SuggestPanel(m, info)["type"]
 			fmt.Printf("  %-60s (%-10s) -> %s\n", m, info.Type, panel)
 		}
 		fmt.Println()
@@ -597,28 +1148,29 @@ func (md *MetricDiscovery) printSingleDiscovery(dsName string, include, exclude
 	return nil
 }
 
+// filterCategory applies the include/exclude patterns and the type filter of
+// sources to one category of compared metrics.
+func (md *MetricDiscovery) filterCategory(m map[string]MetricInfo, sources, include, exclude []string) map[string]MetricInfo {
+	keys := make(map[string]bool)
+	for k := range m {
+		keys[k] = true
+	}
+	result := make(map[string]MetricInfo)
+	for k := range FilterMetrics(keys, include, exclude) {
+		result[k] = m[k]
+	}
+	return md.filterTypes(result, sources...)
+}
+
 func (md *MetricDiscovery) printComparisonDiscovery(sources, include, exclude []string) error {
 	cats, err := md.Categorize(sources[0], sources[1])
 	if err != nil {
 		return err
 	}
 
-	// filter each category
-	filterMap := func(m map[string]MetricInfo) map[string]MetricInfo {
-		keys := make(map[string]bool)
-		for k := range m {
-			keys[k] = true
-		}
-		filtered := FilterMetrics(keys, include, exclude)
-		result := make(map[string]MetricInfo)
-		for k := range filtered {
-			result[k] = m[k]
-		}
-		return result
-	}
-	cats["shared"] = filterMap(cats["shared"])
-	cats["only_a"] = filterMap(cats["only_a"])
-	cats["only_b"] = filterMap(cats["only_b"])
+	cats["shared"] = md.filterCategory(cats["shared"], sources, include, exclude)
+	cats["only_a"] = md.filterCategory(cats["only_a"], sources, include, exclude)
+	cats["only_b"] = md.filterCategory(cats["only_b"], sources, include, exclude)
 
 	fmt.Printf("\n=== Metric Comparison ===\n")
 	fmt.Printf("  %s: %d metrics\n", sources[0], len(cats["only_a"])+len(cats["shared"]))
@@ -649,6 +1201,87 @@ func (md *MetricDiscovery) printComparisonDiscovery(sources, include, exclude []
 	return nil
 }
 
+// printMultiDiscovery compares three or more datasources with CompareAll:
+// metrics on every datasource become comparison panels, and each
+// datasource's exclusive metrics get their own section. Metrics on some but
+// not all datasources are left out.
+func (md *MetricDiscovery) printMultiDiscovery(sources, include, exclude []string) error {
+	shared, exclusive, err := md.CompareAll(sources)
+	if err != nil {
+		return err
+	}
+
+	shared = md.filterCategory(shared, sources, include, exclude)
+	for _, ds := range sources {
+		exclusive[ds] = md.filterCategory(exclusive[ds], sources, include, exclude)
+	}
+
+	fmt.Printf("\n=== Metric Comparison (%d datasources) ===\n", len(sources))
+	fmt.Printf("  shared by all: %d\n", len(shared))
+	for _, ds := range sources {
+		fmt.Printf("  %s only: %d\n", ds, len(exclusive[ds]))
+	}
+
+	fmt.Printf("\n--- Shared Metrics (%d) ---\n", len(shared))
+	for _, m := range sortedMetricKeys(shared) {
+		fmt.Printf("  %-60s (%s)\n", m, shared[m].Type)
+	}
+	for _, ds := range sources {
+		fmt.Printf("\n--- %s Only (%d) ---\n", ds, len(exclusive[ds]))
+		for _, m := range sortedMetricKeys(exclusive[ds]) {
+			fmt.Printf("  %-60s (%s)\n", m, exclusive[ds][m].Type)
+		}
+	}
+
+	md.printMultiComparisonYAML(shared, exclusive, sources)
+	return nil
+}
+
+// printComparisonYAMLHeader prints the dashboard stanza shared by the two- and
+// multi-datasource comparison snippets, up to the sections key.
+func printComparisonYAMLHeader() {
+	fmt.Print("\n# --- suggested comparison YAML snippet ---\n\n")
+	fmt.Println("dashboards:")
+	fmt.Println("  comparison:")
+	fmt.Println("    uid: metric-comparison")
+	fmt.Println("    title: metric comparison")
+	fmt.Println("    filename: metric-comparison.json")
+	fmt.Println("    tags: [comparison]")
+	fmt.Println("    variables: []")
+	fmt.Println("    sections:")
+}
+
+func (md *MetricDiscovery) printMultiComparisonYAML(shared map[string]MetricInfo, exclusive map[string]map[string]MetricInfo, sources []string) {
+	printComparisonYAMLHeader()
+
+	if len(shared) > 0 {
+		fmt.Println("      - title: \"shared metrics\"")
+		fmt.Println("        panels:")
+		for _, m := range sortedMetricKeys(shared) {
+			fmt.Println("          - type: comparison")
+			fmt.Printf("            title: \"%s\"\n", m)
+			fmt.Printf("            metric: \"%s\"\n", m)
+			fmt.Printf("            metric_type: \"%s\"\n", shared[m].Type)
+			fmt.Printf("            datasources: [%s]\n", strings.Join(sources, ", "))
+		}
+	}
+
+	for _, ds := range sources {
+		if len(exclusive[ds]) == 0 {
+			continue
+		}
+		fmt.Printf("      - title: \"%s only\"\n", ds)
+		fmt.Println("        panels:")
+		for _, m := range sortedMetricKeys(exclusive[ds]) {
+			info := exclusive[ds][m]
+			fmt.Printf("          - type: %s\n", SuggestPanelType(info.Type))
+			fmt.Printf("            title: \"%s\"\n", m)
+			fmt.Printf("            query: '%s'\n", SuggestQuery(m, info.Type))
+			fmt.Printf("            datasource: %s\n", ds)
+		}
+	}
+}
+
 func (md *MetricDiscovery) printYAMLSnippet(grouped map[string]map[string]MetricInfo, dsName string) {
 	fmt.Print("\n# --- suggested YAML config snippet ---\n\n")
 	fmt.Println("dashboards:")
@@ -664,26 +1297,22 @@ func (md *MetricDiscovery) printYAMLSnippet(grouped map[string]map[string]Metric
 		fmt.Printf("      - title: \"%s\"\n", prefix)
 		fmt.Println("        panels:")
 		for _, m := range sortedMetricKeys(items) {
-			info := items[m]
-			panel := SuggestPanelType(info.Type)
-			query := SuggestQuery(m, info.Type)
-			fmt.Printf("          - type: %s\n", panel)
+			panel := SuggestPanel(m, items[m])
+			fmt.Printf("          - type: %s\n", panel["type"])
 			fmt.Printf("            title: \"%s\"\n", m)
-			fmt.Printf("            query: '%s'\n", query)
+			fmt.Printf("            query: '%s'\n", panel["query"])
+			if legend, ok := panel["legend"]; ok {
+				fmt.Printf("            legend: '%s'\n", legend)
+			}
+			if unit, ok := panel["y_unit"]; ok {
+				fmt.Printf("            y_unit: %s\n", unit)
+			}
 		}
 	}
 }
 
 func (md *MetricDiscovery) printComparisonYAML(cats map[string]map[string]MetricInfo, sources []string) {
-	fmt.Print("\n# --- suggested comparison YAML snippet ---\n\n")
-	fmt.Println("dashboards:")
-	fmt.Println("  comparison:")
-	fmt.Println("    uid: metric-comparison")
-	fmt.Println("    title: metric comparison")
-	fmt.Println("    filename: metric-comparison.json")
-	fmt.Println("    tags: [comparison]")
-	fmt.Println("    variables: []")
-	fmt.Println("    sections:")
+	printComparisonYAMLHeader()
 
 	if len(cats["shared"]) > 0 {
 		fmt.Println("      - title: \"shared metrics\"")
@@ -751,19 +1380,17 @@ func (md *MetricDiscovery) GenerateDiscoverySections(sources, include, exclude [
 				enriched[m] = MetricInfo{Type: "untyped"}
 			}
 		}
+		enriched = ClassifyMetricFamily(enriched, md.hasLELabel(dsName, meta))
+		enriched = md.filterTypes(enriched, dsName)
 
 		grouped := GroupByPrefix(enriched)
 		for _, prefix := range sortedKeys(grouped) {
 			items := grouped[prefix]
 			var panels []map[string]interface{}
 			for _, m := range sortedMetricKeys(items) {
-				info := items[m]
-				panels = append(panels, map[string]interface{}{
-					"type":       SuggestPanelType(info.Type),
-					"title":      m,
-					"query":      SuggestQuery(m, info.Type),
-					"datasource": dsName,
-				})
+				panel := SuggestPanel(m, items[m])
+				panel["datasource"] = dsName
+				panels = append(panels, panel)
 			}
 			sections = append(sections, config.SectionConfig{
 				Title:  prefix,
@@ -776,21 +1403,9 @@ func (md *MetricDiscovery) GenerateDiscoverySections(sources, include, exclude [
 			return nil, err
 		}
 
-		filterMap := func(m map[string]MetricInfo) map[string]MetricInfo {
-			keys := make(map[string]bool)
-			for k := range m {
-				keys[k] = true
-			}
-			filtered := FilterMetrics(keys, include, exclude)
-			result := make(map[string]MetricInfo)
-			for k := range filtered {
-				result[k] = m[k]
-			}
-			return result
-		}
-		cats["shared"] = filterMap(cats["shared"])
-		cats["only_a"] = filterMap(cats["only_a"])
-		cats["only_b"] = filterMap(cats["only_b"])
+		cats["shared"] = md.filterCategory(cats["shared"], sources, include, exclude)
+		cats["only_a"] = md.filterCategory(cats["only_a"], sources, include, exclude)
+		cats["only_b"] = md.filterCategory(cats["only_b"], sources, include, exclude)
 
 		if len(cats["shared"]) > 0 {
 			var panels []map[string]interface{}
@@ -833,6 +1448,68 @@ func (md *MetricDiscovery) GenerateDiscoverySections(sources, include, exclude [
 	return sections, nil
 }
 
+type snippetPanel struct {
+	Type       string `yaml:"type"`
+	Title      string `yaml:"title"`
+	Query      string `yaml:"query"`
+	Legend     string `yaml:"legend,omitempty"`
+	YUnit      string `yaml:"y_unit,omitempty"`
+	Datasource string `yaml:"datasource,omitempty"`
+}
+
+type snippetSection struct {
+	Title  string         `yaml:"title"`
+	Panels []snippetPanel `yaml:"panels"`
+}
+
+type snippetDashboard struct {
+	UID       string           `yaml:"uid"`
+	Title     string           `yaml:"title"`
+	Filename  string           `yaml:"filename"`
+	Tags      []string         `yaml:"tags,flow"`
+	Variables []string         `yaml:"variables,flow"`
+	Sections  []snippetSection `yaml:"sections"`
+}
+
+// DiscoverySnippet runs single-source discovery and renders the resulting
+// sections as a dashboards YAML snippet, along with the panel count.
+func (md *MetricDiscovery) DiscoverySnippet(dsName string, include, exclude []string) (string, int, error) {
+	sections, err := md.GenerateDiscoverySections([]string{dsName}, include, exclude)
+	if err != nil {
+		return "", 0, err
+	}
+	db := snippetDashboard{
+		UID:       "discovered-" + dsName,
+		Title:     fmt.Sprintf("discovered metrics (%s)", dsName),
+		Filename:  fmt.Sprintf("discovered-%s.json", dsName),
+		Tags:      []string{"discovered"},
+		Variables: []string{},
+	}
+	count := 0
+	for _, section := range sections {
+		ss := snippetSection{Title: section.Title}
+		for _, p := range section.Panels {
+			ss.Panels = append(ss.Panels, snippetPanel{
+				Type:       getString(p, "type", ""),
+				Title:      getString(p, "title", ""),
+				Query:      getString(p, "query", ""),
+				Legend:     getString(p, "legend", ""),
+				YUnit:      getString(p, "y_unit", ""),
+				Datasource: getString(p, "datasource", ""),
+			})
+			count++
+		}
+		db.Sections = append(db.Sections, ss)
+	}
+	out, err := yaml.Marshal(map[string]map[string]snippetDashboard{
+		"dashboards": {"discovered": db},
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return string(out), count, nil
+}
+
 func sortedKeys[V any](m map[string]V) []string {
 	keys := make([]string, 0, len(m))
 	for k := range m {
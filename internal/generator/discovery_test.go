@@ -1,6 +1,21 @@
 package generator
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+)
 
 func TestFilterMetrics(t *testing.T) {
 	metrics := map[string]bool{
@@ -126,3 +141,842 @@ func TestSuggestQuery(t *testing.T) {
 		}
 	}
 }
+
+func TestClassifyMetricFamily(t *testing.T) {
+	probes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			// the le probe asks once for every name carrying an le label
+			if r.URL.Query().Get("match[]") == `{le!=""}` {
+				probes++
+				w.Write([]byte(`{"status":"success","data":["http_request_duration_seconds_bucket"]}`))
+				return
+			}
+			w.Write([]byte(`{"status":"success","data":["http_request_duration_seconds_bucket","http_request_duration_seconds_sum","http_request_duration_seconds_count","queue_bucket","rpc_latency_seconds_bucket","jobs_bucket","up"]}`))
+		case "/api/v1/metadata":
+			w.Write([]byte(`{"status":"success","data":{"up":[{"type":"gauge","help":"target up"}],"rpc_latency_seconds":[{"type":"histogram","help":"rpc latency"}],"jobs":[{"type":"counter","help":"jobs per bucket"}]}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  prom:
+    type: prometheus
+    uid: prom
+    url: ` + srv.URL + `
+dashboards: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := NewMetricDiscovery(cfg)
+	sections, err := md.GenerateDiscoverySections([]string{"prom"}, nil, nil)
+	if err != nil {
+		t.Fatalf("GenerateDiscoverySections error: %v", err)
+	}
+
+	panels := make(map[string]map[string]interface{})
+	for _, section := range sections {
+		for _, p := range section.Panels {
+			panels[p["title"].(string)] = p
+		}
+	}
+	if len(panels) != 5 {
+		t.Fatalf("panels = %v, want the family collapsed to 5 panels", panels)
+	}
+	if probes != 1 {
+		t.Errorf("le probes = %d, want 1 for all untyped families", probes)
+	}
+	// typed as histogram in metadata: no probe needed
+	if p := panels["rpc_latency_seconds"]; p == nil || p["type"] != "heatmap" {
+		t.Errorf("rpc_latency_seconds panel = %v, want heatmap", p)
+	}
+	// typed as something else: never a histogram
+	if p := panels["jobs_bucket"]; p == nil || p["type"] == "heatmap" {
+		t.Errorf("jobs_bucket panel = %v, want a plain metric", p)
+	}
+	hist, ok := panels["http_request_duration_seconds"]
+	if !ok {
+		t.Fatalf("no panel for the histogram base name: %v", panels)
+	}
+	if hist["type"] != "heatmap" || hist["y_unit"] != "s" || hist["legend"] != "{{le}}" {
+		t.Errorf("histogram panel = %v", hist)
+	}
+	if hist["query"] != "sum by (le) (rate(http_request_duration_seconds_bucket[5m]))" {
+		t.Errorf("histogram query = %v", hist["query"])
+	}
+	// a _bucket name without le stays a plain metric
+	if p := panels["queue_bucket"]; p == nil || p["type"] != "timeseries" {
+		t.Errorf("queue_bucket panel = %v, want timeseries", p)
+	}
+	if p := panels["up"]; p == nil || p["type"] != "stat" {
+		t.Errorf("up panel = %v, want stat", p)
+	}
+
+	// the bucket family collapses even when _sum/_count are absent
+	got := ClassifyMetricFamily(map[string]MetricInfo{
+		"rpc_size_bytes_bucket": {Type: "untyped"},
+	}, func(string) bool { return true })
+	if info, ok := got["rpc_size_bytes"]; !ok || !info.Buckets || len(got) != 1 {
+		t.Errorf("ClassifyMetricFamily = %v", got)
+	}
+	if unit := SuggestPanel("rpc_size_bytes", got["rpc_size_bytes"])["y_unit"]; unit != "bytes" {
+		t.Errorf("y_unit = %v, want bytes", unit)
+	}
+}
+
+func TestRequireReachable(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	dead.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(`
+generator:
+  require_datasources: true
+datasources:
+  live:
+    type: prometheus
+    uid: live
+    url: "` + healthy.URL + `"
+    is_default: true
+  gone:
+    type: prometheus
+    uid: gone
+    url: "` + dead.URL + `"
+dashboards:
+  ok:
+    uid: ok
+    title: ok
+    sections:
+      - title: s
+        panels:
+          - { type: stat, title: a, query: up }
+  broken:
+    uid: broken
+    title: broken
+    sections:
+      - title: s
+        collapsed: true
+        panels:
+          - { type: stat, title: b, query: up, datasource: gone }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+	disc := NewMetricDiscovery(cfg)
+
+	okDash, err := builder.Build(cfg.Dashboards["ok"], nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := disc.RequireReachable(okDash); err != nil {
+		t.Errorf("healthy datasource reported as failing: %v", err)
+	}
+
+	brokenDash, err := builder.Build(cfg.Dashboards["broken"], nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := disc.RequireReachable(brokenDash); err == nil {
+		t.Error("expected error for unreachable datasource")
+	}
+}
+
+func TestCheckHealthPerType(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/-/healthy", "/ready", "/_cluster/health":
+			w.WriteHeader(http.StatusOK)
+		case "/ping":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  prom: { type: prometheus, uid: prom, url: "` + srv.URL + `" }
+  logs: { type: loki, uid: logs, url: "` + srv.URL + `" }
+  influx: { type: influxdb, uid: influx, url: "` + srv.URL + `" }
+  es: { type: elasticsearch, uid: es, url: "` + srv.URL + `" }
+  traces: { type: tempo, uid: traces, url: "` + srv.URL + `" }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := NewMetricDiscovery(cfg)
+	for _, name := range []string{"prom", "logs", "influx", "es", "traces"} {
+		if err := md.CheckHealth(name); err != nil {
+			t.Errorf("CheckHealth(%s) error: %v", name, err)
+		}
+	}
+	want := []string{"/-/healthy", "/ready", "/ping", "/_cluster/health"}
+	if strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Errorf("probed %v, want %v (tempo skipped)", paths, want)
+	}
+	if md.CanCheckHealth("traces") {
+		t.Error("tempo datasource should not be probed")
+	}
+}
+
+func TestFetchVariableValues(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/series":
+			if got := r.URL.Query().Get("match[]"); got != `up{job="node"}` {
+				t.Errorf("match[] = %s, want up{job=\"node\"}", got)
+			}
+			w.Write([]byte(`{"status":"success","data":[
+				{"__name__":"up","job":"node","instance":"a:9100"},
+				{"__name__":"up","job":"node","instance":"b:9100"},
+				{"__name__":"up","job":"node","instance":"b:9100","extra":"x"}]}`))
+		case "/api/v1/label/instance/values":
+			w.Write([]byte(`{"status":"success","data":["a:9100","b:9100","c:9100"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+    url: ` + srv.URL + `
+dashboards: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := NewMetricDiscovery(cfg)
+
+	values, err := md.FetchVariableValues("primary", `label_values(up{job="node"}, instance)`)
+	if err != nil {
+		t.Fatalf("FetchVariableValues error: %v", err)
+	}
+	if len(values) != 2 || values[0] != (LabelValue{"a:9100", 1}) || values[1] != (LabelValue{"b:9100", 2}) {
+		t.Errorf("values = %+v, want a:9100(1) b:9100(2)", values)
+	}
+
+	// selectors with dashboard variables fall back to all label values
+	values, err = md.FetchVariableValues("primary", `label_values(up{job=~"$job"}, instance)`)
+	if err != nil {
+		t.Fatalf("FetchVariableValues error: %v", err)
+	}
+	if len(values) != 3 {
+		t.Errorf("fallback values = %d, want 3", len(values))
+	}
+
+	if _, err := md.FetchVariableValues("primary", "query_result(up)"); err == nil {
+		t.Error("expected error for non label_values query")
+	}
+}
+
+func TestDiscoveryExcludeTypes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			w.Write([]byte(`{"status":"success","data":[
+				"http_requests_total",
+				"http_request_duration_seconds_bucket",
+				"http_request_duration_seconds_sum",
+				"http_request_duration_seconds_count",
+				"process_resident_memory_bytes"]}`))
+		case "/api/v1/metadata":
+			w.Write([]byte(`{"status":"success","data":{
+				"http_requests_total":[{"type":"counter","help":""}],
+				"http_request_duration_seconds":[{"type":"histogram","help":""}],
+				"process_resident_memory_bytes":[{"type":"gauge","help":""}]}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+    url: ` + srv.URL + `
+discovery:
+  enabled: true
+  sources: [primary]
+  exclude_types: [histogram]
+dashboards: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := NewMetricDiscovery(cfg)
+	sections, err := md.GenerateDiscoverySections([]string{"primary"}, nil, nil)
+	if err != nil {
+		t.Fatalf("GenerateDiscoverySections error: %v", err)
+	}
+	var titles []string
+	for _, s := range sections {
+		for _, p := range s.Panels {
+			titles = append(titles, p["title"].(string))
+		}
+	}
+	if len(titles) != 2 {
+		t.Fatalf("discovered %v, want only the counter and gauge", titles)
+	}
+	for _, title := range titles {
+		if strings.HasPrefix(title, "http_request_duration_seconds") {
+			t.Errorf("histogram series %s should be excluded", title)
+		}
+	}
+}
+
+func TestDiscoveryEnvURL(t *testing.T) {
+	serve := func(metric string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"status":"success","data":["` + metric + `"]}`))
+		}))
+	}
+	prod, staging := serve("prod_metric"), serve("staging_metric")
+	defer prod.Close()
+	defer staging.Close()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+    url: http://unused.invalid
+    urls:
+      prod: `+prod.URL+`
+      staging: `+staging.URL+`
+dashboards: {}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for env, want := range map[string]string{"staging": "staging_metric", "prod": "prod_metric"} {
+		cfg, err := config.Load(path, map[string]string{"env": env})
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics, err := NewMetricDiscovery(cfg).FetchMetrics("primary")
+		if err != nil {
+			t.Fatalf("env %s: FetchMetrics error: %v", env, err)
+		}
+		if !metrics[want] || len(metrics) != 1 {
+			t.Errorf("env %s: metrics = %v, want only %s", env, metrics, want)
+		}
+	}
+
+	// unknown environments fall back to url
+	cfg, err := config.Load(path, map[string]string{"env": "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.GetDatasourceURL("primary"); got != "http://unused.invalid" {
+		t.Errorf("fallback url = %s, want http://unused.invalid", got)
+	}
+}
+
+func TestDiscoverySnippet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			w.Write([]byte(`{"status":"success","data":["node_load1","node_cpu_seconds_total","node_disk_io_seconds_bucket","go_goroutines"]}`))
+		case "/api/v1/metadata":
+			w.Write([]byte(`{"status":"success","data":{
+				"node_load1":[{"type":"gauge","help":"load"}],
+				"node_cpu_seconds_total":[{"type":"counter","help":"cpu"}],
+				"node_disk_io_seconds":[{"type":"histogram","help":"disk io"}]}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+    url: ` + srv.URL + `
+dashboards: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snippet, count, err := NewMetricDiscovery(cfg).DiscoverySnippet("primary", []string{"node_*"}, nil)
+	if err != nil {
+		t.Fatalf("DiscoverySnippet error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("count = %d, want 3", count)
+	}
+	for _, want := range []string{
+		"uid: discovered-primary",
+		"title: node_load1",
+		"query: rate(node_cpu_seconds_total[5m])",
+		"datasource: primary",
+		"type: heatmap",
+		"legend: '{{le}}'",
+		"y_unit: s",
+	} {
+		if !strings.Contains(snippet, want) {
+			t.Errorf("snippet missing %q:\n%s", want, snippet)
+		}
+	}
+	if strings.Contains(snippet, "go_goroutines") {
+		t.Error("excluded metric go_goroutines in snippet")
+	}
+
+	// the snippet must round-trip as config
+	if _, err := config.LoadFromBytes([]byte(snippet)); err != nil {
+		t.Errorf("snippet does not parse as config: %v", err)
+	}
+}
+
+func TestDiscoveryTrace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			w.Write([]byte(`{"status":"success","data":["up"]}`))
+		case "/api/v1/label/instance/values":
+			w.Write([]byte(`{"status":"success","data":["a:9100"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+    url: ` + srv.URL + `
+dashboards: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	md := NewMetricDiscovery(cfg)
+	md.Trace = slog.New(slog.NewJSONHandler(&buf, nil))
+
+	if _, err := md.FetchMetrics("primary"); err != nil {
+		t.Fatalf("FetchMetrics error: %v", err)
+	}
+	if _, err := md.FetchVariableValues("primary", "label_values(instance)"); err != nil {
+		t.Fatalf("FetchVariableValues error: %v", err)
+	}
+
+	var paths []string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec map[string]interface{}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec["msg"] != "discovery request" {
+			t.Errorf("msg = %v, want discovery request", rec["msg"])
+		}
+		if _, ok := rec["duration"]; !ok {
+			t.Errorf("record %v has no duration", rec)
+		}
+		if rec["status"] != float64(200) {
+			t.Errorf("status = %v, want 200", rec["status"])
+		}
+		paths = append(paths, rec["path"].(string))
+	}
+	want := []string{"/api/v1/label/__name__/values", "/api/v1/label/instance/values"}
+	if strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Errorf("traced paths = %v, want %v", paths, want)
+	}
+}
+
+func TestDiscoveryPathFamilies(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			w.Write([]byte(`{"status":"success","data":["up","node_load1"]}`))
+		case "/api/v1/labels", "/loki/api/v1/labels":
+			w.Write([]byte(`{"status":"success","data":["job","instance"]}`))
+		case "/loki/api/v1/label/job/values":
+			w.Write([]byte(`{"status":"success","data":["varlogs","nginx"]}`))
+		case "/ready":
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  prom:
+    type: prometheus
+    uid: prom
+    url: ` + srv.URL + `
+  logs:
+    type: loki
+    uid: loki
+    url: ` + srv.URL + `
+dashboards: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := NewMetricDiscovery(cfg)
+
+	metrics, err := md.FetchMetrics("prom")
+	if err != nil {
+		t.Fatalf("FetchMetrics(prom) error: %v", err)
+	}
+	if !metrics["up"] || len(metrics) != 2 {
+		t.Errorf("prometheus metrics = %v", metrics)
+	}
+
+	streams, err := md.FetchMetrics("logs")
+	if err != nil {
+		t.Fatalf("FetchMetrics(logs) error: %v", err)
+	}
+	if !streams[`{job="varlogs"}`] || !streams[`{job="nginx"}`] || len(streams) != 2 {
+		t.Errorf("loki streams = %v", streams)
+	}
+	for _, ds := range []string{"prom", "logs"} {
+		labels, err := md.FetchLabels(ds)
+		if err != nil || len(labels) != 2 {
+			t.Errorf("FetchLabels(%s) = %v, %v", ds, labels, err)
+		}
+	}
+	if err := md.CheckHealth("logs"); err != nil {
+		t.Errorf("CheckHealth(logs) error: %v", err)
+	}
+	want := []string{"/api/v1/label/__name__/values", "/loki/api/v1/label/job/values", "/api/v1/labels", "/loki/api/v1/labels", "/ready"}
+	if strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Errorf("paths = %v, want %v", paths, want)
+	}
+
+	sections, err := md.GenerateDiscoverySections([]string{"logs"}, nil, nil)
+	if err != nil {
+		t.Fatalf("GenerateDiscoverySections error: %v", err)
+	}
+	if len(sections) != 1 || sections[0].Title != "logs" {
+		t.Fatalf("sections = %+v, want one logs section", sections)
+	}
+	panel := sections[0].Panels[0]
+	if panel["type"] != "logs" || panel["query"] != `{job="nginx"}` {
+		t.Errorf("panel = %v, want logs panel for {job=\"nginx\"}", panel)
+	}
+
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+	built, err := pf.FromConfig(panel, 0, 0)
+	if err != nil {
+		t.Fatalf("FromConfig error: %v", err)
+	}
+	target := built["targets"].([]interface{})[0].(map[string]interface{})
+	if target["expr"] != `{job="nginx"}` || target["queryType"] != "range" {
+		t.Errorf("target = %v, want LogQL range target", target)
+	}
+	if _, ok := target["legendFormat"]; ok {
+		t.Error("logs target should not carry legendFormat")
+	}
+	if _, ok := target["range"]; ok {
+		t.Error("loki target should not use the PromQL range flag")
+	}
+}
+
+func TestDiscoveryRetry(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/api/v1/label/__name__/values":
+			if calls <= 2 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte(`{"status":"success","data":["up"]}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	load := func(retries int) *MetricDiscovery {
+		cfg, err := config.LoadFromBytes([]byte(fmt.Sprintf(`
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+    url: %s
+discovery:
+  retries: %d
+  retry_backoff: 1ms
+dashboards: {}
+`, srv.URL, retries)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewMetricDiscovery(cfg)
+	}
+
+	metrics, err := load(2).FetchMetrics("primary")
+	if err != nil {
+		t.Fatalf("FetchMetrics error: %v", err)
+	}
+	if !metrics["up"] || calls != 3 {
+		t.Errorf("metrics = %v after %d calls, want up on the third", metrics, calls)
+	}
+
+	calls = 0
+	if _, err := load(1).FetchMetrics("primary"); err == nil {
+		t.Error("expected error when retries run out")
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2", calls)
+	}
+
+	// 4xx fails fast
+	calls = 0
+	if _, err := load(3).FetchLabels("primary"); err == nil || !strings.Contains(err.Error(), "HTTP 400") {
+		t.Errorf("err = %v, want HTTP 400", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1 for a 4xx", calls)
+	}
+}
+
+func TestCompareAllConcurrent(t *testing.T) {
+	sets := map[string]string{
+		"a": `["up","node_load1","shared_total"]`,
+		"b": `["up","http_requests_total","shared_total"]`,
+		"c": `["up","shared_total","c_only"]`,
+		"d": `["up","shared_total"]`,
+		"e": `["up","shared_total","e_only"]`,
+		"f": `["up","shared_total","node_load1"]`,
+	}
+	var cfgYAML strings.Builder
+	cfgYAML.WriteString("datasources:\n")
+	for _, name := range sortedKeys(sets) {
+		metrics := sets[name]
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/v1/label/__name__/values":
+				w.Write([]byte(`{"status":"success","data":` + metrics + `}`))
+			case "/api/v1/metadata":
+				w.Write([]byte(`{"status":"success","data":{"shared_total":[{"type":"counter","help":""}]}}`))
+			}
+		}))
+		defer srv.Close()
+		fmt.Fprintf(&cfgYAML, "  %s:\n    type: prometheus\n    uid: %s\n    url: %s\n", name, name, srv.URL)
+	}
+	cfg, err := config.LoadFromBytes([]byte(cfgYAML.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := sortedKeys(sets)
+
+	defer func(n int) { compareWorkers = n }(compareWorkers)
+	compareWorkers = 1
+	seqShared, seqExclusive, err := NewMetricDiscovery(cfg).CompareAll(names)
+	if err != nil {
+		t.Fatalf("sequential CompareAll error: %v", err)
+	}
+	compareWorkers = 4
+	shared, exclusive, err := NewMetricDiscovery(cfg).CompareAll(names)
+	if err != nil {
+		t.Fatalf("concurrent CompareAll error: %v", err)
+	}
+
+	seq, _ := json.Marshal([]interface{}{seqShared, seqExclusive})
+	par, _ := json.Marshal([]interface{}{shared, exclusive})
+	if !bytes.Equal(seq, par) {
+		t.Errorf("concurrent result differs:\n%s\nsequential:\n%s", par, seq)
+	}
+	if len(shared) != 2 || shared["shared_total"].Type != "counter" {
+		t.Errorf("shared = %v", shared)
+	}
+	if len(exclusive["c"]) != 1 || len(exclusive["a"]) != 0 {
+		t.Errorf("exclusive = %v", exclusive)
+	}
+
+	if _, _, err := NewMetricDiscovery(cfg).CompareAll([]string{"a", "missing"}); err == nil {
+		t.Error("expected error for datasource without a URL")
+	}
+}
+
+func TestPrintDiscoveryMulti(t *testing.T) {
+	sets := map[string]string{
+		"a": `["up","shared_total","a_only","ab_only"]`,
+		"b": `["up","shared_total","ab_only"]`,
+		"c": `["up","shared_total","c_one","c_two"]`,
+	}
+	var cfgYAML strings.Builder
+	cfgYAML.WriteString("datasources:\n")
+	for _, name := range sortedKeys(sets) {
+		metrics := sets[name]
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/v1/label/__name__/values":
+				w.Write([]byte(`{"status":"success","data":` + metrics + `}`))
+			case "/api/v1/metadata":
+				w.Write([]byte(`{"status":"success","data":{"shared_total":[{"type":"counter","help":""}]}}`))
+			}
+		}))
+		defer srv.Close()
+		fmt.Fprintf(&cfgYAML, "  %s:\n    type: prometheus\n    uid: %s\n    url: %s\n", name, name, srv.URL)
+	}
+	cfg, err := config.LoadFromBytes([]byte(cfgYAML.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = NewMetricDiscovery(cfg).PrintDiscovery([]string{"a", "b", "c"}, nil, nil)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("PrintDiscovery error: %v", err)
+	}
+
+	for _, want := range []string{
+		"Metric Comparison (3 datasources)",
+		"shared by all: 2",
+		"a only: 1",
+		"b only: 0",
+		"c only: 2",
+		"datasources: [a, b, c]",
+		`- title: "c only"`,
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	// on two of three datasources: neither shared nor exclusive
+	if strings.Contains(string(out), "ab_only") {
+		t.Errorf("partially shared metric should not be suggested:\n%s", out)
+	}
+}
+
+func TestDiscoveryAuth(t *testing.T) {
+	t.Setenv("PROM_TOKEN", "s3cret")
+	t.Setenv("PROM_PASS", "hunter2")
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+		w.Write([]byte(`{"status":"success","data":["up"]}`))
+	}))
+	defer srv.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  token:
+    type: prometheus
+    uid: token
+    url: ` + srv.URL + `
+    bearer_token: ${PROM_TOKEN}
+  basic:
+    type: prometheus
+    uid: basic
+    url: ` + srv.URL + `
+    basic_auth_user: admin
+    basic_auth_pass: ${ENV:PROM_PASS}
+  open:
+    type: prometheus
+    uid: open
+    url: ` + srv.URL + `
+dashboards: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := NewMetricDiscovery(cfg)
+	for _, ds := range []string{"token", "basic", "open"} {
+		if _, err := md.FetchMetrics(ds); err != nil {
+			t.Fatalf("FetchMetrics(%s) error: %v", ds, err)
+		}
+	}
+	want := []string{"Bearer s3cret", "Basic YWRtaW46aHVudGVyMg==", ""}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Authorization headers = %q, want %q", got, want)
+	}
+}
+
+func TestDiscoveryCacheTTL(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Write([]byte(`{"status":"success","data":["up"]}`))
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":["up","new_metric"]}`))
+	}))
+	defer srv.Close()
+
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+    url: ` + srv.URL + `
+discovery:
+  cache_ttl: 1m
+dashboards: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := NewMetricDiscovery(cfg)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	md.cache.now = func() time.Time { return now }
+
+	fetch := func() map[string]bool {
+		t.Helper()
+		m, err := md.FetchMetrics("primary")
+		if err != nil {
+			t.Fatalf("FetchMetrics error: %v", err)
+		}
+		return m
+	}
+
+	fetch()
+	now = now.Add(30 * time.Second)
+	if m := fetch(); len(m) != 1 || calls != 1 {
+		t.Errorf("within TTL: %d metrics after %d calls, want cached 1 after 1", len(m), calls)
+	}
+	now = now.Add(31 * time.Second)
+	if m := fetch(); !m["new_metric"] || calls != 2 {
+		t.Errorf("after TTL: metrics = %v after %d calls, want re-fetch", m, calls)
+	}
+
+	md.FlushCache()
+	fetch()
+	if calls != 3 {
+		t.Errorf("calls after FlushCache = %d, want 3", calls)
+	}
+}
@@ -0,0 +1,55 @@
+package generator
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultCacheTTL is how long discovery responses are reused when
+// discovery.cache_ttl is unset.
+const defaultCacheTTL = 5 * time.Minute
+
+// discoveryCache holds API responses for a limited time. A zero ttl keeps
+// entries until Flush.
+type discoveryCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value  interface{}
+	stored time.Time
+}
+
+func newDiscoveryCache(ttl time.Duration) *discoveryCache {
+	return &discoveryCache{ttl: ttl, now: time.Now, entries: make(map[string]cacheEntry)}
+}
+
+// get returns a cached value; entries older than the TTL are misses.
+func (c *discoveryCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if c.ttl > 0 && c.now().Sub(e.stored) >= c.ttl {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *discoveryCache) put(key string, v interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cacheEntry{value: v, stored: c.now()}
+}
+
+func (c *discoveryCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+}
@@ -0,0 +1,109 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// FolderResolver maps Grafana folder titles to uids, creating folders that
+// do not exist yet. Lookups are cached for the resolver's lifetime, so one
+// resolver per push run lists folders at most once.
+type FolderResolver struct {
+	Grafana GrafanaOptions
+
+	cache map[string]string
+}
+
+// NewFolderResolver creates a resolver for one Grafana instance.
+func NewFolderResolver(g GrafanaOptions) *FolderResolver {
+	return &FolderResolver{Grafana: g}
+}
+
+// Resolve returns the uid of the folder titled title, creating it when
+// missing. An empty title is the General folder and resolves to "".
+func (fr *FolderResolver) Resolve(title string) (string, error) {
+	if title == "" {
+		return "", nil
+	}
+	if fr.cache == nil {
+		folders, err := fr.list()
+		if err != nil {
+			return "", err
+		}
+		fr.cache = folders
+	}
+	if uid, ok := fr.cache[title]; ok {
+		return uid, nil
+	}
+	uid, err := fr.create(title)
+	if err != nil {
+		return "", err
+	}
+	fr.cache[title] = uid
+	return uid, nil
+}
+
+func (fr *FolderResolver) list() (map[string]string, error) {
+	status, body, err := fr.do("GET", "/api/folders?limit=1000", nil)
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status >= 300 {
+		return nil, grafanaError(status, body, fr.Grafana.URL)
+	}
+	var list []struct {
+		UID   string `json:"uid"`
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("parsing folders: %w", err)
+	}
+	folders := make(map[string]string, len(list))
+	for _, f := range list {
+		folders[f.Title] = f.UID
+	}
+	return folders, nil
+}
+
+func (fr *FolderResolver) create(title string) (string, error) {
+	status, body, err := fr.do("POST", "/api/folders", map[string]interface{}{"title": title})
+	if err != nil {
+		return "", err
+	}
+	if status < 200 || status >= 300 {
+		return "", grafanaError(status, body, fr.Grafana.URL)
+	}
+	var created struct {
+		UID string `json:"uid"`
+	}
+	if err := json.Unmarshal(body, &created); err != nil || created.UID == "" {
+		return "", fmt.Errorf("creating folder '%s': unexpected response %s", title, bytes.TrimSpace(body))
+	}
+	return created.UID, nil
+}
+
+func (fr *FolderResolver) do(method, path string, payload interface{}) (int, []byte, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return 0, nil, fmt.Errorf("marshaling folder: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, trimSlash(fr.Grafana.URL)+path, body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := fr.Grafana.do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("folder request: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, data, nil
+}
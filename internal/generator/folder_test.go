@@ -0,0 +1,64 @@
+package generator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPushToFolder(t *testing.T) {
+	lists, creates := 0, 0
+	var pushed []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/folders":
+			lists++
+			w.Write([]byte(`[{"id":3,"uid":"fld-infra","title":"Infrastructure"}]`))
+		case r.Method == "POST" && r.URL.Path == "/api/folders":
+			creates++
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["title"] != "Cardano" {
+				t.Errorf("created folder title = %v, want Cardano", body["title"])
+			}
+			w.Write([]byte(`{"id":4,"uid":"fld-cardano","title":"Cardano"}`))
+		case r.Method == "POST" && r.URL.Path == "/api/dashboards/db":
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			pushed = append(pushed, payload)
+			w.Write([]byte(`{"status":"success"}`))
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	g := GrafanaOptions{URL: srv.URL, Token: "token"}
+	folders := NewFolderResolver(g)
+	for _, title := range []string{"Infrastructure", "Cardano", "Cardano", ""} {
+		uid, err := folders.Resolve(title)
+		if err != nil {
+			t.Fatalf("Resolve(%q) error: %v", title, err)
+		}
+		if err := PushToGrafana(map[string]interface{}{"uid": "gen-" + title}, uid, g); err != nil {
+			t.Fatalf("PushToGrafana error: %v", err)
+		}
+	}
+
+	if lists != 1 {
+		t.Errorf("folder list requests = %d, want 1 (cached)", lists)
+	}
+	if creates != 1 {
+		t.Errorf("folder create requests = %d, want 1", creates)
+	}
+	want := []interface{}{"fld-infra", "fld-cardano", "fld-cardano", nil}
+	if len(pushed) != len(want) {
+		t.Fatalf("pushed %d dashboards, want %d", len(pushed), len(want))
+	}
+	for i, w := range want {
+		if got := pushed[i]["folderUid"]; got != w {
+			t.Errorf("push %d folderUid = %v, want %v", i, got, w)
+		}
+	}
+}
@@ -110,6 +110,16 @@ func getStringSliceAsStrings(m map[string]interface{}, key string) []string {
 	return nil
 }
 
+// refID returns the query refId for the i-th target (0-based), spreadsheet
+// style: A..Z, then AA, AB, ... AZ, BA and so on.
+func refID(i int) string {
+	id := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		id = string(rune('A'+(i-1)%26)) + id
+	}
+	return id
+}
+
 func contains(s, substr string) bool {
 	return strings.Contains(s, substr)
 }
@@ -0,0 +1,81 @@
+package generator
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// TLSOptions relaxes or extends certificate checks for outgoing requests,
+// e.g. against test environments with self-signed certificates.
+type TLSOptions struct {
+	// Insecure skips certificate verification entirely.
+	Insecure bool
+	// CAFile is a PEM bundle trusted in addition to the system roots.
+	CAFile string
+}
+
+// NewHTTPClient returns a client with the given timeout and TLS options.
+// The zero TLSOptions yields a plain client using the default transport.
+func NewHTTPClient(timeout time.Duration, opts TLSOptions) (*http.Client, error) {
+	client := &http.Client{Timeout: timeout}
+	if !opts.Insecure && opts.CAFile == "" {
+		return client, nil
+	}
+	tlsCfg := &tls.Config{InsecureSkipVerify: opts.Insecure}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %s contains no PEM certificates", opts.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	client.Transport = transport
+	return client, nil
+}
+
+// GrafanaOptions addresses one Grafana instance for the push, fetch, backup,
+// render, folder and library panel helpers: its URL, credentials (a token
+// wins over basic auth) and the client every request goes through. Build it
+// once per run with NewGrafanaOptions so requests share connections.
+type GrafanaOptions struct {
+	URL   string
+	User  string
+	Pass  string
+	Token string
+	// Client sends every request; nil uses a plain client with a 30s
+	// timeout.
+	Client *http.Client
+}
+
+// NewGrafanaOptions returns GrafanaOptions with a client honoring tlsOpts.
+func NewGrafanaOptions(grafanaURL, user, pass, token string, tlsOpts TLSOptions) (GrafanaOptions, error) {
+	client, err := NewHTTPClient(30*time.Second, tlsOpts)
+	if err != nil {
+		return GrafanaOptions{}, err
+	}
+	return GrafanaOptions{URL: grafanaURL, User: user, Pass: pass, Token: token, Client: client}, nil
+}
+
+var defaultGrafanaClient = &http.Client{Timeout: 30 * time.Second}
+
+// do sends req with g's credentials through g's client.
+func (g GrafanaOptions) do(req *http.Request) (*http.Response, error) {
+	setGrafanaAuth(req, g.User, g.Pass, g.Token)
+	if g.Client == nil {
+		return defaultGrafanaClient.Do(req)
+	}
+	return g.Client.Do(req)
+}
@@ -0,0 +1,74 @@
+package generator
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClientTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	get := func(opts TLSOptions) error {
+		client, err := NewHTTPClient(5*time.Second, opts)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if err := get(TLSOptions{}); err == nil {
+		t.Error("expected certificate error against a self-signed server")
+	}
+	if err := get(TLSOptions{Insecure: true}); err != nil {
+		t.Errorf("insecure request failed: %v", err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := get(TLSOptions{CAFile: caFile}); err != nil {
+		t.Errorf("request trusting the CA file failed: %v", err)
+	}
+
+	if _, err := NewHTTPClient(time.Second, TLSOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("expected error for a missing CA file")
+	}
+}
+
+func TestPushToGrafanaInsecure(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","uid":"d"}`))
+	}))
+	defer srv.Close()
+
+	dashboard := map[string]interface{}{"uid": "d", "panels": []interface{}{}}
+
+	strict, err := NewGrafanaOptions(srv.URL, "", "", "", TLSOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PushToGrafana(dashboard, "", strict); err == nil {
+		t.Error("expected certificate error without --grafana-insecure")
+	}
+	insecure, err := NewGrafanaOptions(srv.URL, "", "", "", TLSOptions{Insecure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PushToGrafana(dashboard, "", insecure); err != nil {
+		t.Errorf("push with --grafana-insecure failed: %v", err)
+	}
+}
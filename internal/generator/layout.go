@@ -1,6 +1,12 @@
 package generator
 
-// LayoutEngine implements the 24-unit grid auto-layout algorithm.
+import (
+	"fmt"
+	"sort"
+)
+
+// LayoutEngine implements the grid auto-layout algorithm, on Grafana's
+// 24-unit grid unless generator.grid_width says otherwise.
 type LayoutEngine struct {
 	GridWidth int
 	cursorX   int
@@ -10,7 +16,42 @@ type LayoutEngine struct {
 
 // NewLayoutEngine creates a new layout engine with the standard 24-unit grid.
 func NewLayoutEngine() *LayoutEngine {
-	return &LayoutEngine{GridWidth: 24}
+	return NewLayoutEngineWidth(24)
+}
+
+// NewLayoutEngineWidth creates a layout engine filling width columns, e.g.
+// from cfg.GetGenerator().GridColumns().
+func NewLayoutEngineWidth(width int) *LayoutEngine {
+	return &LayoutEngine{GridWidth: width}
+}
+
+// panelSize returns a panel config's width and height: its own, else its
+// type's default (6x4 for unknown types). A default width wider than the
+// grid is clamped to it and written to a copy of cfg, so the factory emits
+// the size the layout used; an explicit width wider than the grid is an
+// error.
+func (le *LayoutEngine) panelSize(cfg map[string]interface{}) (map[string]interface{}, int, int, error) {
+	ds := DefaultSizes[getString(cfg, "type", "")]
+	if ds == [2]int{} {
+		ds = [2]int{6, 4}
+	}
+	h := getInt(cfg, "height", ds[1])
+	if hasKey(cfg, "width") {
+		w := getInt(cfg, "width", ds[0])
+		if w > le.GridWidth {
+			return nil, 0, 0, fmt.Errorf("width %d exceeds the %d-column grid", w, le.GridWidth)
+		}
+		return cfg, w, h, nil
+	}
+	if ds[0] <= le.GridWidth {
+		return cfg, ds[0], h, nil
+	}
+	clamped := make(map[string]interface{}, len(cfg)+1)
+	for k, v := range cfg {
+		clamped[k] = v
+	}
+	clamped["width"] = le.GridWidth
+	return clamped, le.GridWidth, h, nil
 }
 
 // Reset resets the layout state for a new dashboard.
@@ -50,6 +91,16 @@ func (le *LayoutEngine) Place(width, height int) (int, int) {
 	return x, y
 }
 
+// PlaceAlone positions a panel on a line of its own, for repeated panels:
+// Grafana fills the line with their copies at runtime, so the next panel
+// must start below rather than collide with them.
+func (le *LayoutEngine) PlaceAlone(width, height int) (int, int) {
+	le.FinishSection()
+	x, y := le.Place(width, height)
+	le.FinishSection()
+	return x, y
+}
+
 // FinishSection advances past the tallest panel in the current row.
 func (le *LayoutEngine) FinishSection() {
 	if le.cursorX > 0 {
@@ -58,3 +109,110 @@ func (le *LayoutEngine) FinishSection() {
 		le.rowHeight = 0
 	}
 }
+
+// Overlap is a pair of panels whose grid rectangles intersect.
+type Overlap struct {
+	A, B string
+}
+
+func (o Overlap) String() string {
+	return fmt.Sprintf("'%s' overlaps '%s'", o.A, o.B)
+}
+
+// FindOverlaps reports intersecting gridPos rectangles among a dashboard's
+// top-level panels, and separately among the panels nested in each
+// collapsed row, which Grafana lays out on their own when expanded.
+func FindOverlaps(panels []interface{}) []Overlap {
+	overlaps := overlapsIn(panels)
+	for _, p := range panels {
+		if m, ok := p.(map[string]interface{}); ok {
+			if inner, ok := m["panels"].([]interface{}); ok {
+				overlaps = append(overlaps, overlapsIn(inner)...)
+			}
+		}
+	}
+	return overlaps
+}
+
+type gridRect struct {
+	name       string
+	x, y, w, h int
+}
+
+func (r gridRect) intersects(o gridRect) bool {
+	return r.x < o.x+o.w && o.x < r.x+r.w && r.y < o.y+o.h && o.y < r.y+r.h
+}
+
+// validatePlaced checks the panels of one section that were given explicit
+// x/y: each must lie within the grid width and none may overlap another.
+// Auto-placed panels never collide with each other and are not passed in.
+func (le *LayoutEngine) validatePlaced(rects []gridRect) error {
+	for _, r := range rects {
+		if r.x < 0 || r.x+r.w > le.GridWidth {
+			return fmt.Errorf("panel '%s' at x=%d with width %d does not fit the %d-column grid", r.name, r.x, r.w, le.GridWidth)
+		}
+	}
+	for i := range rects {
+		for j := i + 1; j < len(rects); j++ {
+			if rects[i].intersects(rects[j]) {
+				return fmt.Errorf("overlapping panels: %s", Overlap{A: rects[i].name, B: rects[j].name})
+			}
+		}
+	}
+	return nil
+}
+
+func overlapsIn(panels []interface{}) []Overlap {
+	var rects []gridRect
+	for _, p := range panels {
+		m, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		gp, ok := m["gridPos"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name := getString(m, "title", "")
+		if name == "" {
+			name = fmt.Sprintf("panel %d", getInt(m, "id", 0))
+		}
+		rects = append(rects, gridRect{name, getInt(gp, "x", 0), getInt(gp, "y", 0), getInt(gp, "w", 0), getInt(gp, "h", 0)})
+	}
+	var overlaps []Overlap
+	for i := range rects {
+		for j := i + 1; j < len(rects); j++ {
+			a, b := rects[i], rects[j]
+			if a.intersects(b) {
+				overlaps = append(overlaps, Overlap{A: a.name, B: b.name})
+			}
+		}
+	}
+	return overlaps
+}
+
+// sortByGridPos orders panels top-to-bottom, left-to-right, the order Grafana
+// itself uses, recursing into collapsed rows. Panels at the same position
+// (a row and a panel placed at its y) keep their build order.
+func sortByGridPos(panels []interface{}) {
+	pos := func(p interface{}) (int, int) {
+		m, _ := p.(map[string]interface{})
+		gp, _ := m["gridPos"].(map[string]interface{})
+		return getInt(gp, "y", 0), getInt(gp, "x", 0)
+	}
+	sort.SliceStable(panels, func(i, j int) bool {
+		yi, xi := pos(panels[i])
+		yj, xj := pos(panels[j])
+		if yi != yj {
+			return yi < yj
+		}
+		return xi < xj
+	})
+	for _, p := range panels {
+		if m, ok := p.(map[string]interface{}); ok {
+			if inner, ok := m["panels"].([]interface{}); ok {
+				sortByGridPos(inner)
+			}
+		}
+	}
+}
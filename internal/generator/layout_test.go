@@ -48,6 +48,34 @@ func TestLayoutWrap(t *testing.T) {
 	}
 }
 
+func TestLayoutCustomGridWidth(t *testing.T) {
+	le := NewLayoutEngineWidth(12)
+
+	// two 6-wide panels fill a 12-column line
+	le.Place(6, 4)
+	x, y := le.Place(6, 4)
+	if x != 6 || y != 0 {
+		t.Errorf("Place(6,4) = (%d,%d), want (6,0)", x, y)
+	}
+	// a third wraps, where a 24-column grid would not
+	x, y = le.Place(6, 4)
+	if x != 0 || y != 4 {
+		t.Errorf("Place(6,4) = (%d,%d), want (0,4) after wrapping at 12", x, y)
+	}
+
+	// default widths are clamped to the grid, explicit ones must fit
+	cfg, w, _, err := le.panelSize(map[string]interface{}{"type": "table"})
+	if err != nil || w != 12 || cfg["width"] != 12 {
+		t.Errorf("table size = %d (cfg width %v, err %v), want clamped to 12", w, cfg["width"], err)
+	}
+	if _, w, _, _ = le.panelSize(map[string]interface{}{"type": "stat"}); w != DefaultSizes["stat"][0] {
+		t.Errorf("stat width = %d, want its default %d", w, DefaultSizes["stat"][0])
+	}
+	if _, _, _, err := le.panelSize(map[string]interface{}{"type": "stat", "width": 16}); err == nil {
+		t.Error("expected error for width 16 on a 12-column grid")
+	}
+}
+
 func TestLayoutAddRow(t *testing.T) {
 	le := NewLayoutEngine()
 
@@ -114,3 +142,52 @@ func TestLayoutFullWidthPanel(t *testing.T) {
 		t.Errorf("Place(6,4) after full = (%d,%d), want (0,8)", x, y)
 	}
 }
+
+func TestFindOverlaps(t *testing.T) {
+	panel := func(title string, x, y, w, h int) interface{} {
+		return map[string]interface{}{
+			"title":   title,
+			"gridPos": map[string]interface{}{"x": x, "y": y, "w": w, "h": h},
+		}
+	}
+	panels := []interface{}{
+		panel("cpu", 0, 0, 12, 8),
+		panel("memory", 6, 4, 12, 8), // overlaps cpu
+		panel("disk", 12, 0, 12, 6),  // touches cpu's edge, overlaps memory
+		panel("net", 0, 8, 6, 4),     // directly below cpu
+	}
+	overlaps := FindOverlaps(panels)
+	if len(overlaps) != 2 {
+		t.Fatalf("overlaps = %v, want 2", overlaps)
+	}
+	if overlaps[0] != (Overlap{"cpu", "memory"}) || overlaps[1] != (Overlap{"memory", "disk"}) {
+		t.Errorf("overlaps = %v, want cpu/memory and memory/disk", overlaps)
+	}
+
+	// nested panels of a collapsed row are checked among themselves only
+	row := map[string]interface{}{
+		"title":   "row",
+		"gridPos": map[string]interface{}{"x": 0, "y": 20, "w": 24, "h": 1},
+		"panels":  []interface{}{panel("a", 0, 0, 12, 4), panel("b", 0, 2, 12, 4)},
+	}
+	overlaps = FindOverlaps([]interface{}{panel("top", 0, 0, 24, 4), row})
+	if len(overlaps) != 1 || overlaps[0] != (Overlap{"a", "b"}) {
+		t.Errorf("nested overlaps = %v, want a/b", overlaps)
+	}
+}
+
+func TestLayoutPlaceAlone(t *testing.T) {
+	le := NewLayoutEngine()
+	le.Place(6, 4)
+
+	// a repeated panel starts on a fresh line...
+	x, y := le.PlaceAlone(8, 5)
+	if x != 0 || y != 4 {
+		t.Errorf("PlaceAlone(8,5) = (%d,%d), want (0,4)", x, y)
+	}
+	// ...and the next static panel starts below it, not beside it
+	x, y = le.Place(6, 4)
+	if x != 0 || y != 9 {
+		t.Errorf("Place after PlaceAlone = (%d,%d), want (0,9)", x, y)
+	}
+}
@@ -0,0 +1,230 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+)
+
+// applyLibrary marks a panel with library: true as a Grafana library panel.
+// The full model stays inline so written files remain self-contained; push
+// splits it out with ExtractLibraryPanels. The uid is library_uid; without
+// one it is left empty for assignLibraryUIDs to derive once the dashboard
+// and section are known.
+func applyLibrary(panel, cfg map[string]interface{}) {
+	if !getBool(cfg, "library", false) || getString(cfg, "type", "") == "library" {
+		return
+	}
+	name, _ := panel["title"].(string)
+	uid := getString(cfg, "library_uid", "")
+	panel["libraryPanel"] = map[string]interface{}{"uid": uid, "name": name}
+}
+
+// assignLibraryUIDs fills in the uid of library panels built without
+// library_uid, including those inside collapsed rows. The uid is derived from
+// the dashboard uid, section title and panel title, so panels sharing a title
+// on different dashboards or sections stay separate library elements.
+func assignLibraryUIDs(panels []interface{}, dashboardUID, section string) {
+	for _, p := range panels {
+		panel, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if children, ok := panel["panels"].([]interface{}); ok {
+			assignLibraryUIDs(children, dashboardUID, section)
+		}
+		lib, ok := panel["libraryPanel"].(map[string]interface{})
+		if !ok || lib["uid"] != "" {
+			continue
+		}
+		name, _ := lib["name"].(string)
+		lib["uid"] = config.GeneratedUID(dashboardUID + "/" + section + "/" + name)
+	}
+}
+
+// LibraryRef creates a reference to an existing library panel maintained
+// outside this config: only id, gridPos and the libraryPanel block, so
+// Grafana loads the model from the library. It has no type, which is how
+// ExtractLibraryPanels tells it apart from panels it should push.
+func (pf *PanelFactory) LibraryRef(cfg map[string]interface{}, x, y int) (map[string]interface{}, error) {
+	uid := getString(cfg, "library_uid", "")
+	if uid == "" {
+		return nil, fmt.Errorf("library panel requires library_uid")
+	}
+	dw, dh := DefaultSizes["library"][0], DefaultSizes["library"][1]
+	w := getInt(cfg, "width", dw)
+	h := getInt(cfg, "height", dh)
+	name := pf.Config.ResolveRef(getString(cfg, "library_name", getString(cfg, "title", uid)))
+	return map[string]interface{}{
+		"gridPos":      map[string]interface{}{"h": h, "w": w, "x": x, "y": y},
+		"id":           pf.IDGen.Next(),
+		"libraryPanel": map[string]interface{}{"uid": uid, "name": name},
+	}, nil
+}
+
+// LibraryElement is a library panel payload for /api/library-elements.
+type LibraryElement struct {
+	UID   string                 `json:"uid"`
+	Name  string                 `json:"name"`
+	Kind  int                    `json:"kind"` // 1 = panel
+	Model map[string]interface{} `json:"model"`
+}
+
+// ExtractLibraryPanels returns the library elements of a dashboard (including
+// panels inside collapsed rows) and a copy of the dashboard in which each
+// library panel is reduced to its id, gridPos and libraryPanel reference.
+func ExtractLibraryPanels(dashboard map[string]interface{}) ([]LibraryElement, map[string]interface{}) {
+	var elements []LibraryElement
+	var reduce func(panels []interface{}) []interface{}
+	reduce = func(panels []interface{}) []interface{} {
+		out := make([]interface{}, len(panels))
+		for i, p := range panels {
+			panel, ok := p.(map[string]interface{})
+			if !ok {
+				out[i] = p
+				continue
+			}
+			if inner, ok := panel["panels"].([]interface{}); ok && len(inner) > 0 {
+				row := make(map[string]interface{}, len(panel))
+				for k, v := range panel {
+					row[k] = v
+				}
+				row["panels"] = reduce(inner)
+				out[i] = row
+				continue
+			}
+			ref, ok := panel["libraryPanel"].(map[string]interface{})
+			if _, typed := panel["type"]; !ok || !typed {
+				// plain panel, or a LibraryRef to an element managed elsewhere
+				out[i] = panel
+				continue
+			}
+			model := make(map[string]interface{}, len(panel))
+			for k, v := range panel {
+				if k != "libraryPanel" && k != "id" && k != "gridPos" {
+					model[k] = v
+				}
+			}
+			uid, _ := ref["uid"].(string)
+			name, _ := ref["name"].(string)
+			elements = append(elements, LibraryElement{UID: uid, Name: name, Kind: 1, Model: model})
+			out[i] = map[string]interface{}{
+				"id":           panel["id"],
+				"gridPos":      panel["gridPos"],
+				"libraryPanel": ref,
+			}
+		}
+		return out
+	}
+
+	result := make(map[string]interface{}, len(dashboard))
+	for k, v := range dashboard {
+		result[k] = v
+	}
+	if panels, ok := dashboard["panels"].([]interface{}); ok {
+		result["panels"] = reduce(panels)
+	}
+	return elements, result
+}
+
+// PushLibraryElement creates the library element, or updates it in place
+// when one with the same uid already exists and its name or model differs.
+func PushLibraryElement(elem LibraryElement, g GrafanaOptions) error {
+	base := trimSlash(g.URL) + "/api/library-elements"
+	do := func(method, url string, payload interface{}) (int, []byte, error) {
+		var body io.Reader
+		if payload != nil {
+			data, err := json.Marshal(payload)
+			if err != nil {
+				return 0, nil, fmt.Errorf("marshaling library element: %w", err)
+			}
+			body = bytes.NewReader(data)
+		}
+		req, err := http.NewRequest(method, url, body)
+		if err != nil {
+			return 0, nil, fmt.Errorf("creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := g.do(req)
+		if err != nil {
+			return 0, nil, fmt.Errorf("pushing library element '%s': %w", elem.UID, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, data, nil
+	}
+
+	status, body, err := do("GET", base+"/"+elem.UID, nil)
+	if err != nil {
+		return err
+	}
+	switch {
+	case status == http.StatusNotFound:
+		status, body, err = do("POST", base, elem)
+	case status >= 200 && status < 300:
+		var existing struct {
+			Result struct {
+				Name    string                 `json:"name"`
+				Model   map[string]interface{} `json:"model"`
+				Version int                    `json:"version"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(body, &existing); err != nil {
+			return fmt.Errorf("parsing library element '%s': %w", elem.UID, err)
+		}
+		same, err := sameLibraryModel(elem.Model, existing.Result.Model)
+		if err != nil {
+			return err
+		}
+		if same && existing.Result.Name == elem.Name {
+			// a PATCH would bump the element's version for nothing
+			return nil
+		}
+		status, body, err = do("PATCH", base+"/"+elem.UID, map[string]interface{}{
+			"uid":     elem.UID,
+			"name":    elem.Name,
+			"kind":    elem.Kind,
+			"model":   elem.Model,
+			"version": existing.Result.Version,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if status < 200 || status >= 300 {
+		return grafanaError(status, body, g.URL)
+	}
+	return nil
+}
+
+// sameLibraryModel reports whether a generated library panel model matches
+// the live one, ignoring the placement keys Grafana may keep on it.
+func sameLibraryModel(generated, live map[string]interface{}) (bool, error) {
+	gen, err := normalizeJSON(generated)
+	if err != nil {
+		return false, err
+	}
+	cur := make(map[string]interface{}, len(live))
+	for k, v := range live {
+		if k != "libraryPanel" && k != "id" && k != "gridPos" {
+			cur[k] = v
+		}
+	}
+	return len(diffValues("", cur, gen, nil)) == 0, nil
+}
+
+// PushWithLibraryPanels pushes a dashboard's library panels, then the
+// dashboard with those panels reduced to references into folderUID.
+func PushWithLibraryPanels(dashboard map[string]interface{}, folderUID string, g GrafanaOptions) error {
+	elements, reduced := ExtractLibraryPanels(dashboard)
+	for _, elem := range elements {
+		if err := PushLibraryElement(elem, g); err != nil {
+			return err
+		}
+	}
+	return PushToGrafana(reduced, folderUID, g)
+}
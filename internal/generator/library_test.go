@@ -0,0 +1,165 @@
+package generator
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+)
+
+func TestPushLibraryPanels(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+	lib, err := pf.FromConfig(map[string]interface{}{
+		"type": "stat", "title": "CPU Busy %", "query": "up", "library": true, "library_uid": "lib-cpu-busy",
+	}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := pf.FromConfig(map[string]interface{}{"type": "stat", "title": "up", "query": "up"}, 6, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := lib["libraryPanel"].(map[string]interface{})
+	if ref["uid"] != "lib-cpu-busy" || ref["name"] != "CPU Busy %" {
+		t.Errorf("libraryPanel = %v, want lib-cpu-busy / CPU Busy %%", ref)
+	}
+	row := pf.Row("row", 0, true, []interface{}{lib}, "")
+	dashboard := map[string]interface{}{"uid": "d", "panels": []interface{}{plain, row}}
+
+	var created map[string]interface{}
+	var pushed map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/library-elements/lib-cpu-busy":
+			http.NotFound(w, r)
+		case r.Method == "POST" && r.URL.Path == "/api/library-elements":
+			json.Unmarshal(body, &created)
+			w.Write([]byte(`{"result":{}}`))
+		case r.Method == "POST" && r.URL.Path == "/api/dashboards/db":
+			json.Unmarshal(body, &pushed)
+			w.Write([]byte(`{"status":"success","uid":"d"}`))
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	if err := PushWithLibraryPanels(dashboard, "", GrafanaOptions{URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if created["uid"] != "lib-cpu-busy" || created["kind"] != float64(1) {
+		t.Errorf("library element = %v", created)
+	}
+	model := created["model"].(map[string]interface{})
+	if model["type"] != "stat" || model["gridPos"] != nil || model["libraryPanel"] != nil {
+		t.Errorf("model = %v, want stat model without gridPos/libraryPanel", model)
+	}
+
+	panels := pushed["dashboard"].(map[string]interface{})["panels"].([]interface{})
+	if p := panels[0].(map[string]interface{}); p["type"] != "stat" {
+		t.Errorf("plain panel should stay inline, got %v", p)
+	}
+	inner := panels[1].(map[string]interface{})["panels"].([]interface{})[0].(map[string]interface{})
+	if len(inner) != 3 || inner["libraryPanel"] == nil || inner["gridPos"] == nil {
+		t.Errorf("library panel in dashboard = %v, want id/gridPos/libraryPanel reference", inner)
+	}
+	// the built dashboard keeps its inline model
+	if _, ok := lib["type"]; !ok {
+		t.Error("ExtractLibraryPanels modified the original panel")
+	}
+}
+
+func TestBuildLibraryUIDs(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  prom: { type: prometheus, uid: prom, is_default: true }
+dashboards:
+  nodes:
+    uid: nodes
+    title: Nodes
+    sections:
+      - title: Host
+        panels:
+          - { type: stat, title: CPU, query: up, library: true }
+          - { type: stat, title: Load, query: up, library: true, library_uid: org-load }
+  pods:
+    uid: pods
+    title: Pods
+    sections:
+      - title: Host
+        collapsed: true
+        panels:
+          - { type: stat, title: CPU, query: up, library: true }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+	uids := make(map[string]string)
+	for _, name := range []string{"nodes", "pods"} {
+		dashboard, err := builder.Build(cfg.Dashboards[name], nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		elements, _ := ExtractLibraryPanels(dashboard)
+		for _, elem := range elements {
+			uids[name+"/"+elem.Name] = elem.UID
+		}
+	}
+	if uids["nodes/CPU"] != config.GeneratedUID("nodes/Host/CPU") {
+		t.Errorf("nodes CPU uid = %q, want %q", uids["nodes/CPU"], config.GeneratedUID("nodes/Host/CPU"))
+	}
+	if uids["pods/CPU"] == "" || uids["pods/CPU"] == uids["nodes/CPU"] {
+		t.Errorf("same-titled library panels on different dashboards share uid %q", uids["pods/CPU"])
+	}
+	if uids["nodes/Load"] != "org-load" {
+		t.Errorf("explicit library_uid = %q, want org-load", uids["nodes/Load"])
+	}
+}
+
+func TestLibraryRefPanel(t *testing.T) {
+	cfg := loadTestConfig(t)
+	builder := NewDashboardBuilder(cfg, NewPanelFactory(cfg, NewIDGenerator()), NewLayoutEngine())
+
+	panels, err := builder.BuildSection(config.SectionConfig{
+		Title: "shared",
+		Panels: []map[string]interface{}{
+			{"type": "stat", "title": "up", "query": "up"},
+			{"type": "library", "library_uid": "org-cpu-busy", "title": "cpu busy"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BuildSection error: %v", err)
+	}
+	ref := panels[2].(map[string]interface{})
+	lp, ok := ref["libraryPanel"].(map[string]interface{})
+	if !ok || lp["uid"] != "org-cpu-busy" || lp["name"] != "cpu busy" {
+		t.Errorf("libraryPanel = %v, want org-cpu-busy / cpu busy", ref["libraryPanel"])
+	}
+	if _, ok := ref["id"]; !ok {
+		t.Error("library reference should have an id")
+	}
+	gp := ref["gridPos"].(map[string]interface{})
+	want := map[string]interface{}{"x": DefaultSizes["stat"][0], "y": 1, "w": 12, "h": 8}
+	for k, v := range want {
+		if gp[k] != v {
+			t.Errorf("gridPos %s = %v, want %v", k, gp[k], v)
+		}
+	}
+
+	// references are not pushed as library elements
+	elements, _ := ExtractLibraryPanels(map[string]interface{}{"panels": panels})
+	if len(elements) != 0 {
+		t.Errorf("extracted %d elements from a reference, want 0", len(elements))
+	}
+
+	if _, err := NewPanelFactory(cfg, NewIDGenerator()).FromConfig(map[string]interface{}{"type": "library"}, 0, 0); err == nil {
+		t.Error("expected error without library_uid")
+	}
+}
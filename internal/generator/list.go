@@ -0,0 +1,95 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+)
+
+// ListKinds are the config sections the list command can enumerate.
+var ListKinds = []string{"dashboards", "profiles", "datasources"}
+
+// ListEntry is one line of list output. UID is set for dashboards and
+// datasources; Members holds a profile's resolved dashboards.
+type ListEntry struct {
+	Name    string   `json:"name"`
+	UID     string   `json:"uid,omitempty"`
+	Type    string   `json:"type,omitempty"`
+	Members []string `json:"dashboards,omitempty"`
+}
+
+// ListConfig enumerates dashboards (in config or profile order), profiles or
+// datasources (sorted by name).
+func ListConfig(cfg *config.Config, kind, profile string) ([]ListEntry, error) {
+	var entries []ListEntry
+	switch kind {
+	case "dashboards":
+		dashboards, err := cfg.GetDashboards(profile)
+		if err != nil {
+			return nil, err
+		}
+		order, err := cfg.GetDashboardOrder(profile)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range order {
+			if db, ok := dashboards[name]; ok {
+				entries = append(entries, ListEntry{Name: name, UID: db.UID})
+			}
+		}
+	case "profiles":
+		names := make([]string, 0, len(cfg.Profiles))
+		for name := range cfg.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			members, err := cfg.GetDashboardOrder(name)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, ListEntry{Name: name, Members: members})
+		}
+	case "datasources":
+		for _, name := range sortedKeys(cfg.Datasources) {
+			ds := cfg.Datasources[name]
+			entries = append(entries, ListEntry{Name: name, UID: ds.UID, Type: ds.Type})
+		}
+	default:
+		return nil, fmt.Errorf("unknown list kind '%s' (want %s)", kind, strings.Join(ListKinds, ", "))
+	}
+	return entries, nil
+}
+
+// WriteList prints entries one per line (tab-separated extras) or as a JSON
+// array.
+func WriteList(w io.Writer, entries []ListEntry, asJSON bool) error {
+	if asJSON {
+		if entries == nil {
+			entries = []ListEntry{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	for _, e := range entries {
+		fields := []string{e.Name}
+		if e.Type != "" {
+			fields = append(fields, e.Type)
+		}
+		if e.UID != "" {
+			fields = append(fields, e.UID)
+		}
+		if len(e.Members) > 0 {
+			fields = append(fields, strings.Join(e.Members, ","))
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(fields, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+)
+
+func TestListDashboards(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary: { type: prometheus, uid: prom }
+profiles:
+  infra:
+    dashboards: [compute, overview]
+dashboards:
+  overview: { uid: gen-overview, title: overview }
+  compute: { uid: gen-compute, title: compute }
+  memory: { uid: gen-memory, title: memory }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ListConfig(cfg, "dashboards", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteList(&buf, entries, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "overview\tgen-overview\ncompute\tgen-compute\nmemory\tgen-memory\n"
+	if buf.String() != want {
+		t.Errorf("list dashboards =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	// profile order, as JSON
+	entries, err = ListConfig(cfg, "dashboards", "infra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := WriteList(&buf, entries, true); err != nil {
+		t.Fatal(err)
+	}
+	var got []ListEntry
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(got) != 2 || got[0].Name != "compute" || got[1].UID != "gen-overview" {
+		t.Errorf("profile list = %+v, want compute, overview", got)
+	}
+
+	if _, err := ListConfig(cfg, "panels", ""); err == nil {
+		t.Error("expected error for unknown list kind")
+	}
+}
@@ -2,6 +2,11 @@ package generator
 
 import (
 	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/wcatz/dashboard-generator/internal/config"
 )
@@ -22,6 +27,13 @@ var DefaultSizes = map[string][2]int{
 	"logs":           {24, 8},
 	"row":            {24, 1},
 	"comparison":     {12, 8},
+	"geomap":         {12, 9},
+	"candlestick":    {12, 8},
+	"xychart":        {12, 8},
+	"alertlist":      {8, 8},
+	"dashlist":       {6, 8},
+	"library":        {12, 8},
+	"trend":          {12, 7},
 }
 
 // PanelFactory creates Grafana panel JSON dicts.
@@ -37,6 +49,67 @@ func NewPanelFactory(cfg *config.Config, idGen *IDGenerator) *PanelFactory {
 
 // FromConfig creates a panel from a config dict.
 func (pf *PanelFactory) FromConfig(cfg map[string]interface{}, x, y int) (map[string]interface{}, error) {
+	cfg = pf.WithDefaults(cfg)
+	panel, err := pf.fromConfig(cfg, x, y)
+	if err != nil {
+		return nil, err
+	}
+	applyNoValue(panel, cfg)
+	applyFormatting(panel, cfg)
+	if title, ok := panel["title"].(string); ok {
+		panel["title"] = pf.Config.ResolveRef(title)
+	}
+	applyLibrary(panel, cfg)
+	if err := applyRepeat(panel, cfg); err != nil {
+		return nil, err
+	}
+	if n := maxDataPoints(cfg, 0); n > 0 {
+		if _, ok := panel["maxDataPoints"]; !ok {
+			panel["maxDataPoints"] = n
+		}
+	}
+	if interval := getString(cfg, "interval", ""); interval != "" {
+		if _, ok := panel["interval"]; !ok {
+			panel["interval"] = pf.Config.ResolveRef(interval)
+		}
+	}
+	if hasKey(cfg, "compare_to") {
+		if err := applyCompareTo(panel, cfg); err != nil {
+			return nil, err
+		}
+	}
+	return panel, nil
+}
+
+// WithDefaults returns cfg merged over the config's panel_defaults: global
+// keys first, then the map under the panel's type, then cfg's own keys.
+// Maps under other panel type names are skipped. cfg is not modified.
+func (pf *PanelFactory) WithDefaults(cfg map[string]interface{}) map[string]interface{} {
+	defaults := pf.Config.PanelDefaults
+	if len(defaults) == 0 {
+		return cfg
+	}
+	merged := make(map[string]interface{}, len(defaults)+len(cfg))
+	for k, v := range defaults {
+		if _, isType := DefaultSizes[k]; isType {
+			if _, ok := v.(map[string]interface{}); ok {
+				continue
+			}
+		}
+		merged[k] = v
+	}
+	if typed, ok := defaults[getString(cfg, "type", "")].(map[string]interface{}); ok {
+		for k, v := range typed {
+			merged[k] = v
+		}
+	}
+	for k, v := range cfg {
+		merged[k] = v
+	}
+	return merged
+}
+
+func (pf *PanelFactory) fromConfig(cfg map[string]interface{}, x, y int) (map[string]interface{}, error) {
 	ptype := getString(cfg, "type", "")
 	switch ptype {
 	case "stat":
@@ -65,11 +138,60 @@ func (pf *PanelFactory) FromConfig(cfg map[string]interface{}, x, y int) (map[st
 		return pf.Logs(cfg, x, y), nil
 	case "comparison":
 		return pf.Comparison(cfg, x, y)
+	case "geomap":
+		return pf.Geomap(cfg, x, y), nil
+	case "candlestick":
+		return pf.Candlestick(cfg, x, y), nil
+	case "xychart":
+		return pf.XYChart(cfg, x, y), nil
+	case "alertlist":
+		return pf.AlertList(cfg, x, y), nil
+	case "dashlist":
+		return pf.Dashlist(cfg, x, y), nil
+	case "library":
+		return pf.LibraryRef(cfg, x, y)
+	case "trend":
+		return pf.Trend(cfg, x, y), nil
 	default:
 		return nil, fmt.Errorf("unknown panel type: %s", ptype)
 	}
 }
 
+// applyRepeat emits repeat (variable name, without $), repeatDirection
+// (h or v, default h) and, for horizontal repeats, maxPerRow.
+func applyRepeat(panel, cfg map[string]interface{}) error {
+	repeat := strings.TrimPrefix(getString(cfg, "repeat", ""), "$")
+	if repeat == "" {
+		return nil
+	}
+	dir := getString(cfg, "repeat_direction", "h")
+	if dir != "h" && dir != "v" {
+		return fmt.Errorf("repeat_direction must be h or v, got '%s'", dir)
+	}
+	panel["repeat"] = repeat
+	panel["repeatDirection"] = dir
+	if n := getInt(cfg, "max_per_row", 0); n > 0 && dir == "h" {
+		panel["maxPerRow"] = n
+	}
+	return nil
+}
+
+// applyNoValue sets fieldConfig.defaults.noValue, the text shown when a
+// query returns nothing, from no_value (or its alias no_data_text).
+func applyNoValue(panel, cfg map[string]interface{}) {
+	text := getString(cfg, "no_value", getString(cfg, "no_data_text", ""))
+	if text == "" {
+		return
+	}
+	fc, ok := panel["fieldConfig"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if defaults, ok := fc["defaults"].(map[string]interface{}); ok {
+		defaults["noValue"] = text
+	}
+}
+
 func (pf *PanelFactory) ds(cfg map[string]interface{}) map[string]interface{} {
 	dsName := getString(cfg, "datasource", "")
 	if dsName != "" {
@@ -87,6 +209,27 @@ func (pf *PanelFactory) target(expr, legend, refID string, datasource map[string
 		def := pf.Config.GetDefaultDatasource()
 		datasource = map[string]interface{}{"type": def.Type, "uid": def.UID}
 	}
+	if datasource["type"] == "influxdb" {
+		// InfluxQL: the query text goes in raw mode; legend templates are
+		// Prometheus-specific and not carried over.
+		return map[string]interface{}{
+			"datasource":   datasource,
+			"query":        pf.Config.ResolveRef(expr),
+			"rawQuery":     true,
+			"refId":        refID,
+			"resultFormat": "time_series",
+		}
+	}
+	if datasource["type"] == "loki" {
+		return map[string]interface{}{
+			"datasource":   datasource,
+			"editorMode":   "code",
+			"expr":         pf.Config.ResolveRef(expr),
+			"legendFormat": legend,
+			"queryType":    "range",
+			"refId":        refID,
+		}
+	}
 	return map[string]interface{}{
 		"datasource":   datasource,
 		"editorMode":   "code",
@@ -105,7 +248,7 @@ func (pf *PanelFactory) buildTargets(cfg map[string]interface{}, datasource map[
 
 	if query, ok := cfg["query"].(string); ok {
 		legend := getString(cfg, "legend", "{{instance}}")
-		targets = append(targets, pf.target(query, legend, "A", datasource))
+		targets = append(targets, applyInstant(pf.applyStep(pf.target(query, legend, "A", datasource), cfg, nil), cfg, nil))
 	}
 
 	if targetList, ok := cfg["targets"].([]interface{}); ok {
@@ -122,15 +265,127 @@ func (pf *PanelFactory) buildTargets(cfg map[string]interface{}, datasource map[
 				}
 			}
 			legend := getString(t, "legend", "{{instance}}")
-			refID := string(rune('A' + i))
 			expr := getString(t, "expr", "")
-			targets = append(targets, pf.target(expr, legend, refID, tDS))
+			targets = append(targets, applyInstant(pf.applyStep(pf.target(expr, legend, refID(i), tDS), cfg, t), cfg, t))
 		}
 	}
 
 	return targets
 }
 
+// applyStep sets a target's min interval and resolution from the panel's
+// interval/resolution keys; a targets entry may override either. Max data
+// points are a panel-level setting only (see maxDataPoints).
+func (pf *PanelFactory) applyStep(target, cfg, own map[string]interface{}) map[string]interface{} {
+	interval := getString(cfg, "interval", "")
+	resolution := getString(cfg, "resolution", "")
+	if own != nil {
+		interval = getString(own, "interval", interval)
+		resolution = getString(own, "resolution", resolution)
+		if hasKey(own, "max_data_points") {
+			fmt.Fprintf(os.Stderr, "  warning: panel '%s': max_data_points on a targets entry is ignored; set it on the panel\n",
+				getString(cfg, "title", ""))
+		}
+	}
+	if interval != "" {
+		target["interval"] = pf.Config.ResolveRef(interval)
+	}
+	if n := resolutionFactor(resolution); n > 1 {
+		target["intervalFactor"] = n
+	}
+	return target
+}
+
+// instantPanelTypes are the single-value and tabular panels where an
+// instant query is meaningful.
+var instantPanelTypes = map[string]bool{"stat": true, "gauge": true, "bargauge": true, "table": true}
+
+// applyInstant turns a Prometheus range target into an instant query when
+// the panel (or the targets entry) sets instant: true. Other panel types
+// warn and keep the range query.
+func applyInstant(target, cfg, own map[string]interface{}) map[string]interface{} {
+	instant := getBool(cfg, "instant", false)
+	if own != nil {
+		instant = getBool(own, "instant", instant)
+	}
+	if !instant {
+		return target
+	}
+	if _, ok := target["range"]; !ok {
+		return target
+	}
+	if ptype := getString(cfg, "type", ""); ptype != "" && !instantPanelTypes[ptype] {
+		fmt.Fprintf(os.Stderr, "  warning: instant is only supported on stat, gauge, bargauge and table panels, ignoring on '%s'\n", ptype)
+		return target
+	}
+	target["instant"] = true
+	target["range"] = false
+	return target
+}
+
+var compareShiftRe = regexp.MustCompile(`^\d+[smhdwy]$`)
+
+// applyCompareTo adds a previous-period comparison to a single-query stat or
+// gauge: a hidden target B evaluating the query shifted back by compare_to
+// ("now-7d" or "7d"), and a server-side math expression C showing the
+// percent change from B to A.
+func applyCompareTo(panel, cfg map[string]interface{}) error {
+	compareTo := getString(cfg, "compare_to", "")
+	if ptype := getString(cfg, "type", ""); ptype != "stat" && ptype != "gauge" {
+		return fmt.Errorf("compare_to is only supported on stat and gauge panels, not '%s'", ptype)
+	}
+	shift := strings.TrimPrefix(compareTo, "now-")
+	if !compareShiftRe.MatchString(shift) {
+		return fmt.Errorf("compare_to '%s': want a relative time like now-7d", compareTo)
+	}
+	targets, _ := panel["targets"].([]interface{})
+	if len(targets) != 1 {
+		return fmt.Errorf("compare_to needs exactly one query, got %d targets", len(targets))
+	}
+	current := targets[0].(map[string]interface{})
+	if _, ok := current["expr"].(string); !ok {
+		return fmt.Errorf("compare_to needs a PromQL query")
+	}
+
+	previous := make(map[string]interface{}, len(current))
+	for k, v := range current {
+		previous[k] = v
+	}
+	previous["expr"] = fmt.Sprintf("last_over_time((%s)[5m:] offset %s)", current["expr"], shift)
+	previous["legendFormat"] = compareTo
+	previous["refId"] = "B"
+	previous["hide"] = true
+
+	change := map[string]interface{}{
+		"datasource": map[string]interface{}{"type": "__expr__", "uid": "__expr__"},
+		"expression": "($A - $B) / $B * 100",
+		"refId":      "C",
+		"type":       "math",
+	}
+	panel["targets"] = append(targets, previous, change)
+
+	fc := panel["fieldConfig"].(map[string]interface{})
+	overrides, _ := fc["overrides"].([]interface{})
+	fc["overrides"] = append(overrides, map[string]interface{}{
+		"matcher": map[string]interface{}{"id": "byFrameRefID", "options": "C"},
+		"properties": []interface{}{
+			map[string]interface{}{"id": "unit", "value": "percent"},
+			map[string]interface{}{"id": "displayName", "value": "vs " + compareTo},
+		},
+	})
+	return nil
+}
+
+// resolutionFactor parses a Grafana resolution like "1/2" into its
+// intervalFactor (2). Anything else yields 1.
+func resolutionFactor(res string) int {
+	var n int
+	if _, err := fmt.Sscanf(res, "1/%d", &n); err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
 func (pf *PanelFactory) thresholds(cfg map[string]interface{}, defaultColor string) []interface{} {
 	if t, ok := cfg["thresholds"]; ok {
 		resolved := pf.Config.ResolveThresholds(t)
@@ -153,17 +408,216 @@ func (pf *PanelFactory) thresholds(cfg map[string]interface{}, defaultColor stri
 }
 
 func (pf *PanelFactory) overrides(cfg map[string]interface{}) []interface{} {
+	result := []interface{}{}
 	if o, ok := cfg["overrides"].([]interface{}); ok {
-		return o
+		for _, item := range o {
+			if m, ok := item.(map[string]interface{}); ok {
+				if _, short := m["matcher"].(string); short {
+					if expanded := pf.expandOverride(m); expanded != nil {
+						result = append(result, expanded)
+					}
+					continue
+				}
+			}
+			result = append(result, item)
+		}
 	}
-	return []interface{}{}
+	result = append(result, unitOverrides(cfg)...)
+	result = append(result, axisOverrides(cfg)...)
+	result = append(result, hideSeriesOverrides(cfg)...)
+	return result
+}
+
+// overrideMatchers are the matcher ids accepted by the overrides shorthand.
+var overrideMatchers = map[string]bool{"byName": true, "byRegexp": true, "byType": true, "byFrameRefID": true}
+
+// expandOverride expands an overrides shorthand entry ({matcher: byName,
+// value: cpu, properties: {unit: percent, color: $red}}) into Grafana's
+// verbose form. Properties are emitted sorted by id; a string color becomes
+// a fixed color and may be a palette ref. Verbose entries (matcher is a map)
+// never reach here and are passed through unchanged.
+func (pf *PanelFactory) expandOverride(m map[string]interface{}) map[string]interface{} {
+	matcher := getString(m, "matcher", "")
+	if !overrideMatchers[matcher] {
+		fmt.Fprintf(os.Stderr, "  warning: override matcher '%s' is not byName, byRegexp, byType or byFrameRefID, skipping\n", matcher)
+		return nil
+	}
+	props := []interface{}{}
+	if pm, ok := m["properties"].(map[string]interface{}); ok {
+		for _, id := range sortedKeys(pm) {
+			value := pm[id]
+			if c, ok := value.(string); ok && id == "color" {
+				value = map[string]interface{}{"fixedColor": pf.Config.ResolveColor(c), "mode": "fixed"}
+			}
+			props = append(props, map[string]interface{}{"id": id, "value": value})
+		}
+	}
+	return map[string]interface{}{
+		"matcher":    map[string]interface{}{"id": matcher, "options": m["value"]},
+		"properties": props,
+	}
+}
+
+// hideSeriesOverrides hides series matching each hide_series regex from the
+// legend, tooltip and graph while keeping their queries.
+func hideSeriesOverrides(cfg map[string]interface{}) []interface{} {
+	var result []interface{}
+	for _, pattern := range getStringSliceAsStrings(cfg, "hide_series") {
+		result = append(result, map[string]interface{}{
+			"matcher": map[string]interface{}{"id": "byRegexp", "options": pattern},
+			"properties": []interface{}{
+				map[string]interface{}{
+					"id":    "custom.hideFrom",
+					"value": map[string]interface{}{"legend": true, "tooltip": true, "viz": true},
+				},
+			},
+		})
+	}
+	return result
+}
+
+// unitOverrides expands unit_overrides (series-name substring -> unit) into
+// byRegexp field overrides, sorted by substring for stable output.
+func unitOverrides(cfg map[string]interface{}) []interface{} {
+	m, ok := cfg["unit_overrides"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var result []interface{}
+	for _, substr := range sortedKeys(m) {
+		unit, ok := m[substr].(string)
+		if !ok {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"matcher": map[string]interface{}{
+				"id":      "byRegexp",
+				"options": ".*" + regexp.QuoteMeta(substr) + ".*",
+			},
+			"properties": []interface{}{
+				map[string]interface{}{"id": "unit", "value": unit},
+			},
+		})
+	}
+	return result
+}
+
+// axisOverrides expands axis_overrides entries ({series_regex, axis, unit,
+// label}) into byRegexp overrides, for dual-axis timeseries panels.
+func axisOverrides(cfg map[string]interface{}) []interface{} {
+	list, ok := cfg["axis_overrides"].([]interface{})
+	if !ok {
+		return nil
+	}
+	var result []interface{}
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		pattern := getString(m, "series_regex", "")
+		if pattern == "" {
+			continue
+		}
+		props := []interface{}{
+			map[string]interface{}{"id": "custom.axisPlacement", "value": getString(m, "axis", "right")},
+		}
+		if unit := getString(m, "unit", ""); unit != "" {
+			props = append(props, map[string]interface{}{"id": "unit", "value": unit})
+		}
+		if label := getString(m, "label", ""); label != "" {
+			props = append(props, map[string]interface{}{"id": "custom.axisLabel", "value": label})
+		}
+		result = append(result, map[string]interface{}{
+			"matcher":    map[string]interface{}{"id": "byRegexp", "options": pattern},
+			"properties": props,
+		})
+	}
+	return result
 }
 
+// valueMappings returns value_mappings followed by the states mappings.
+// value_mappings may be verbose Grafana mappings (entries with a type),
+// passed through, or shorthand: a value -> text map ({"0": down, "1": up},
+// or {"0": {text: down, color: $red}}) or a list of {value, text, color} /
+// {from, to, text, color} entries, as for states. Mapping indexes run on
+// from value_mappings into states.
 func (pf *PanelFactory) valueMappings(cfg map[string]interface{}) []interface{} {
-	if m, ok := cfg["value_mappings"].([]interface{}); ok {
-		return m
+	mappings := []interface{}{}
+	var short []interface{}
+	switch m := cfg["value_mappings"].(type) {
+	case []interface{}:
+		for _, item := range m {
+			if entry, ok := item.(map[string]interface{}); ok && !hasKey(entry, "type") {
+				short = append(short, entry)
+				continue
+			}
+			mappings = append(mappings, item)
+		}
+	case map[string]interface{}:
+		for _, value := range sortedKeys(m) {
+			entry := map[string]interface{}{"value": value}
+			switch v := m[value].(type) {
+			case nil:
+				// "0": with no text maps the value without relabeling it
+			case map[string]interface{}:
+				entry["text"], entry["color"] = v["text"], v["color"]
+			default:
+				entry["text"] = fmt.Sprint(v)
+			}
+			short = append(short, entry)
+		}
 	}
-	return []interface{}{}
+	mappings = append(mappings, pf.expandMappings(short, 0)...)
+	return append(mappings, pf.stateMappings(cfg, len(short))...)
+}
+
+// stateMappings expands the states shorthand, a list of {value, text, color}
+// or {from, to, text, color} entries, into Grafana value and range mappings
+// so discrete states (OK/WARN/CRIT) show as colored text. Colors accept
+// palette refs. Indexes start at start.
+func (pf *PanelFactory) stateMappings(cfg map[string]interface{}, start int) []interface{} {
+	list, ok := cfg["states"].([]interface{})
+	if !ok {
+		return nil
+	}
+	return pf.expandMappings(list, start)
+}
+
+// expandMappings turns {value, text, color} and {from, to, text, color}
+// entries into one value mapping plus a range mapping per range entry,
+// numbering results from start.
+func (pf *PanelFactory) expandMappings(list []interface{}, start int) []interface{} {
+	values := map[string]interface{}{}
+	var ranges []interface{}
+	for i, item := range list {
+		st, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		result := map[string]interface{}{"index": start + i}
+		if text := getString(st, "text", ""); text != "" {
+			result["text"] = text
+		}
+		if color := pf.Config.ResolveColor(getString(st, "color", "")); color != "" {
+			result["color"] = color
+		}
+		if v, ok := st["value"]; ok {
+			values[fmt.Sprint(v)] = result
+			continue
+		}
+		if hasKey(st, "from") || hasKey(st, "to") {
+			ranges = append(ranges, map[string]interface{}{
+				"type":    "range",
+				"options": map[string]interface{}{"from": st["from"], "to": st["to"], "result": result},
+			})
+		}
+	}
+	var mappings []interface{}
+	if len(values) > 0 {
+		mappings = append(mappings, map[string]interface{}{"type": "value", "options": values})
+	}
+	return append(mappings, ranges...)
 }
 
 func (pf *PanelFactory) dataLinks(cfg map[string]interface{}) []interface{} {
@@ -173,6 +627,139 @@ func (pf *PanelFactory) dataLinks(cfg map[string]interface{}) []interface{} {
 	return []interface{}{}
 }
 
+// insertNulls resolves insert_nulls: a bool, a number of milliseconds, or a
+// duration string ("5m", or Grafana-style "1d"/"1w") converted to
+// milliseconds. Lines break across gaps larger than the threshold.
+func insertNulls(cfg map[string]interface{}) interface{} {
+	switch v := cfg["insert_nulls"].(type) {
+	case bool:
+		return v
+	case int, float64:
+		return getNumber(cfg, "insert_nulls", 0)
+	case string:
+		if d, ok := parseGrafanaDuration(v); ok {
+			return int(d.Milliseconds())
+		}
+		fmt.Fprintf(os.Stderr, "  warning: insert_nulls %q is not a duration, ignoring\n", v)
+	}
+	return false
+}
+
+// parseGrafanaDuration parses a Go duration, plus the whole-day and
+// whole-week units ("1d", "2w") Grafana accepts and time.ParseDuration does not.
+func parseGrafanaDuration(s string) (time.Duration, bool) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, true
+	}
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if len(s) < 2 {
+		return 0, false
+	}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// legendCalcIDs are the reducer ids Grafana accepts in legend calcs, besides
+// percentiles (p1..p99).
+var legendCalcIDs = map[string]bool{
+	"lastNotNull": true, "last": true, "firstNotNull": true, "first": true,
+	"min": true, "max": true, "mean": true, "median": true, "sum": true,
+	"count": true, "range": true, "delta": true, "step": true, "diff": true,
+	"diffperc": true, "logmin": true, "allIsZero": true, "allIsNull": true,
+	"changeCount": true, "distinctCount": true, "variance": true, "stdDev": true,
+}
+
+// legendCalcAliases maps friendly names to Grafana reducer ids.
+var legendCalcAliases = map[string]string{
+	"last":    "lastNotNull",
+	"current": "lastNotNull",
+	"first":   "firstNotNull",
+	"avg":     "mean",
+	"average": "mean",
+	"total":   "sum",
+	"stddev":  "stdDev",
+}
+
+var percentileCalcRe = regexp.MustCompile(`^p[1-9][0-9]?$`)
+
+// normalizeCalc maps a legend calc name to its Grafana id. ok is false for
+// names Grafana does not know; they are passed through unchanged.
+func normalizeCalc(name string) (string, bool) {
+	if id, ok := legendCalcAliases[name]; ok {
+		return id, true
+	}
+	return name, legendCalcIDs[name] || percentileCalcRe.MatchString(name)
+}
+
+// legendCalcs resolves legend_calcs, mapping aliases and warning on unknown
+// calcs, which Grafana would otherwise silently drop from the legend.
+func legendCalcs(cfg map[string]interface{}) []interface{} {
+	raw := getStringSlice(cfg, "legend_calcs", []string{})
+	calcs := make([]interface{}, 0, len(raw))
+	for _, c := range raw {
+		name, _ := c.(string)
+		id, ok := normalizeCalc(name)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "  warning: unknown legend calc '%s' in panel '%s'\n", name, getString(cfg, "title", ""))
+		}
+		calcs = append(calcs, id)
+	}
+	return calcs
+}
+
+// legendWidth sets legend_width (pixels) on a right-placed legend so calc
+// columns don't squeeze the graph; Grafana ignores width at the bottom.
+func legendWidth(cfg, legend map[string]interface{}) map[string]interface{} {
+	if w := getInt(cfg, "legend_width", 0); w > 0 && legend["placement"] == "right" {
+		legend["width"] = w
+	}
+	return legend
+}
+
+// reduceCalcs returns the calcs for a single-value stat or gauge, warning
+// when several are set without reduce_values.
+func reduceCalcs(cfg map[string]interface{}) []interface{} {
+	calcs := getStringSlice(cfg, "calcs", []string{"lastNotNull"})
+	if msg := reduceCalcsWarning(cfg, calcs); msg != "" {
+		fmt.Fprintf(os.Stderr, "  warning: %s\n", msg)
+	}
+	return calcs
+}
+
+// reduceCalcsWarning explains why multiple calcs are a misconfiguration:
+// without reduce_values Grafana reduces each field to one value and shows
+// only the first calc.
+func reduceCalcsWarning(cfg map[string]interface{}, calcs []interface{}) string {
+	if len(calcs) <= 1 || getBool(cfg, "reduce_values", false) {
+		return ""
+	}
+	return fmt.Sprintf("panel '%s' sets %d calcs; only the first is shown unless reduce_values: true",
+		getString(cfg, "title", ""), len(calcs))
+}
+
+// orientation resolves the orientation key. An explicit "auto" picks
+// horizontal for wide panels and vertical for tall ones; a grid column is
+// roughly twice as wide as a grid row is tall, so width counts double when
+// comparing. Without the key, def is emitted as is, so Grafana's own "auto"
+// stays untouched.
+func orientation(cfg map[string]interface{}, def string, w, h int) string {
+	o := getString(cfg, "orientation", "")
+	if o != "auto" {
+		return defaultStr(o, def)
+	}
+	if w*2 >= h {
+		return "horizontal"
+	}
+	return "vertical"
+}
+
 // Row creates a row panel.
 func (pf *PanelFactory) Row(title string, y int, collapsed bool, panels []interface{}, repeat string) map[string]interface{} {
 	if panels == nil {
@@ -222,11 +809,11 @@ func (pf *PanelFactory) Stat(cfg map[string]interface{}, x, y int) map[string]in
 			"colorMode":   getString(cfg, "color_mode", "background"),
 			"graphMode":   getString(cfg, "graph_mode", "none"),
 			"justifyMode": "center",
-			"orientation":  "auto",
+			"orientation": orientation(cfg, "auto", w, h),
 			"reduceOptions": map[string]interface{}{
-				"calcs":  getStringSlice(cfg, "calcs", []string{"lastNotNull"}),
+				"calcs":  reduceCalcs(cfg),
 				"fields": "",
-				"values": false,
+				"values": getBool(cfg, "reduce_values", false),
 			},
 			"showPercentChange": false,
 			"textMode":          getString(cfg, "text_mode", "value_and_name"),
@@ -265,11 +852,11 @@ func (pf *PanelFactory) Gauge(cfg map[string]interface{}, x, y int) map[string]i
 		"options": map[string]interface{}{
 			"minVizHeight": 75,
 			"minVizWidth":  75,
-			"orientation":  "auto",
+			"orientation":  orientation(cfg, "auto", w, h),
 			"reduceOptions": map[string]interface{}{
-				"calcs":  getStringSlice(cfg, "calcs", []string{"lastNotNull"}),
+				"calcs":  reduceCalcs(cfg),
 				"fields": "",
-				"values": false,
+				"values": getBool(cfg, "reduce_values", false),
 			},
 			"showThresholdLabels":  getBool(cfg, "show_threshold_labels", false),
 			"showThresholdMarkers": getBool(cfg, "show_threshold_markers", true),
@@ -311,14 +898,14 @@ func (pf *PanelFactory) Timeseries(cfg map[string]interface{}, x, y int) map[str
 					"fillOpacity":       fill,
 					"gradientMode":      "scheme",
 					"hideFrom":          map[string]interface{}{"legend": false, "tooltip": false, "viz": false},
-					"insertNulls":       false,
+					"insertNulls":       insertNulls(cfg),
 					"lineInterpolation": interpolation,
 					"lineWidth":         line,
 					"pointSize":         5,
 					"scaleDistribution": map[string]interface{}{"type": "linear"},
 					"showPoints":        "never",
 					"spanNulls":         false,
-					"stacking":          map[string]interface{}{"group": "A", "mode": stack},
+					"stacking":          map[string]interface{}{"group": getString(cfg, "stack_group", "A"), "mode": stack},
 					"thresholdsStyle":   map[string]interface{}{"mode": "off"},
 				},
 				"mappings":   pf.valueMappings(cfg),
@@ -331,12 +918,12 @@ func (pf *PanelFactory) Timeseries(cfg map[string]interface{}, x, y int) map[str
 		"gridPos": map[string]interface{}{"h": h, "w": w, "x": x, "y": y},
 		"id":      pf.IDGen.Next(),
 		"options": map[string]interface{}{
-			"legend": map[string]interface{}{
-				"calcs":       getStringSlice(cfg, "legend_calcs", []string{}),
+			"legend": legendWidth(cfg, map[string]interface{}{
+				"calcs":       legendCalcs(cfg),
 				"displayMode": getString(cfg, "legend_mode", "list"),
 				"placement":   getString(cfg, "legend_placement", "bottom"),
 				"showLegend":  getBool(cfg, "show_legend", true),
-			},
+			}),
 			"tooltip": map[string]interface{}{"mode": "multi", "sort": "desc"},
 		},
 		"pluginVersion": "11.2.0",
@@ -375,7 +962,7 @@ func (pf *PanelFactory) Bargauge(cfg map[string]interface{}, x, y int) map[strin
 			"minVizHeight": 16,
 			"minVizWidth":  8,
 			"namePlacement": "auto",
-			"orientation":  getString(cfg, "orientation", "horizontal"),
+			"orientation":  orientation(cfg, "horizontal", w, h),
 			"reduceOptions": map[string]interface{}{
 				"calcs":  getStringSlice(cfg, "calcs", []string{"lastNotNull"}),
 				"fields": "",
@@ -399,6 +986,20 @@ func (pf *PanelFactory) Heatmap(cfg map[string]interface{}, x, y int) map[string
 	w := getInt(cfg, "width", dw)
 	h := getInt(cfg, "height", dh)
 	scheme := getString(cfg, "color_scheme", "Spectral")
+
+	// pre-bucketed le series: y_sort implies the le rows layout, and
+	// heatmap-formatted targets make Prometheus return buckets in le order
+	ySort := getString(cfg, "y_sort", "")
+	layout := getString(cfg, "rows_layout", "auto")
+	if ySort != "" && !hasKey(cfg, "rows_layout") {
+		layout = "le"
+	}
+	targets := pf.buildTargets(cfg, nil)
+	if layout == "le" {
+		for _, t := range targets {
+			t.(map[string]interface{})["format"] = "heatmap"
+		}
+	}
 	return map[string]interface{}{
 		"datasource":  pf.ds(cfg),
 		"description": getString(cfg, "description", ""),
@@ -435,16 +1036,16 @@ func (pf *PanelFactory) Heatmap(cfg map[string]interface{}, x, y int) map[string
 			"exemplars":    map[string]interface{}{"color": "rgba(153,204,255,0.7)"},
 			"filterValues": map[string]interface{}{"le": 1e-9},
 			"legend":       map[string]interface{}{"show": true},
-			"rowsFrame":    map[string]interface{}{"layout": "auto"},
+			"rowsFrame":    map[string]interface{}{"layout": layout},
 			"tooltip":      map[string]interface{}{"show": true, "yHistogram": false},
 			"yAxis": map[string]interface{}{
 				"axisPlacement": "left",
-				"reverse":       false,
+				"reverse":       ySort == "desc",
 				"unit":          getString(cfg, "y_unit", "short"),
 			},
 		},
 		"pluginVersion": "11.2.0",
-		"targets":       pf.buildTargets(cfg, nil),
+		"targets":       targets,
 		"title":         getString(cfg, "title", ""),
 		"transparent":   getBool(cfg, "transparent", true),
 		"type":          "heatmap",
@@ -456,34 +1057,49 @@ func (pf *PanelFactory) Histogram(cfg map[string]interface{}, x, y int) map[stri
 	dw, dh := DefaultSizes["histogram"][0], DefaultSizes["histogram"][1]
 	w := getInt(cfg, "width", dw)
 	h := getInt(cfg, "height", dh)
+	defaults := map[string]interface{}{
+		"color": map[string]interface{}{"mode": getString(cfg, "color_mode", "palette-classic-by-name")},
+		"custom": map[string]interface{}{
+			"fillOpacity":  getInt(cfg, "fill_opacity", 80),
+			"gradientMode": "none",
+			"hideFrom":     map[string]interface{}{"legend": false, "tooltip": false, "viz": false},
+			"lineWidth":    1,
+		},
+		"mappings":   []interface{}{},
+		"thresholds": map[string]interface{}{"mode": "absolute", "steps": pf.thresholds(cfg, "")},
+		"unit":       getString(cfg, "unit", "short"),
+	}
+	// fixed-range histograms pin the x axis
+	if _, ok := cfg["x_min"]; ok {
+		defaults["min"] = getNumber(cfg, "x_min", 0)
+	}
+	if _, ok := cfg["x_max"]; ok {
+		defaults["max"] = getNumber(cfg, "x_max", 0)
+	}
+	options := map[string]interface{}{
+		"bucketCount":  getInt(cfg, "bucket_count", 30),
+		"combine":      getBool(cfg, "combine", false),
+		"fillOpacity":  getInt(cfg, "fill_opacity", 80),
+		"gradientMode": "none",
+		"legend":       map[string]interface{}{"calcs": []interface{}{}, "displayMode": "list", "placement": "bottom", "showLegend": true},
+		"tooltip":      map[string]interface{}{"mode": "multi", "sort": "desc"},
+	}
+	if _, ok := cfg["bucket_size"]; ok {
+		options["bucketSize"] = getNumber(cfg, "bucket_size", 0)
+	}
+	if _, ok := cfg["bucket_offset"]; ok {
+		options["bucketOffset"] = getNumber(cfg, "bucket_offset", 0)
+	}
 	return map[string]interface{}{
 		"datasource":  pf.ds(cfg),
 		"description": getString(cfg, "description", ""),
 		"fieldConfig": map[string]interface{}{
-			"defaults": map[string]interface{}{
-				"color": map[string]interface{}{"mode": getString(cfg, "color_mode", "palette-classic-by-name")},
-				"custom": map[string]interface{}{
-					"fillOpacity":  getInt(cfg, "fill_opacity", 80),
-					"gradientMode": "none",
-					"hideFrom":     map[string]interface{}{"legend": false, "tooltip": false, "viz": false},
-					"lineWidth":    1,
-				},
-				"mappings":   []interface{}{},
-				"thresholds": map[string]interface{}{"mode": "absolute", "steps": pf.thresholds(cfg, "")},
-				"unit":       getString(cfg, "unit", "short"),
-			},
+			"defaults":  defaults,
 			"overrides": pf.overrides(cfg),
 		},
-		"gridPos": map[string]interface{}{"h": h, "w": w, "x": x, "y": y},
-		"id":      pf.IDGen.Next(),
-		"options": map[string]interface{}{
-			"bucketCount":  getInt(cfg, "bucket_count", 30),
-			"combine":      getBool(cfg, "combine", false),
-			"fillOpacity":  getInt(cfg, "fill_opacity", 80),
-			"gradientMode": "none",
-			"legend":       map[string]interface{}{"calcs": []interface{}{}, "displayMode": "list", "placement": "bottom", "showLegend": true},
-			"tooltip":      map[string]interface{}{"mode": "multi", "sort": "desc"},
-		},
+		"gridPos":       map[string]interface{}{"h": h, "w": w, "x": x, "y": y},
+		"id":            pf.IDGen.Next(),
+		"options":       options,
 		"pluginVersion": "11.2.0",
 		"targets":       pf.buildTargets(cfg, nil),
 		"title":         getString(cfg, "title", ""),
@@ -570,12 +1186,12 @@ func (pf *PanelFactory) Piechart(cfg map[string]interface{}, x, y int) map[strin
 		"id":      pf.IDGen.Next(),
 		"options": map[string]interface{}{
 			"displayLabels": getStringSlice(cfg, "display_labels", []string{"percent"}),
-			"legend": map[string]interface{}{
-				"calcs":       getStringSlice(cfg, "legend_calcs", []string{}),
+			"legend": legendWidth(cfg, map[string]interface{}{
+				"calcs":       legendCalcs(cfg),
 				"displayMode": getString(cfg, "legend_mode", "list"),
 				"placement":   getString(cfg, "legend_placement", "right"),
 				"showLegend":  true,
-			},
+			}),
 			"pieType": getString(cfg, "pie_type", "donut"),
 			"reduceOptions": map[string]interface{}{
 				"calcs":  getStringSlice(cfg, "calcs", []string{"lastNotNull"}),
@@ -597,7 +1213,7 @@ func (pf *PanelFactory) StateTimeline(cfg map[string]interface{}, x, y int) map[
 	dw, dh := DefaultSizes["state-timeline"][0], DefaultSizes["state-timeline"][1]
 	w := getInt(cfg, "width", dw)
 	h := getInt(cfg, "height", dh)
-	return map[string]interface{}{
+	p := map[string]interface{}{
 		"datasource":  pf.ds(cfg),
 		"description": getString(cfg, "description", ""),
 		"fieldConfig": map[string]interface{}{
@@ -630,6 +1246,10 @@ func (pf *PanelFactory) StateTimeline(cfg map[string]interface{}, x, y int) map[
 		"transparent":   getBool(cfg, "transparent", true),
 		"type":          "state-timeline",
 	}
+	if n := maxDataPoints(cfg, stateReduceDataPoints); n > 0 {
+		p["maxDataPoints"] = n
+	}
+	return p
 }
 
 // StatusHistory creates a status-history panel.
@@ -637,7 +1257,7 @@ func (pf *PanelFactory) StatusHistory(cfg map[string]interface{}, x, y int) map[
 	dw, dh := DefaultSizes["status-history"][0], DefaultSizes["status-history"][1]
 	w := getInt(cfg, "width", dw)
 	h := getInt(cfg, "height", dh)
-	return map[string]interface{}{
+	p := map[string]interface{}{
 		"datasource":  pf.ds(cfg),
 		"description": getString(cfg, "description", ""),
 		"fieldConfig": map[string]interface{}{
@@ -669,6 +1289,28 @@ func (pf *PanelFactory) StatusHistory(cfg map[string]interface{}, x, y int) map[
 		"transparent":   getBool(cfg, "transparent", true),
 		"type":          "status-history",
 	}
+	if n := maxDataPoints(cfg, stateReduceDataPoints); n > 0 {
+		p["maxDataPoints"] = n
+	}
+	return p
+}
+
+// stateReduceDataPoints caps query resolution for state panels with
+// reduce: true, keeping state bands readable on dense data.
+const stateReduceDataPoints = 100
+
+// maxDataPoints resolves a panel's maxDataPoints from max_data_points, or
+// 0 to leave Grafana's default. Grafana reads it per panel, never per target.
+// Panels with a reduce mode pass the cap that reduce: true applies when
+// max_data_points is unset; others pass 0.
+func maxDataPoints(cfg map[string]interface{}, reduceCap int) int {
+	if n := getInt(cfg, "max_data_points", 0); n > 0 {
+		return n
+	}
+	if getBool(cfg, "reduce", false) {
+		return reduceCap
+	}
+	return 0
 }
 
 // Text creates a text panel.
@@ -676,24 +1318,113 @@ func (pf *PanelFactory) Text(cfg map[string]interface{}, x, y int) map[string]in
 	dw, dh := DefaultSizes["text"][0], DefaultSizes["text"][1]
 	w := getInt(cfg, "width", dw)
 	h := getInt(cfg, "height", dh)
+	options := map[string]interface{}{
+		"code": map[string]interface{}{
+			"language":        "plaintext",
+			"showLineNumbers": false,
+			"showMiniMap":     false,
+		},
+		"content": getString(cfg, "content", ""),
+		"mode":    getString(cfg, "mode", "markdown"),
+	}
+	// Grafana only honors this when the server runs with
+	// panels.disable_sanitize_html enabled; it is emitted only when requested.
+	if getBool(cfg, "disable_sanitize", false) {
+		options["disableSanitizeHtml"] = true
+	}
+	return map[string]interface{}{
+		"datasource":    pf.ds(cfg),
+		"description":   getString(cfg, "description", ""),
+		"gridPos":       map[string]interface{}{"h": h, "w": w, "x": x, "y": y},
+		"id":            pf.IDGen.Next(),
+		"options":       options,
+		"pluginVersion": "11.2.0",
+		"title":         getString(cfg, "title", ""),
+		"transparent":   getBool(cfg, "transparent", true),
+		"type":          "text",
+	}
+}
+
+// alertStates are the Grafana alert states an alertlist can filter on.
+var alertStates = []string{"firing", "pending", "normal", "noData", "error"}
+
+// AlertList creates an alert list panel. It queries Grafana's alerting
+// itself, so it has no targets. state_filter lists the states shown
+// (default firing and pending); show is current or changes.
+func (pf *PanelFactory) AlertList(cfg map[string]interface{}, x, y int) map[string]interface{} {
+	dw, dh := DefaultSizes["alertlist"][0], DefaultSizes["alertlist"][1]
+	w := getInt(cfg, "width", dw)
+	h := getInt(cfg, "height", dh)
+
+	show := getString(cfg, "show", "current")
+	if show != "current" && show != "changes" {
+		fmt.Fprintf(os.Stderr, "  warning: alertlist show '%s' is not current or changes, using current\n", show)
+		show = "current"
+	}
+	stateFilter := make(map[string]interface{}, len(alertStates))
+	for _, st := range alertStates {
+		stateFilter[st] = false
+	}
+	for _, st := range getStringSlice(cfg, "state_filter", []string{"firing", "pending"}) {
+		name, _ := st.(string)
+		if _, ok := stateFilter[name]; !ok {
+			fmt.Fprintf(os.Stderr, "  warning: alertlist state_filter '%v' is not one of %s, ignoring\n", st, strings.Join(alertStates, ", "))
+			continue
+		}
+		stateFilter[name] = true
+	}
+
 	return map[string]interface{}{
-		"datasource":  pf.ds(cfg),
 		"description": getString(cfg, "description", ""),
 		"gridPos":     map[string]interface{}{"h": h, "w": w, "x": x, "y": y},
 		"id":          pf.IDGen.Next(),
 		"options": map[string]interface{}{
-			"code": map[string]interface{}{
-				"language":        "plaintext",
-				"showLineNumbers": false,
-				"showMiniMap":     false,
-			},
-			"content": getString(cfg, "content", ""),
-			"mode":    getString(cfg, "mode", "markdown"),
+			"alertInstanceLabelFilter": getString(cfg, "label_filter", ""),
+			"alertName":                getString(cfg, "alert_name", ""),
+			"dashboardAlerts":          getBool(cfg, "dashboard_alerts", false),
+			"groupBy":                  []interface{}{},
+			"groupMode":                "default",
+			"maxItems":                 getInt(cfg, "max_items", 20),
+			"showOptions":              show,
+			"sortOrder":                1,
+			"stateFilter":              stateFilter,
+			"viewMode":                 "list",
 		},
 		"pluginVersion": "11.2.0",
 		"title":         getString(cfg, "title", ""),
 		"transparent":   getBool(cfg, "transparent", true),
-		"type":          "text",
+		"type":          "alertlist",
+	}
+}
+
+// Dashlist creates a dashboard list panel, e.g. for a landing page linking
+// every dashboard carrying the given tags. Like alertlist it has no targets.
+func (pf *PanelFactory) Dashlist(cfg map[string]interface{}, x, y int) map[string]interface{} {
+	dw, dh := DefaultSizes["dashlist"][0], DefaultSizes["dashlist"][1]
+	w := getInt(cfg, "width", dw)
+	h := getInt(cfg, "height", dh)
+
+	return map[string]interface{}{
+		"description": getString(cfg, "description", ""),
+		"gridPos":     map[string]interface{}{"h": h, "w": w, "x": x, "y": y},
+		"id":          pf.IDGen.Next(),
+		"options": map[string]interface{}{
+			"folderUID":          getString(cfg, "folder_uid", ""),
+			"includeVars":        getBool(cfg, "include_vars", false),
+			"keepTime":           getBool(cfg, "keep_time", false),
+			"maxItems":           getInt(cfg, "max_items", 10),
+			"query":              getString(cfg, "query", ""),
+			"showFolderNames":    getBool(cfg, "show_folder_names", true),
+			"showHeadings":       getBool(cfg, "show_headings", true),
+			"showRecentlyViewed": getBool(cfg, "show_recent", false),
+			"showSearch":         getBool(cfg, "show_search", true),
+			"showStarred":        getBool(cfg, "show_starred", false),
+			"tags":               getStringSlice(cfg, "tags", []string{}),
+		},
+		"pluginVersion": "11.2.0",
+		"title":         getString(cfg, "title", ""),
+		"transparent":   getBool(cfg, "transparent", true),
+		"type":          "dashlist",
 	}
 }
 
@@ -702,6 +1433,14 @@ func (pf *PanelFactory) Logs(cfg map[string]interface{}, x, y int) map[string]in
 	dw, dh := DefaultSizes["logs"][0], DefaultSizes["logs"][1]
 	w := getInt(cfg, "width", dw)
 	h := getInt(cfg, "height", dh)
+	// log stream queries have no series to name
+	targets := pf.buildTargets(cfg, nil)
+	for _, t := range targets {
+		tm := t.(map[string]interface{})
+		if ds, ok := tm["datasource"].(map[string]interface{}); ok && ds["type"] == "loki" {
+			delete(tm, "legendFormat")
+		}
+	}
 	return map[string]interface{}{
 		"datasource":  pf.ds(cfg),
 		"description": getString(cfg, "description", ""),
@@ -718,13 +1457,343 @@ func (pf *PanelFactory) Logs(cfg map[string]interface{}, x, y int) map[string]in
 			"wrapLogMessage":    getBool(cfg, "wrap", true),
 		},
 		"pluginVersion": "11.2.0",
-		"targets":       pf.buildTargets(cfg, nil),
+		"targets":       targets,
 		"title":         getString(cfg, "title", ""),
 		"transparent":   getBool(cfg, "transparent", true),
 		"type":          "logs",
 	}
 }
 
+// Geomap creates a geomap panel with a default basemap and one data layer.
+// Points are located by geohash_field, by lat_field/lon_field, or by
+// Grafana's field-name auto detection when neither is set.
+func (pf *PanelFactory) Geomap(cfg map[string]interface{}, x, y int) map[string]interface{} {
+	dw, dh := DefaultSizes["geomap"][0], DefaultSizes["geomap"][1]
+	w := getInt(cfg, "width", dw)
+	h := getInt(cfg, "height", dh)
+
+	layerType := getString(cfg, "layer_type", "markers")
+	var layerConfig map[string]interface{}
+	switch layerType {
+	case "heatmap":
+		layerConfig = map[string]interface{}{
+			"blur":   getInt(cfg, "blur", 15),
+			"radius": getInt(cfg, "radius", 5),
+			"weight": map[string]interface{}{"fixed": 1, "max": 1, "min": 0},
+		}
+	default:
+		if layerType != "markers" {
+			fmt.Fprintf(os.Stderr, "  warning: geomap layer_type '%s' is not markers or heatmap, using markers\n", layerType)
+			layerType = "markers"
+		}
+		layerConfig = map[string]interface{}{
+			"showLegend": true,
+			"style": map[string]interface{}{
+				"color":   map[string]interface{}{"fixed": pf.Config.ResolveColor(getString(cfg, "color", "green"))},
+				"opacity": getFloat(cfg, "opacity", 0.4),
+				"size":    map[string]interface{}{"fixed": getInt(cfg, "marker_size", 5), "max": 15, "min": 2},
+				"symbol":  map[string]interface{}{"fixed": "img/icons/marker/circle.svg", "mode": "fixed"},
+			},
+		}
+	}
+
+	location := map[string]interface{}{"mode": "auto"}
+	if field := getString(cfg, "geohash_field", ""); field != "" {
+		location = map[string]interface{}{"mode": "geohash", "geohash": field}
+	} else if hasKey(cfg, "lat_field") || hasKey(cfg, "lon_field") {
+		location = map[string]interface{}{
+			"mode":      "coords",
+			"latitude":  getString(cfg, "lat_field", "latitude"),
+			"longitude": getString(cfg, "lon_field", "longitude"),
+		}
+	}
+
+	// locations come from labels, so Prometheus targets are instant tables
+	targets := pf.buildTargets(cfg, nil)
+	for _, t := range targets {
+		tm := t.(map[string]interface{})
+		if _, ok := tm["expr"]; ok {
+			tm["format"] = "table"
+			tm["instant"] = true
+			tm["range"] = false
+		}
+	}
+
+	return map[string]interface{}{
+		"datasource":  pf.ds(cfg),
+		"description": getString(cfg, "description", ""),
+		"fieldConfig": map[string]interface{}{
+			"defaults": map[string]interface{}{
+				"color":      map[string]interface{}{"mode": "thresholds"},
+				"mappings":   pf.valueMappings(cfg),
+				"thresholds": map[string]interface{}{"mode": "absolute", "steps": pf.thresholds(cfg, "")},
+				"unit":       getString(cfg, "unit", "short"),
+			},
+			"overrides": pf.overrides(cfg),
+		},
+		"gridPos": map[string]interface{}{"h": h, "w": w, "x": x, "y": y},
+		"id":      pf.IDGen.Next(),
+		"options": map[string]interface{}{
+			"basemap": map[string]interface{}{
+				"config": map[string]interface{}{},
+				"name":   "Basemap",
+				"type":   getString(cfg, "basemap", "default"),
+			},
+			"controls": map[string]interface{}{
+				"mouseWheelZoom":  getBool(cfg, "mouse_wheel_zoom", true),
+				"showAttribution": true,
+				"showZoom":        true,
+			},
+			"layers": []interface{}{
+				map[string]interface{}{
+					"config":   layerConfig,
+					"location": location,
+					"name":     "Layer 1",
+					"tooltip":  true,
+					"type":     layerType,
+				},
+			},
+			"tooltip": map[string]interface{}{"mode": "details"},
+			"view": map[string]interface{}{
+				"allLayers": true,
+				"id":        "coords",
+				"lat":       getFloat(cfg, "initial_lat", 0),
+				"lon":       getFloat(cfg, "initial_lon", 0),
+				"zoom":      getFloat(cfg, "initial_zoom", 1),
+			},
+		},
+		"pluginVersion": "11.2.0",
+		"targets":       targets,
+		"title":         getString(cfg, "title", ""),
+		"transparent":   getBool(cfg, "transparent", true),
+		"type":          "geomap",
+	}
+}
+
+// Candlestick creates an OHLC candlestick panel. open_field, high_field,
+// low_field and close_field name the series (usually target legends such as
+// p50/p99) Grafana draws each candle from; unset fields are auto-detected.
+func (pf *PanelFactory) Candlestick(cfg map[string]interface{}, x, y int) map[string]interface{} {
+	dw, dh := DefaultSizes["candlestick"][0], DefaultSizes["candlestick"][1]
+	w := getInt(cfg, "width", dw)
+	h := getInt(cfg, "height", dh)
+
+	mode := getString(cfg, "mode", "candles")
+	if mode != "candles" && mode != "volume" && mode != "candles+volume" {
+		fmt.Fprintf(os.Stderr, "  warning: candlestick mode '%s' is not candles, volume or candles+volume, using candles\n", mode)
+		mode = "candles"
+	}
+	style := getString(cfg, "candle_style", "candles")
+	if style != "candles" && style != "ohlcbars" {
+		fmt.Fprintf(os.Stderr, "  warning: candlestick candle_style '%s' is not candles or ohlcbars, using candles\n", style)
+		style = "candles"
+	}
+
+	fields := map[string]interface{}{}
+	for _, f := range []string{"open", "high", "low", "close", "volume"} {
+		if name := getString(cfg, f+"_field", ""); name != "" {
+			fields[f] = name
+		}
+	}
+
+	return map[string]interface{}{
+		"datasource":  pf.ds(cfg),
+		"description": getString(cfg, "description", ""),
+		"fieldConfig": map[string]interface{}{
+			"defaults": map[string]interface{}{
+				"color": map[string]interface{}{"mode": "palette-classic"},
+				"custom": map[string]interface{}{
+					"axisPlacement": "auto",
+					"fillOpacity":   getInt(cfg, "fill_opacity", 80),
+					"hideFrom":      map[string]interface{}{"legend": false, "tooltip": false, "viz": false},
+					"lineWidth":     getInt(cfg, "line_width", 1),
+					"showPoints":    "never",
+					"spanNulls":     false,
+				},
+				"mappings":   pf.valueMappings(cfg),
+				"thresholds": map[string]interface{}{"mode": "absolute", "steps": pf.thresholds(cfg, "")},
+				"unit":       getString(cfg, "unit", "short"),
+				"links":      pf.dataLinks(cfg),
+			},
+			"overrides": pf.overrides(cfg),
+		},
+		"gridPos": map[string]interface{}{"h": h, "w": w, "x": x, "y": y},
+		"id":      pf.IDGen.Next(),
+		"options": map[string]interface{}{
+			"candleStyle":   style,
+			"colorStrategy": getString(cfg, "color_strategy", "open-close"),
+			"colors": map[string]interface{}{
+				"up":   pf.Config.ResolveColor(getString(cfg, "up_color", "green")),
+				"down": pf.Config.ResolveColor(getString(cfg, "down_color", "red")),
+			},
+			"fields":           fields,
+			"includeAllFields": getBool(cfg, "include_all_fields", false),
+			"legend": map[string]interface{}{
+				"calcs":       legendCalcs(cfg),
+				"displayMode": getString(cfg, "legend_mode", "list"),
+				"placement":   getString(cfg, "legend_placement", "bottom"),
+				"showLegend":  getBool(cfg, "show_legend", true),
+			},
+			"mode":    mode,
+			"tooltip": map[string]interface{}{"mode": "multi", "sort": "none"},
+		},
+		"pluginVersion": "11.2.0",
+		"targets":       pf.buildTargets(cfg, nil),
+		"title":         getString(cfg, "title", ""),
+		"transparent":   getBool(cfg, "transparent", true),
+		"type":          "candlestick",
+	}
+}
+
+// XYChart creates a scatter panel plotting y_fields against x_field, e.g.
+// cpu vs latency. With both set each y field becomes a manually mapped
+// series; otherwise Grafana maps series automatically (x_field, if set,
+// still picks the x dimension).
+func (pf *PanelFactory) XYChart(cfg map[string]interface{}, x, y int) map[string]interface{} {
+	dw, dh := DefaultSizes["xychart"][0], DefaultSizes["xychart"][1]
+	w := getInt(cfg, "width", dw)
+	h := getInt(cfg, "height", dh)
+
+	xField := getString(cfg, "x_field", "")
+	yFields := getStringSliceAsStrings(cfg, "y_fields")
+	pointSize := getInt(cfg, "point_size", 5)
+	show := "points"
+	if getBool(cfg, "show_line", false) {
+		show = "points+lines"
+	}
+
+	mapping := "auto"
+	series := []interface{}{}
+	if xField != "" && len(yFields) > 0 {
+		mapping = "manual"
+		for _, yf := range yFields {
+			series = append(series, map[string]interface{}{
+				"name":       yf,
+				"pointColor": map[string]interface{}{},
+				"pointSize":  map[string]interface{}{"fixed": pointSize, "max": 20, "min": 1},
+				"x":          xField,
+				"y":          yf,
+			})
+		}
+	}
+	dims := map[string]interface{}{"frame": 0}
+	if xField != "" {
+		dims["x"] = xField
+	}
+
+	return map[string]interface{}{
+		"datasource":  pf.ds(cfg),
+		"description": getString(cfg, "description", ""),
+		"fieldConfig": map[string]interface{}{
+			"defaults": map[string]interface{}{
+				"color": map[string]interface{}{"mode": "palette-classic"},
+				"custom": map[string]interface{}{
+					"axisPlacement":     "auto",
+					"hideFrom":          map[string]interface{}{"legend": false, "tooltip": false, "viz": false},
+					"lineWidth":         getInt(cfg, "line_width", 1),
+					"pointSize":         map[string]interface{}{"fixed": pointSize},
+					"scaleDistribution": map[string]interface{}{"type": "linear"},
+					"show":              show,
+				},
+				"mappings":   pf.valueMappings(cfg),
+				"thresholds": map[string]interface{}{"mode": "absolute", "steps": pf.thresholds(cfg, "")},
+				"unit":       getString(cfg, "unit", "short"),
+				"links":      pf.dataLinks(cfg),
+			},
+			"overrides": pf.overrides(cfg),
+		},
+		"gridPos": map[string]interface{}{"h": h, "w": w, "x": x, "y": y},
+		"id":      pf.IDGen.Next(),
+		"options": map[string]interface{}{
+			"dims": dims,
+			"legend": map[string]interface{}{
+				"calcs":       legendCalcs(cfg),
+				"displayMode": getString(cfg, "legend_mode", "list"),
+				"placement":   getString(cfg, "legend_placement", "bottom"),
+				"showLegend":  getBool(cfg, "show_legend", true),
+			},
+			"series":        series,
+			"seriesMapping": mapping,
+			"tooltip":       map[string]interface{}{"mode": "single", "sort": "none"},
+		},
+		"pluginVersion": "11.2.0",
+		"targets":       pf.buildTargets(cfg, nil),
+		"title":         getString(cfg, "title", ""),
+		"transparent":   getBool(cfg, "transparent", true),
+		"type":          "xychart",
+	}
+}
+
+// Trend creates a trend panel: series plotted against a numeric field other
+// than time, e.g. a build number or version. x_field names that field;
+// unset, Grafana uses the first numeric field.
+func (pf *PanelFactory) Trend(cfg map[string]interface{}, x, y int) map[string]interface{} {
+	dw, dh := DefaultSizes["trend"][0], DefaultSizes["trend"][1]
+	w := getInt(cfg, "width", dw)
+	h := getInt(cfg, "height", dh)
+
+	draw := getString(cfg, "draw_style", "line")
+	if draw != "line" && draw != "bars" && draw != "points" {
+		fmt.Fprintf(os.Stderr, "  warning: trend draw_style '%s' is not line, bars or points, using line\n", draw)
+		draw = "line"
+	}
+	options := map[string]interface{}{
+		"legend": legendWidth(cfg, map[string]interface{}{
+			"calcs":       legendCalcs(cfg),
+			"displayMode": getString(cfg, "legend_mode", "list"),
+			"placement":   getString(cfg, "legend_placement", "bottom"),
+			"showLegend":  getBool(cfg, "show_legend", true),
+		}),
+		"tooltip": map[string]interface{}{"mode": "multi", "sort": "desc"},
+	}
+	if xField := getString(cfg, "x_field", ""); xField != "" {
+		options["xField"] = xField
+	}
+
+	return map[string]interface{}{
+		"datasource":  pf.ds(cfg),
+		"description": getString(cfg, "description", ""),
+		"fieldConfig": map[string]interface{}{
+			"defaults": map[string]interface{}{
+				"color": map[string]interface{}{"mode": getString(cfg, "color_mode", "palette-classic-by-name")},
+				"custom": map[string]interface{}{
+					"axisBorderShow":    false,
+					"axisCenteredZero":  false,
+					"axisColorMode":     "text",
+					"axisLabel":         getString(cfg, "axis_label", ""),
+					"axisPlacement":     "auto",
+					"barAlignment":      0,
+					"drawStyle":         draw,
+					"fillOpacity":       getInt(cfg, "fill_opacity", 8),
+					"gradientMode":      "scheme",
+					"hideFrom":          map[string]interface{}{"legend": false, "tooltip": false, "viz": false},
+					"lineInterpolation": getString(cfg, "line_interpolation", "linear"),
+					"lineWidth":         getInt(cfg, "line_width", 1),
+					"pointSize":         5,
+					"scaleDistribution": map[string]interface{}{"type": "linear"},
+					"showPoints":        "auto",
+					"spanNulls":         false,
+					"stacking":          map[string]interface{}{"group": "A", "mode": "none"},
+					"thresholdsStyle":   map[string]interface{}{"mode": "off"},
+				},
+				"mappings":   pf.valueMappings(cfg),
+				"thresholds": map[string]interface{}{"mode": "absolute", "steps": pf.thresholds(cfg, "")},
+				"unit":       getString(cfg, "unit", "short"),
+				"links":      pf.dataLinks(cfg),
+			},
+			"overrides": pf.overrides(cfg),
+		},
+		"gridPos":       map[string]interface{}{"h": h, "w": w, "x": x, "y": y},
+		"id":            pf.IDGen.Next(),
+		"options":       options,
+		"pluginVersion": "11.2.0",
+		"targets":       pf.buildTargets(cfg, nil),
+		"title":         getString(cfg, "title", ""),
+		"transparent":   getBool(cfg, "transparent", true),
+		"type":          "trend",
+	}
+}
+
 // Comparison creates a mixed-datasource comparison panel.
 func (pf *PanelFactory) Comparison(cfg map[string]interface{}, x, y int) (map[string]interface{}, error) {
 	dw, dh := DefaultSizes["comparison"][0], DefaultSizes["comparison"][1]
@@ -762,7 +1831,7 @@ func (pf *PanelFactory) Comparison(cfg map[string]interface{}, x, y int) (map[st
 			"expr":         pf.Config.ResolveRef(expr),
 			"legendFormat": legend,
 			"range":        true,
-			"refId":        string(rune('A' + i)),
+			"refId":        refID(i),
 		})
 	}
 
@@ -784,7 +1853,7 @@ func (pf *PanelFactory) Comparison(cfg map[string]interface{}, x, y int) (map[st
 					"fillOpacity":       8,
 					"gradientMode":      "scheme",
 					"hideFrom":          map[string]interface{}{"legend": false, "tooltip": false, "viz": false},
-					"insertNulls":       false,
+					"insertNulls":       insertNulls(cfg),
 					"lineInterpolation": "smooth",
 					"lineWidth":         1,
 					"pointSize":         5,
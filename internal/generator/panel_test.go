@@ -1,8 +1,10 @@
 package generator
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/wcatz/dashboard-generator/internal/config"
@@ -90,6 +92,411 @@ func TestStatPanel(t *testing.T) {
 	}
 }
 
+func TestGeomapPanel(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	panel, err := pf.FromConfig(map[string]interface{}{
+		"type":         "geomap",
+		"title":        "hosts",
+		"query":        "node_uname_info",
+		"lat_field":    "lat",
+		"lon_field":    "lon",
+		"initial_zoom": 3,
+		"initial_lat":  48.1,
+		"color":        "$blue",
+	}, 0, 0)
+	if err != nil {
+		t.Fatalf("FromConfig error: %v", err)
+	}
+
+	if panel["type"] != "geomap" {
+		t.Errorf("type = %v, want geomap", panel["type"])
+	}
+	gridPos := panel["gridPos"].(map[string]interface{})
+	if gridPos["w"] != 12 || gridPos["h"] != 9 {
+		t.Errorf("size = %vx%v, want 12x9", gridPos["w"], gridPos["h"])
+	}
+
+	options := panel["options"].(map[string]interface{})
+	if options["basemap"].(map[string]interface{})["type"] != "default" {
+		t.Errorf("basemap = %v, want default", options["basemap"])
+	}
+	view := options["view"].(map[string]interface{})
+	if view["zoom"] != 3.0 || view["lat"] != 48.1 || view["lon"] != 0.0 {
+		t.Errorf("view = %v", view)
+	}
+	layers := options["layers"].([]interface{})
+	if len(layers) != 1 {
+		t.Fatalf("layer count = %d, want 1", len(layers))
+	}
+	layer := layers[0].(map[string]interface{})
+	if layer["type"] != "markers" {
+		t.Errorf("layer type = %v, want markers", layer["type"])
+	}
+	loc := layer["location"].(map[string]interface{})
+	if loc["mode"] != "coords" || loc["latitude"] != "lat" || loc["longitude"] != "lon" {
+		t.Errorf("location = %v", loc)
+	}
+	style := layer["config"].(map[string]interface{})["style"].(map[string]interface{})
+	if style["color"].(map[string]interface{})["fixed"] != "#5794F2" {
+		t.Errorf("marker color = %v, want #5794F2", style["color"])
+	}
+
+	targets := panel["targets"].([]interface{})
+	if len(targets) != 1 {
+		t.Fatalf("targets count = %d, want 1", len(targets))
+	}
+	target := targets[0].(map[string]interface{})
+	if target["expr"] != "node_uname_info" || target["format"] != "table" || target["instant"] != true {
+		t.Errorf("target = %v", target)
+	}
+
+	// unknown layer types fall back to markers; geohash wins over coords
+	panel = pf.Geomap(map[string]interface{}{
+		"layer_type":    "hexbin",
+		"geohash_field": "geohash",
+	}, 0, 0)
+	layer = panel["options"].(map[string]interface{})["layers"].([]interface{})[0].(map[string]interface{})
+	if layer["type"] != "markers" {
+		t.Errorf("fallback layer type = %v, want markers", layer["type"])
+	}
+	if loc := layer["location"].(map[string]interface{}); loc["mode"] != "geohash" || loc["geohash"] != "geohash" {
+		t.Errorf("location = %v, want geohash", loc)
+	}
+
+	panel = pf.Geomap(map[string]interface{}{"layer_type": "heatmap"}, 0, 0)
+	layer = panel["options"].(map[string]interface{})["layers"].([]interface{})[0].(map[string]interface{})
+	if layer["type"] != "heatmap" {
+		t.Errorf("layer type = %v, want heatmap", layer["type"])
+	}
+	if layer["location"].(map[string]interface{})["mode"] != "auto" {
+		t.Errorf("location = %v, want auto", layer["location"])
+	}
+}
+
+func TestCandlestickPanel(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	panel, err := pf.FromConfig(map[string]interface{}{
+		"type":        "candlestick",
+		"title":       "request latency",
+		"open_field":  "p50",
+		"high_field":  "p99",
+		"low_field":   "p50",
+		"close_field": "p90",
+		"up_color":    "$blue",
+		"targets": []interface{}{
+			map[string]interface{}{"expr": "histogram_quantile(0.5, rate(http_bucket${host}[${rate_interval}]))", "legend": "p50"},
+			map[string]interface{}{"expr": "histogram_quantile(0.9, rate(http_bucket${host}[${rate_interval}]))", "legend": "p90"},
+			map[string]interface{}{"expr": "histogram_quantile(0.99, rate(http_bucket${host}[${rate_interval}]))", "legend": "p99"},
+		},
+	}, 0, 0)
+	if err != nil {
+		t.Fatalf("FromConfig error: %v", err)
+	}
+
+	if panel["type"] != "candlestick" {
+		t.Errorf("type = %v, want candlestick", panel["type"])
+	}
+	gridPos := panel["gridPos"].(map[string]interface{})
+	if gridPos["w"] != 12 || gridPos["h"] != 8 {
+		t.Errorf("size = %vx%v, want 12x8", gridPos["w"], gridPos["h"])
+	}
+	options := panel["options"].(map[string]interface{})
+	if options["mode"] != "candles" || options["candleStyle"] != "candles" {
+		t.Errorf("mode/candleStyle = %v/%v, want candles/candles", options["mode"], options["candleStyle"])
+	}
+	fields := options["fields"].(map[string]interface{})
+	want := map[string]string{"open": "p50", "high": "p99", "low": "p50", "close": "p90"}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("fields[%s] = %v, want %s", k, fields[k], v)
+		}
+	}
+	if _, ok := fields["volume"]; ok {
+		t.Error("unset volume_field should not be mapped")
+	}
+	if options["colors"].(map[string]interface{})["up"] != "#5794F2" {
+		t.Errorf("colors = %v, want up #5794F2", options["colors"])
+	}
+
+	targets := panel["targets"].([]interface{})
+	if len(targets) != 3 {
+		t.Fatalf("targets count = %d, want 3", len(targets))
+	}
+	target := targets[0].(map[string]interface{})
+	if target["expr"] != `histogram_quantile(0.5, rate(http_bucket{instance=~"$instance"}[5m]))` {
+		t.Errorf("expr = %v, want resolved refs", target["expr"])
+	}
+
+	panel = pf.Candlestick(map[string]interface{}{"mode": "bars", "candle_style": "ohlcbars"}, 0, 0)
+	options = panel["options"].(map[string]interface{})
+	if options["mode"] != "candles" || options["candleStyle"] != "ohlcbars" {
+		t.Errorf("mode/candleStyle = %v/%v, want candles/ohlcbars", options["mode"], options["candleStyle"])
+	}
+}
+
+func TestXYChartPanel(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	panel, err := pf.FromConfig(map[string]interface{}{
+		"type":       "xychart",
+		"title":      "cpu vs latency",
+		"x_field":    "cpu",
+		"y_fields":   []interface{}{"p50", "p99"},
+		"point_size": 8,
+		"show_line":  true,
+		"targets": []interface{}{
+			map[string]interface{}{"expr": "rate(cpu_seconds${host}[${rate_interval}])", "legend": "cpu"},
+			map[string]interface{}{"expr": "histogram_quantile(0.5, rate(http_bucket${host}[${rate_interval}]))", "legend": "p50"},
+			map[string]interface{}{"expr": "histogram_quantile(0.99, rate(http_bucket${host}[${rate_interval}]))", "legend": "p99"},
+		},
+	}, 0, 0)
+	if err != nil {
+		t.Fatalf("FromConfig error: %v", err)
+	}
+
+	if panel["type"] != "xychart" {
+		t.Errorf("type = %v, want xychart", panel["type"])
+	}
+	gridPos := panel["gridPos"].(map[string]interface{})
+	if gridPos["w"] != 12 || gridPos["h"] != 8 {
+		t.Errorf("size = %vx%v, want 12x8", gridPos["w"], gridPos["h"])
+	}
+	options := panel["options"].(map[string]interface{})
+	if options["seriesMapping"] != "manual" {
+		t.Errorf("seriesMapping = %v, want manual", options["seriesMapping"])
+	}
+	if options["dims"].(map[string]interface{})["x"] != "cpu" {
+		t.Errorf("dims = %v, want x cpu", options["dims"])
+	}
+	series := options["series"].([]interface{})
+	if len(series) != 2 {
+		t.Fatalf("series count = %d, want 2", len(series))
+	}
+	for i, want := range []string{"p50", "p99"} {
+		s := series[i].(map[string]interface{})
+		if s["x"] != "cpu" || s["y"] != want {
+			t.Errorf("series[%d] = %v/%v, want cpu/%s", i, s["x"], s["y"], want)
+		}
+		if s["pointSize"].(map[string]interface{})["fixed"] != 8 {
+			t.Errorf("series[%d] pointSize = %v, want 8", i, s["pointSize"])
+		}
+	}
+	custom := panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["custom"].(map[string]interface{})
+	if custom["show"] != "points+lines" {
+		t.Errorf("show = %v, want points+lines", custom["show"])
+	}
+
+	target := panel["targets"].([]interface{})[0].(map[string]interface{})
+	if target["expr"] != `rate(cpu_seconds{instance=~"$instance"}[5m])` {
+		t.Errorf("expr = %v, want resolved refs", target["expr"])
+	}
+
+	panel = pf.XYChart(map[string]interface{}{}, 0, 0)
+	options = panel["options"].(map[string]interface{})
+	if options["seriesMapping"] != "auto" || len(options["series"].([]interface{})) != 0 {
+		t.Errorf("unconfigured mapping = %v %v, want auto with no series", options["seriesMapping"], options["series"])
+	}
+}
+
+func TestTrendPanel(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	panel, err := pf.FromConfig(map[string]interface{}{
+		"type":         "trend",
+		"title":        "latency by build",
+		"x_field":      "build",
+		"draw_style":   "bars",
+		"fill_opacity": 40,
+		"query":        "avg by (build) (rate(http_seconds_sum${host}[${rate_interval}]))",
+	}, 0, 0)
+	if err != nil {
+		t.Fatalf("FromConfig error: %v", err)
+	}
+
+	if panel["type"] != "trend" {
+		t.Errorf("type = %v, want trend", panel["type"])
+	}
+	gridPos := panel["gridPos"].(map[string]interface{})
+	if gridPos["w"] != 12 || gridPos["h"] != 7 {
+		t.Errorf("size = %vx%v, want 12x7", gridPos["w"], gridPos["h"])
+	}
+	if xField := panel["options"].(map[string]interface{})["xField"]; xField != "build" {
+		t.Errorf("xField = %v, want build", xField)
+	}
+	custom := panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["custom"].(map[string]interface{})
+	if custom["drawStyle"] != "bars" || custom["fillOpacity"] != 40 {
+		t.Errorf("drawStyle/fillOpacity = %v/%v, want bars/40", custom["drawStyle"], custom["fillOpacity"])
+	}
+	target := panel["targets"].([]interface{})[0].(map[string]interface{})
+	if target["expr"] != `avg by (build) (rate(http_seconds_sum{instance=~"$instance"}[5m]))` {
+		t.Errorf("expr = %v, want resolved refs", target["expr"])
+	}
+
+	panel = pf.Trend(map[string]interface{}{"draw_style": "area"}, 0, 0)
+	if _, ok := panel["options"].(map[string]interface{})["xField"]; ok {
+		t.Error("xField should be omitted when x_field is unset")
+	}
+	custom = panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["custom"].(map[string]interface{})
+	if custom["drawStyle"] != "line" {
+		t.Errorf("invalid draw_style fell back to %v, want line", custom["drawStyle"])
+	}
+}
+
+func TestAlertListPanel(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	panel, err := pf.FromConfig(map[string]interface{}{
+		"type":             "alertlist",
+		"title":            "noc alerts",
+		"show":             "changes",
+		"max_items":        50,
+		"state_filter":     []interface{}{"firing", "normal"},
+		"dashboard_alerts": true,
+	}, 0, 0)
+	if err != nil {
+		t.Fatalf("FromConfig error: %v", err)
+	}
+
+	if panel["type"] != "alertlist" {
+		t.Errorf("type = %v, want alertlist", panel["type"])
+	}
+	if _, ok := panel["targets"]; ok {
+		t.Error("alertlist should have no targets")
+	}
+	options := panel["options"].(map[string]interface{})
+	if options["maxItems"] != 50 {
+		t.Errorf("maxItems = %v, want 50", options["maxItems"])
+	}
+	if options["showOptions"] != "changes" || options["dashboardAlerts"] != true {
+		t.Errorf("showOptions/dashboardAlerts = %v/%v, want changes/true", options["showOptions"], options["dashboardAlerts"])
+	}
+	filter := options["stateFilter"].(map[string]interface{})
+	want := map[string]bool{"firing": true, "pending": false, "normal": true, "noData": false, "error": false}
+	for k, v := range want {
+		if filter[k] != v {
+			t.Errorf("stateFilter[%s] = %v, want %v", k, filter[k], v)
+		}
+	}
+
+	// defaults: current firing and pending alerts
+	options = pf.AlertList(map[string]interface{}{}, 0, 0)["options"].(map[string]interface{})
+	filter = options["stateFilter"].(map[string]interface{})
+	if filter["firing"] != true || filter["pending"] != true || filter["normal"] != false || options["maxItems"] != 20 {
+		t.Errorf("default options = %v", options)
+	}
+}
+
+func TestDashlistPanel(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	panel, err := pf.FromConfig(map[string]interface{}{
+		"type":         "dashlist",
+		"title":        "all dashboards",
+		"tags":         []interface{}{"infra", "cardano"},
+		"max_items":    30,
+		"show_starred": true,
+		"show_recent":  true,
+		"show_search":  false,
+		"folder_uid":   "fld-infra",
+	}, 0, 0)
+	if err != nil {
+		t.Fatalf("FromConfig error: %v", err)
+	}
+
+	if panel["type"] != "dashlist" {
+		t.Errorf("type = %v, want dashlist", panel["type"])
+	}
+	if _, ok := panel["targets"]; ok {
+		t.Error("dashlist should have no targets")
+	}
+	options := panel["options"].(map[string]interface{})
+	tags := options["tags"].([]interface{})
+	if len(tags) != 2 || tags[0] != "infra" || tags[1] != "cardano" {
+		t.Errorf("tags = %v, want [infra cardano]", tags)
+	}
+	want := map[string]interface{}{
+		"maxItems":           30,
+		"showStarred":        true,
+		"showRecentlyViewed": true,
+		"showSearch":         false,
+		"folderUID":          "fld-infra",
+	}
+	for k, v := range want {
+		if options[k] != v {
+			t.Errorf("options[%s] = %v, want %v", k, options[k], v)
+		}
+	}
+
+	options = pf.Dashlist(map[string]interface{}{}, 0, 0)["options"].(map[string]interface{})
+	if options["showSearch"] != true || options["showStarred"] != false || len(options["tags"].([]interface{})) != 0 {
+		t.Errorf("default options = %v, want search only and no tags", options)
+	}
+}
+
+func TestPanelDefaults(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary:
+    type: prometheus
+    uid: prometheus
+    is_default: true
+panel_defaults:
+  unit: bytes
+  transparent: false
+  timeseries:
+    fill_opacity: 30
+    unit: percent
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	stat, err := pf.FromConfig(map[string]interface{}{"type": "stat", "title": "mem", "query": "up"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unit := stat["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["unit"]; unit != "bytes" {
+		t.Errorf("stat unit = %v, want global default bytes", unit)
+	}
+	if stat["transparent"] != false {
+		t.Errorf("stat transparent = %v, want false", stat["transparent"])
+	}
+
+	ts, err := pf.FromConfig(map[string]interface{}{"type": "timeseries", "title": "cpu", "query": "up", "transparent": true}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := ts["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})
+	if defaults["unit"] != "percent" {
+		t.Errorf("timeseries unit = %v, want per-type default percent", defaults["unit"])
+	}
+	if fill := defaults["custom"].(map[string]interface{})["fillOpacity"]; fill != 30 {
+		t.Errorf("timeseries fillOpacity = %v, want 30", fill)
+	}
+	if ts["transparent"] != true {
+		t.Errorf("panel key should win over defaults, transparent = %v", ts["transparent"])
+	}
+
+	// per-type defaults stay on their type
+	gauge, err := pf.FromConfig(map[string]interface{}{"type": "bargauge", "title": "disk", "query": "up", "fill_opacity": 5}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unit := gauge["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["unit"]; unit != "bytes" {
+		t.Errorf("bargauge unit = %v, want global default bytes", unit)
+	}
+}
+
 func TestTimeseriesPanel(t *testing.T) {
 	cfg := loadTestConfig(t)
 	idGen := NewIDGenerator()
@@ -329,3 +736,920 @@ func TestDefaultPanelSizes(t *testing.T) {
 		}
 	}
 }
+
+func TestOrientationAuto(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	tall := pf.Bargauge(map[string]interface{}{
+		"title":       "tall",
+		"query":       "up",
+		"orientation": "auto",
+		"width":       2,
+		"height":      10,
+	}, 0, 0)
+	if o := tall["options"].(map[string]interface{})["orientation"]; o != "vertical" {
+		t.Errorf("tall bargauge orientation = %v, want vertical", o)
+	}
+
+	wide := pf.Gauge(map[string]interface{}{
+		"title":       "wide",
+		"query":       "up",
+		"orientation": "auto",
+		"width":       12,
+		"height":      4,
+	}, 0, 0)
+	if o := wide["options"].(map[string]interface{})["orientation"]; o != "horizontal" {
+		t.Errorf("wide gauge orientation = %v, want horizontal", o)
+	}
+
+	fixed := pf.Bargauge(map[string]interface{}{
+		"title":       "fixed",
+		"query":       "up",
+		"orientation": "vertical",
+	}, 0, 0)
+	if o := fixed["options"].(map[string]interface{})["orientation"]; o != "vertical" {
+		t.Errorf("literal orientation = %v, want vertical", o)
+	}
+
+	tallStat := pf.Stat(map[string]interface{}{
+		"title":       "tall stat",
+		"query":       "up",
+		"orientation": "auto",
+		"width":       2,
+		"height":      10,
+	}, 0, 0)
+	if o := tallStat["options"].(map[string]interface{})["orientation"]; o != "vertical" {
+		t.Errorf("tall stat orientation = %v, want vertical", o)
+	}
+
+	// without the key the gauge keeps Grafana's own "auto"
+	unset := pf.Gauge(map[string]interface{}{
+		"title":  "unset",
+		"query":  "up",
+		"width":  12,
+		"height": 4,
+	}, 0, 0)
+	if o := unset["options"].(map[string]interface{})["orientation"]; o != "auto" {
+		t.Errorf("unset gauge orientation = %v, want auto", o)
+	}
+}
+
+func TestStatOrientationAndValues(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	panel := pf.Stat(map[string]interface{}{
+		"title":         "per instance",
+		"query":         "up",
+		"orientation":   "horizontal",
+		"reduce_values": true,
+	}, 0, 0)
+
+	opts := panel["options"].(map[string]interface{})
+	if opts["orientation"] != "horizontal" {
+		t.Errorf("orientation = %v, want horizontal", opts["orientation"])
+	}
+	reduce := opts["reduceOptions"].(map[string]interface{})
+	if reduce["values"] != true {
+		t.Errorf("reduceOptions.values = %v, want true", reduce["values"])
+	}
+
+	def := pf.Stat(map[string]interface{}{"title": "default", "query": "up"}, 0, 0)
+	if o := def["options"].(map[string]interface{})["orientation"]; o != "auto" {
+		t.Errorf("default orientation = %v, want auto", o)
+	}
+}
+
+func TestTextPanelHTML(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	panel := pf.Text(map[string]interface{}{
+		"title":            "banner",
+		"mode":             "html",
+		"content":          "<b>hi</b>",
+		"disable_sanitize": true,
+	}, 0, 0)
+
+	opts := panel["options"].(map[string]interface{})
+	if opts["mode"] != "html" {
+		t.Errorf("mode = %v, want html", opts["mode"])
+	}
+	if opts["disableSanitizeHtml"] != true {
+		t.Errorf("disableSanitizeHtml = %v, want true", opts["disableSanitizeHtml"])
+	}
+
+	md := pf.Text(map[string]interface{}{"title": "notes", "content": "x"}, 0, 0)
+	if _, ok := md["options"].(map[string]interface{})["disableSanitizeHtml"]; ok {
+		t.Error("disableSanitizeHtml should be omitted by default")
+	}
+}
+
+func TestOverridesShorthand(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	verbose := map[string]interface{}{
+		"matcher":    map[string]interface{}{"id": "byName", "options": "raw"},
+		"properties": []interface{}{map[string]interface{}{"id": "unit", "value": "short"}},
+	}
+	panel := pf.Timeseries(map[string]interface{}{
+		"title": "overrides",
+		"query": "up",
+		"overrides": []interface{}{
+			map[string]interface{}{
+				"matcher":    "byName",
+				"value":      "cpu",
+				"properties": map[string]interface{}{"unit": "percent", "color": "$red", "custom.lineWidth": 2},
+			},
+			map[string]interface{}{
+				"matcher":    "byRegexp",
+				"value":      ".*_bytes",
+				"properties": map[string]interface{}{"unit": "bytes"},
+			},
+			verbose,
+			map[string]interface{}{"matcher": "byNmae", "value": "typo"},
+		},
+	}, 0, 0)
+
+	overrides := panel["fieldConfig"].(map[string]interface{})["overrides"].([]interface{})
+	if len(overrides) != 3 {
+		t.Fatalf("overrides = %d, want 3 (unknown matcher skipped)", len(overrides))
+	}
+
+	byName := overrides[0].(map[string]interface{})
+	matcher := byName["matcher"].(map[string]interface{})
+	if matcher["id"] != "byName" || matcher["options"] != "cpu" {
+		t.Errorf("byName matcher = %v, want byName cpu", matcher)
+	}
+	props := byName["properties"].([]interface{})
+	wantIDs := []string{"color", "custom.lineWidth", "unit"}
+	if len(props) != len(wantIDs) {
+		t.Fatalf("byName properties = %v, want %v", props, wantIDs)
+	}
+	for i, id := range wantIDs {
+		if props[i].(map[string]interface{})["id"] != id {
+			t.Errorf("property[%d] id = %v, want %s", i, props[i].(map[string]interface{})["id"], id)
+		}
+	}
+	color := props[0].(map[string]interface{})["value"].(map[string]interface{})
+	if color["mode"] != "fixed" || color["fixedColor"] != "#F2495C" {
+		t.Errorf("color = %v, want fixed #F2495C", color)
+	}
+	if props[1].(map[string]interface{})["value"] != 2 || props[2].(map[string]interface{})["value"] != "percent" {
+		t.Errorf("properties = %v, want lineWidth 2 and unit percent", props)
+	}
+
+	byRegexp := overrides[1].(map[string]interface{})
+	matcher = byRegexp["matcher"].(map[string]interface{})
+	if matcher["id"] != "byRegexp" || matcher["options"] != ".*_bytes" {
+		t.Errorf("byRegexp matcher = %v, want byRegexp .*_bytes", matcher)
+	}
+	prop := byRegexp["properties"].([]interface{})[0].(map[string]interface{})
+	if prop["id"] != "unit" || prop["value"] != "bytes" {
+		t.Errorf("byRegexp property = %v, want unit bytes", prop)
+	}
+
+	// verbose overrides pass through untouched
+	if overrides[2].(map[string]interface{})["matcher"].(map[string]interface{})["options"] != "raw" {
+		t.Errorf("verbose override = %v, want passthrough", overrides[2])
+	}
+}
+
+func TestUnitOverrides(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	panel := pf.Timeseries(map[string]interface{}{
+		"title": "mixed units",
+		"query": "up",
+		"overrides": []interface{}{
+			map[string]interface{}{"matcher": map[string]interface{}{"id": "byName", "options": "x"}},
+		},
+		"unit_overrides": map[string]interface{}{
+			"bytes":  "bytes",
+			"_total": "ops",
+		},
+	}, 0, 0)
+
+	overrides := panel["fieldConfig"].(map[string]interface{})["overrides"].([]interface{})
+	if len(overrides) != 3 {
+		t.Fatalf("overrides = %d, want 3", len(overrides))
+	}
+	want := []struct{ regex, unit string }{
+		{".*_total.*", "ops"},
+		{".*bytes.*", "bytes"},
+	}
+	for i, w := range want {
+		o := overrides[i+1].(map[string]interface{})
+		matcher := o["matcher"].(map[string]interface{})
+		if matcher["id"] != "byRegexp" || matcher["options"] != w.regex {
+			t.Errorf("override[%d] matcher = %v, want byRegexp %s", i+1, matcher, w.regex)
+		}
+		prop := o["properties"].([]interface{})[0].(map[string]interface{})
+		if prop["id"] != "unit" || prop["value"] != w.unit {
+			t.Errorf("override[%d] property = %v, want unit %s", i+1, prop, w.unit)
+		}
+	}
+}
+
+func TestStatePanelMaxDataPoints(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	panel := pf.StateTimeline(map[string]interface{}{
+		"title":           "health",
+		"query":           "up",
+		"max_data_points": 100,
+	}, 0, 0)
+	if panel["maxDataPoints"] != 100 {
+		t.Errorf("maxDataPoints = %v, want 100", panel["maxDataPoints"])
+	}
+
+	reduced := pf.StateTimeline(map[string]interface{}{
+		"title":        "reduced",
+		"query":        "up",
+		"merge_values": false,
+		"reduce":       true,
+	}, 0, 0)
+	if reduced["maxDataPoints"] != stateReduceDataPoints {
+		t.Errorf("reduce maxDataPoints = %v, want %d", reduced["maxDataPoints"], stateReduceDataPoints)
+	}
+	if reduced["options"].(map[string]interface{})["mergeValues"] != false {
+		t.Error("reduce should not override an explicit merge_values: false")
+	}
+	merged := pf.StateTimeline(map[string]interface{}{"title": "merged", "query": "up", "reduce": true}, 0, 0)
+	if merged["options"].(map[string]interface{})["mergeValues"] != true {
+		t.Error("reduce should keep the default mergeValues")
+	}
+
+	plain := pf.StatusHistory(map[string]interface{}{"title": "plain", "query": "up"}, 0, 0)
+	if _, ok := plain["maxDataPoints"]; ok {
+		t.Error("maxDataPoints should be omitted by default")
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	panel := pf.Histogram(map[string]interface{}{
+		"title":         "latency",
+		"query":         "request_duration_seconds",
+		"bucket_size":   10,
+		"bucket_offset": 5,
+		"x_min":         0,
+		"x_max":         200,
+	}, 0, 0)
+	opts := panel["options"].(map[string]interface{})
+	if opts["bucketSize"] != 10 {
+		t.Errorf("bucketSize = %v, want 10", opts["bucketSize"])
+	}
+	if opts["bucketOffset"] != 5 {
+		t.Errorf("bucketOffset = %v, want 5", opts["bucketOffset"])
+	}
+	defaults := panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})
+	if defaults["min"] != 0 || defaults["max"] != 200 {
+		t.Errorf("axis range = %v..%v, want 0..200", defaults["min"], defaults["max"])
+	}
+
+	plain := pf.Histogram(map[string]interface{}{"title": "plain", "query": "up"}, 0, 0)
+	if _, ok := plain["options"].(map[string]interface{})["bucketSize"]; ok {
+		t.Error("bucketSize should be omitted by default")
+	}
+}
+
+func TestAxisOverrides(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	panel := pf.Timeseries(map[string]interface{}{
+		"title": "requests",
+		"query": "rate(http_requests_total[5m])",
+		"axis_overrides": []interface{}{
+			map[string]interface{}{"series_regex": ".*pct.*", "axis": "right", "unit": "percent"},
+		},
+	}, 0, 0)
+	overrides := panel["fieldConfig"].(map[string]interface{})["overrides"].([]interface{})
+	if len(overrides) != 1 {
+		t.Fatalf("overrides = %d, want 1", len(overrides))
+	}
+	o := overrides[0].(map[string]interface{})
+	matcher := o["matcher"].(map[string]interface{})
+	if matcher["id"] != "byRegexp" || matcher["options"] != ".*pct.*" {
+		t.Errorf("matcher = %v, want byRegexp .*pct.*", matcher)
+	}
+	props := o["properties"].([]interface{})
+	if len(props) != 2 {
+		t.Fatalf("properties = %d, want 2", len(props))
+	}
+	placement := props[0].(map[string]interface{})
+	if placement["id"] != "custom.axisPlacement" || placement["value"] != "right" {
+		t.Errorf("placement = %v, want custom.axisPlacement right", placement)
+	}
+	unit := props[1].(map[string]interface{})
+	if unit["id"] != "unit" || unit["value"] != "percent" {
+		t.Errorf("unit = %v, want percent", unit)
+	}
+}
+
+func TestNoValue(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	for _, ptype := range []string{"stat", "gauge", "bargauge", "table"} {
+		panel, err := pf.FromConfig(map[string]interface{}{
+			"type":     ptype,
+			"title":    "disk",
+			"query":    "up",
+			"no_value": "N/A",
+		}, 0, 0)
+		if err != nil {
+			t.Fatalf("FromConfig(%s) error: %v", ptype, err)
+		}
+		defaults := panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})
+		if defaults["noValue"] != "N/A" {
+			t.Errorf("%s noValue = %v, want N/A", ptype, defaults["noValue"])
+		}
+	}
+
+	panel, _ := pf.FromConfig(map[string]interface{}{"type": "table", "title": "t", "query": "up", "no_data_text": "no pods running"}, 0, 0)
+	defaults := panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})
+	if defaults["noValue"] != "no pods running" {
+		t.Errorf("no_data_text noValue = %v, want 'no pods running'", defaults["noValue"])
+	}
+
+	plain, _ := pf.FromConfig(map[string]interface{}{"type": "stat", "title": "s", "query": "up"}, 0, 0)
+	if _, ok := plain["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["noValue"]; ok {
+		t.Error("noValue should be omitted by default")
+	}
+}
+
+func TestInsertNulls(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	tests := []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{"5m", 300000},
+		{"1d", 86400000},
+		{"2w", 1209600000},
+		{"soon", false},
+		{true, true},
+		{60000, 60000},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		pcfg := map[string]interface{}{"title": "sparse", "query": "up"}
+		if tt.value != nil {
+			pcfg["insert_nulls"] = tt.value
+		}
+		panel := pf.Timeseries(pcfg, 0, 0)
+		custom := panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["custom"].(map[string]interface{})
+		if custom["insertNulls"] != tt.want {
+			t.Errorf("insert_nulls %v: insertNulls = %v, want %v", tt.value, custom["insertNulls"], tt.want)
+		}
+	}
+}
+
+func TestHeatmapYSort(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	panel := pf.Heatmap(map[string]interface{}{
+		"title":  "latency",
+		"query":  "sum by (le) (rate(http_request_duration_seconds_bucket[5m]))",
+		"y_sort": "asc",
+	}, 0, 0)
+	opts := panel["options"].(map[string]interface{})
+	if layout := opts["rowsFrame"].(map[string]interface{})["layout"]; layout != "le" {
+		t.Errorf("rowsFrame.layout = %v, want le", layout)
+	}
+	if reverse := opts["yAxis"].(map[string]interface{})["reverse"]; reverse != false {
+		t.Errorf("yAxis.reverse = %v, want false for asc", reverse)
+	}
+	target := panel["targets"].([]interface{})[0].(map[string]interface{})
+	if target["format"] != "heatmap" {
+		t.Errorf("target format = %v, want heatmap", target["format"])
+	}
+
+	desc := pf.Heatmap(map[string]interface{}{"title": "latency", "query": "x", "y_sort": "desc"}, 0, 0)
+	if reverse := desc["options"].(map[string]interface{})["yAxis"].(map[string]interface{})["reverse"]; reverse != true {
+		t.Errorf("yAxis.reverse = %v, want true for desc", reverse)
+	}
+
+	plain := pf.Heatmap(map[string]interface{}{"title": "latency", "query": "x"}, 0, 0)
+	if layout := plain["options"].(map[string]interface{})["rowsFrame"].(map[string]interface{})["layout"]; layout != "auto" {
+		t.Errorf("default rowsFrame.layout = %v, want auto", layout)
+	}
+	if _, ok := plain["targets"].([]interface{})[0].(map[string]interface{})["format"]; ok {
+		t.Error("format should be omitted without y_sort")
+	}
+}
+
+func TestBuildTargetsRefIDs(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	var list []interface{}
+	for i := 0; i < 30; i++ {
+		list = append(list, map[string]interface{}{"expr": fmt.Sprintf("up{shard=\"%d\"}", i)})
+	}
+	targets := pf.buildTargets(map[string]interface{}{"targets": list}, nil)
+	if len(targets) != 30 {
+		t.Fatalf("targets = %d, want 30", len(targets))
+	}
+	seen := map[string]bool{}
+	for i, tgt := range targets {
+		id := tgt.(map[string]interface{})["refId"].(string)
+		want := string(rune('A' + i))
+		if i >= 26 {
+			want = "A" + string(rune('A'+i-26))
+		}
+		if id != want {
+			t.Errorf("target %d refId = %s, want %s", i, id, want)
+		}
+		if seen[id] {
+			t.Errorf("duplicate refId %s", id)
+		}
+		seen[id] = true
+	}
+
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := refID(i); got != want {
+			t.Errorf("refID(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestStatInstantQuery(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	panel, err := pf.FromConfig(map[string]interface{}{
+		"type":    "stat",
+		"title":   "targets up",
+		"query":   "count(up${host})",
+		"instant": true,
+	}, 0, 0)
+	if err != nil {
+		t.Fatalf("FromConfig error: %v", err)
+	}
+	targets := panel["targets"].([]interface{})
+	if len(targets) != 1 {
+		t.Fatalf("targets count = %d, want 1", len(targets))
+	}
+	target := targets[0].(map[string]interface{})
+	if target["instant"] != true || target["range"] != false {
+		t.Errorf("instant/range = %v/%v, want true/false", target["instant"], target["range"])
+	}
+
+	// default stays a range query
+	panel, _ = pf.FromConfig(map[string]interface{}{"type": "stat", "query": "count(up)"}, 0, 0)
+	target = panel["targets"].([]interface{})[0].(map[string]interface{})
+	if target["range"] != true {
+		t.Errorf("range = %v, want true by default", target["range"])
+	}
+	if _, ok := target["instant"]; ok {
+		t.Error("default target should not set instant")
+	}
+
+	// not for time series panels
+	panel, _ = pf.FromConfig(map[string]interface{}{"type": "timeseries", "query": "up", "instant": true}, 0, 0)
+	target = panel["targets"].([]interface{})[0].(map[string]interface{})
+	if target["range"] != true {
+		t.Errorf("timeseries range = %v, want true", target["range"])
+	}
+}
+
+func TestPanelIntervalOnTargets(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	panel, err := pf.FromConfig(map[string]interface{}{
+		"type":            "timeseries",
+		"title":           "overview",
+		"interval":        "5m",
+		"resolution":      "1/2",
+		"max_data_points": 200,
+		"targets": []interface{}{
+			map[string]interface{}{"expr": "up"},
+			map[string]interface{}{"expr": "down", "interval": "${rate_interval}", "max_data_points": 50},
+		},
+	}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := panel["targets"].([]interface{})
+	first := targets[0].(map[string]interface{})
+	if first["interval"] != "5m" {
+		t.Errorf("targets[0].interval = %v, want 5m", first["interval"])
+	}
+	if first["intervalFactor"] != 2 {
+		t.Errorf("targets[0].intervalFactor = %v, want 2", first["intervalFactor"])
+	}
+	if second := targets[1].(map[string]interface{}); second["interval"] != "5m" {
+		// rate_interval constant is 5m in the test config
+		t.Errorf("targets[1].interval = %v, want resolved 5m", second["interval"])
+	}
+	if panel["maxDataPoints"] != 200 {
+		t.Errorf("maxDataPoints = %v, want 200", panel["maxDataPoints"])
+	}
+	if panel["interval"] != "5m" {
+		t.Errorf("panel interval = %v, want 5m", panel["interval"])
+	}
+	// maxDataPoints is a panel-level setting; targets never carry it
+	for i, tgt := range targets {
+		if v, ok := tgt.(map[string]interface{})["maxDataPoints"]; ok {
+			t.Errorf("targets[%d].maxDataPoints = %v, want it omitted", i, v)
+		}
+	}
+
+	plain, err := pf.FromConfig(map[string]interface{}{"type": "timeseries", "title": "s", "query": "up"}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := plain["targets"].([]interface{})[0].(map[string]interface{})
+	for _, key := range []string{"interval", "maxDataPoints"} {
+		if _, ok := target[key]; ok {
+			t.Errorf("target %s should be omitted by default", key)
+		}
+		if _, ok := plain[key]; ok {
+			t.Errorf("panel %s should be omitted by default", key)
+		}
+	}
+}
+
+func TestLegendCalcs(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	panel := pf.Timeseries(map[string]interface{}{
+		"title":        "cpu",
+		"query":        "up",
+		"legend_calcs": []interface{}{"avg", "max", "last", "p95"},
+	}, 0, 0)
+	legend := panel["options"].(map[string]interface{})["legend"].(map[string]interface{})
+	calcs := legend["calcs"].([]interface{})
+	want := []string{"mean", "max", "lastNotNull", "p95"}
+	if len(calcs) != len(want) {
+		t.Fatalf("calcs = %v, want %v", calcs, want)
+	}
+	for i, w := range want {
+		if calcs[i] != w {
+			t.Errorf("calcs[%d] = %v, want %s", i, calcs[i], w)
+		}
+	}
+
+	if _, ok := normalizeCalc("avgg"); ok {
+		t.Error("normalizeCalc(avgg) should report an unknown calc")
+	}
+	if id, ok := normalizeCalc("stdDev"); !ok || id != "stdDev" {
+		t.Errorf("normalizeCalc(stdDev) = %s, %v", id, ok)
+	}
+}
+
+func TestCompareTo(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	panel, err := pf.FromConfig(map[string]interface{}{
+		"type":       "stat",
+		"title":      "requests",
+		"query":      "sum(rate(http_requests_total[5m]))",
+		"compare_to": "now-7d",
+	}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := panel["targets"].([]interface{})
+	if len(targets) != 3 {
+		t.Fatalf("targets = %d, want 3", len(targets))
+	}
+	prev := targets[1].(map[string]interface{})
+	if want := "last_over_time((sum(rate(http_requests_total[5m])))[5m:] offset 7d)"; prev["expr"] != want {
+		t.Errorf("shifted expr = %v, want %s", prev["expr"], want)
+	}
+	if prev["refId"] != "B" || prev["hide"] != true {
+		t.Errorf("shifted target refId = %v, hide = %v; want hidden B", prev["refId"], prev["hide"])
+	}
+	change := targets[2].(map[string]interface{})
+	if change["type"] != "math" || change["expression"] != "($A - $B) / $B * 100" {
+		t.Errorf("change target = %v", change)
+	}
+	overrides := panel["fieldConfig"].(map[string]interface{})["overrides"].([]interface{})
+	if len(overrides) != 1 {
+		t.Errorf("overrides = %d, want 1 (percent unit for C)", len(overrides))
+	}
+
+	for _, bad := range []map[string]interface{}{
+		{"type": "timeseries", "query": "up", "compare_to": "now-7d"},
+		{"type": "stat", "query": "up", "compare_to": "last week"},
+		{"type": "stat", "compare_to": "7d", "targets": []interface{}{
+			map[string]interface{}{"expr": "a"}, map[string]interface{}{"expr": "b"}}},
+	} {
+		if _, err := pf.FromConfig(bad, 0, 0); err == nil {
+			t.Errorf("expected error for %v", bad)
+		}
+	}
+}
+
+func TestReduceCalcsWarning(t *testing.T) {
+	two := []interface{}{"lastNotNull", "max"}
+	if msg := reduceCalcsWarning(map[string]interface{}{"title": "cpu", "calcs": two}, two); !strings.Contains(msg, "2 calcs") {
+		t.Errorf("warning = %q, want one about 2 calcs", msg)
+	}
+	if msg := reduceCalcsWarning(map[string]interface{}{"calcs": two, "reduce_values": true}, two); msg != "" {
+		t.Errorf("reduce_values should silence the warning, got %q", msg)
+	}
+	one := []interface{}{"mean"}
+	if msg := reduceCalcsWarning(map[string]interface{}{"calcs": one}, one); msg != "" {
+		t.Errorf("single calc should not warn, got %q", msg)
+	}
+}
+
+func TestTimeseriesStacking(t *testing.T) {
+	cfg := loadTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+
+	stacking := func(panel map[string]interface{}) map[string]interface{} {
+		custom := panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["custom"].(map[string]interface{})
+		return custom["stacking"].(map[string]interface{})
+	}
+
+	s := stacking(pf.Timeseries(map[string]interface{}{
+		"title": "cpu", "query": "up", "stack": "percent", "stack_group": "cpu",
+	}, 0, 0))
+	if s["mode"] != "percent" || s["group"] != "cpu" {
+		t.Errorf("stacking = %v, want percent/cpu", s)
+	}
+
+	s = stacking(pf.Timeseries(map[string]interface{}{"title": "cpu", "query": "up"}, 0, 0))
+	if s["mode"] != "none" || s["group"] != "A" {
+		t.Errorf("default stacking = %v, want none/A", s)
+	}
+}
+
+func TestPanelTitleResolvesConstants(t *testing.T) {
+	cfg := loadTestConfig(t)
+	if err := cfg.ApplySets([]string{"rate_interval=1m"}); err != nil {
+		t.Fatal(err)
+	}
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	panel, err := pf.FromConfig(map[string]interface{}{
+		"type":  "timeseries",
+		"title": "cpu (${rate_interval} rate)",
+		"query": "rate(cpu[${rate_interval}])",
+	}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if panel["title"] != "cpu (1m rate)" {
+		t.Errorf("title = %v, want cpu (1m rate)", panel["title"])
+	}
+	expr := panel["targets"].([]interface{})[0].(map[string]interface{})["expr"]
+	if expr != "rate(cpu[1m])" {
+		t.Errorf("expr = %v, want rate(cpu[1m])", expr)
+	}
+}
+
+func TestInfluxDBTarget(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+constants:
+  measurement: cpu
+datasources:
+  primary: { type: prometheus, uid: prom, is_default: true }
+  influx: { type: influxdb, uid: influx-uid }
+dashboards: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	panel := pf.Timeseries(map[string]interface{}{
+		"title":      "cpu",
+		"datasource": "influx",
+		"query":      `SELECT mean("usage") FROM "${measurement}" WHERE $timeFilter GROUP BY time($__interval)`,
+	}, 0, 0)
+	target := panel["targets"].([]interface{})[0].(map[string]interface{})
+	if target["query"] != `SELECT mean("usage") FROM "cpu" WHERE $timeFilter GROUP BY time($__interval)` {
+		t.Errorf("query = %v", target["query"])
+	}
+	if target["rawQuery"] != true {
+		t.Errorf("rawQuery = %v, want true", target["rawQuery"])
+	}
+	if _, ok := target["expr"]; ok {
+		t.Error("influxdb target should not carry a PromQL expr")
+	}
+	ds := target["datasource"].(map[string]interface{})
+	if ds["type"] != "influxdb" || ds["uid"] != "influx-uid" {
+		t.Errorf("datasource = %v, want influxdb/influx-uid", ds)
+	}
+}
+
+func TestHideSeries(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	panel := pf.Timeseries(map[string]interface{}{
+		"title":       "latency",
+		"query":       "rpc_duration_seconds",
+		"hide_series": []interface{}{".*_sum", "quantile=.*"},
+	}, 0, 0)
+	overrides := panel["fieldConfig"].(map[string]interface{})["overrides"].([]interface{})
+	if len(overrides) != 2 {
+		t.Fatalf("overrides = %d, want 2", len(overrides))
+	}
+	for i, want := range []string{".*_sum", "quantile=.*"} {
+		o := overrides[i].(map[string]interface{})
+		matcher := o["matcher"].(map[string]interface{})
+		if matcher["id"] != "byRegexp" || matcher["options"] != want {
+			t.Errorf("override %d matcher = %v, want byRegexp %s", i, matcher, want)
+		}
+		prop := o["properties"].([]interface{})[0].(map[string]interface{})
+		hide := prop["value"].(map[string]interface{})
+		if prop["id"] != "custom.hideFrom" || hide["legend"] != true || hide["viz"] != true || hide["tooltip"] != true {
+			t.Errorf("override %d property = %v, want hideFrom legend/viz/tooltip", i, prop)
+		}
+	}
+}
+
+func TestUnitWarnings(t *testing.T) {
+	if w := unitWarnings(map[string]interface{}{"title": "disk", "unit": "decbytes"}); len(w) != 0 {
+		t.Errorf("decbytes warnings = %v, want none", w)
+	}
+	if w := unitWarnings(map[string]interface{}{"unit": "suffix: req"}); len(w) != 0 {
+		t.Errorf("custom unit warnings = %v, want none", w)
+	}
+	w := unitWarnings(map[string]interface{}{"title": "net", "unit": "bites"})
+	if len(w) != 1 || !strings.Contains(w[0], "'bites'") {
+		t.Errorf("bites warnings = %v, want one naming bites", w)
+	}
+	w = unitWarnings(map[string]interface{}{
+		"unit":           "bytes",
+		"unit_overrides": map[string]interface{}{"rate": "byts"},
+	})
+	if len(w) != 1 || !strings.Contains(w[0], "'byts'") {
+		t.Errorf("unit_overrides warnings = %v, want one naming byts", w)
+	}
+
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+	panel, err := pf.FromConfig(map[string]interface{}{
+		"type": "stat", "title": "disk", "query": "up", "unit": "decbytes", "decimals": 2,
+	}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})
+	if defaults["unit"] != "decbytes" || defaults["decimals"] != 2 {
+		t.Errorf("defaults unit/decimals = %v/%v, want decbytes/2", defaults["unit"], defaults["decimals"])
+	}
+}
+
+func TestLegendWidth(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+
+	legend := func(panel map[string]interface{}) map[string]interface{} {
+		return panel["options"].(map[string]interface{})["legend"].(map[string]interface{})
+	}
+	l := legend(pf.Timeseries(map[string]interface{}{
+		"title": "cpu", "query": "up", "legend_placement": "right", "legend_width": 250,
+	}, 0, 0))
+	if l["placement"] != "right" || l["width"] != 250 {
+		t.Errorf("legend = %v, want right with width 250", l)
+	}
+	l = legend(pf.Timeseries(map[string]interface{}{"title": "cpu", "query": "up", "legend_width": 250}, 0, 0))
+	if _, ok := l["width"]; ok {
+		t.Errorf("bottom legend should not get a width, got %v", l)
+	}
+	l = legend(pf.Piechart(map[string]interface{}{"title": "pie", "query": "up", "legend_width": 180}, 0, 0))
+	if l["width"] != 180 {
+		t.Errorf("piechart legend = %v, want width 180", l)
+	}
+}
+
+func TestValueMappingsShorthand(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+	mappingsOf := func(vm interface{}) []interface{} {
+		t.Helper()
+		panel := pf.Stat(map[string]interface{}{"title": "health", "query": "up", "value_mappings": vm}, 0, 0)
+		return panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["mappings"].([]interface{})
+	}
+
+	// map form
+	mappings := mappingsOf(map[string]interface{}{"0": "down", "1": "up"})
+	if len(mappings) != 1 {
+		t.Fatalf("map form mappings = %v, want one value mapping", mappings)
+	}
+	values := mappings[0].(map[string]interface{})
+	opts := values["options"].(map[string]interface{})
+	if values["type"] != "value" || opts["0"].(map[string]interface{})["text"] != "down" || opts["1"].(map[string]interface{})["text"] != "up" {
+		t.Errorf("map form mapping = %v, want 0 -> down, 1 -> up", values)
+	}
+
+	// list form with palette colors, a range and a verbose mapping
+	verbose := map[string]interface{}{"type": "special", "options": map[string]interface{}{"match": "null", "result": map[string]interface{}{"text": "n/a"}}}
+	mappings = mappingsOf([]interface{}{
+		map[string]interface{}{"value": 1, "text": "up", "color": "$green"},
+		map[string]interface{}{"value": 0, "text": "down", "color": "$red"},
+		map[string]interface{}{"from": 0, "to": 50, "text": "low"},
+		verbose,
+	})
+	if len(mappings) != 3 {
+		t.Fatalf("list form mappings = %v, want verbose, value and range", mappings)
+	}
+	if mappings[0].(map[string]interface{})["type"] != "special" {
+		t.Errorf("verbose mapping = %v, want passthrough", mappings[0])
+	}
+	opts = mappings[1].(map[string]interface{})["options"].(map[string]interface{})
+	up := opts["1"].(map[string]interface{})
+	down := opts["0"].(map[string]interface{})
+	if up["text"] != "up" || up["color"] != "#73BF69" || down["text"] != "down" || down["color"] != "#F2495C" {
+		t.Errorf("value mapping = %v, want up green, down red", opts)
+	}
+	rng := mappings[2].(map[string]interface{})
+	ropts := rng["options"].(map[string]interface{})
+	if rng["type"] != "range" || ropts["from"] != 0 || ropts["to"] != 50 || ropts["result"].(map[string]interface{})["text"] != "low" {
+		t.Errorf("range mapping = %v, want 0-50 low", rng)
+	}
+
+	// an empty shorthand entry maps the value without "<nil>" text
+	mappings = mappingsOf(map[string]interface{}{"0": nil, "1": "up"})
+	opts = mappings[0].(map[string]interface{})["options"].(map[string]interface{})
+	if text, ok := opts["0"].(map[string]interface{})["text"]; ok {
+		t.Errorf("empty entry text = %v, want none", text)
+	}
+
+	// indexes continue from value_mappings into states
+	panel := pf.Stat(map[string]interface{}{
+		"title": "health", "query": "up",
+		"value_mappings": map[string]interface{}{"0": "down", "1": "up"},
+		"states":         []interface{}{map[string]interface{}{"value": 2, "text": "degraded"}},
+	}, 0, 0)
+	mappings = panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["mappings"].([]interface{})
+	if len(mappings) != 2 {
+		t.Fatalf("mappings = %v, want value_mappings then states", mappings)
+	}
+	state := mappings[1].(map[string]interface{})["options"].(map[string]interface{})["2"].(map[string]interface{})
+	if state["index"] != 2 {
+		t.Errorf("states index = %v, want 2 after two value_mappings", state["index"])
+	}
+}
+
+func TestStatStates(t *testing.T) {
+	cfg := loadTestConfig(t)
+	pf := NewPanelFactory(cfg, NewIDGenerator())
+	states := []interface{}{
+		map[string]interface{}{"value": 0, "text": "OK", "color": "$green"},
+		map[string]interface{}{"value": 1, "text": "WARN", "color": "orange"},
+		map[string]interface{}{"from": 2, "to": nil, "text": "CRIT", "color": "$red"},
+	}
+
+	panel := pf.Stat(map[string]interface{}{"title": "health", "query": "up", "states": states}, 0, 0)
+	mappings := panel["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["mappings"].([]interface{})
+	if len(mappings) != 2 {
+		t.Fatalf("mappings = %v, want a value mapping and a range mapping", mappings)
+	}
+	values := mappings[0].(map[string]interface{})
+	opts := values["options"].(map[string]interface{})
+	ok := opts["0"].(map[string]interface{})
+	warn := opts["1"].(map[string]interface{})
+	if values["type"] != "value" || ok["text"] != "OK" || ok["color"] != "#73BF69" || warn["color"] != "orange" {
+		t.Errorf("value mapping = %v", values)
+	}
+	rng := mappings[1].(map[string]interface{})
+	result := rng["options"].(map[string]interface{})["result"].(map[string]interface{})
+	if rng["type"] != "range" || result["text"] != "CRIT" || result["color"] != "#F2495C" {
+		t.Errorf("range mapping = %v", rng)
+	}
+	if mode := panel["options"].(map[string]interface{})["colorMode"]; mode != "background" {
+		t.Errorf("colorMode = %v, want background so the mapped color shows", mode)
+	}
+
+	gauge := pf.Gauge(map[string]interface{}{"title": "health", "query": "up", "states": states}, 0, 0)
+	if m := gauge["fieldConfig"].(map[string]interface{})["defaults"].(map[string]interface{})["mappings"].([]interface{}); len(m) != 2 {
+		t.Errorf("gauge mappings = %v, want 2", m)
+	}
+}
@@ -0,0 +1,78 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// DatasourceProvisioningFile is the default file name written by
+// generate --datasource-provisioning.
+const DatasourceProvisioningFile = "datasources.yaml"
+
+type provisionedDatasource struct {
+	Name           string                 `yaml:"name"`
+	Type           string                 `yaml:"type"`
+	UID            string                 `yaml:"uid"`
+	Access         string                 `yaml:"access"`
+	URL            string                 `yaml:"url,omitempty"`
+	IsDefault      bool                   `yaml:"isDefault"`
+	JSONData       map[string]interface{} `yaml:"jsonData,omitempty"`
+	SecureJSONData map[string]string      `yaml:"secureJsonData,omitempty"`
+}
+
+// DatasourceProvisioning renders the config's datasources as a Grafana
+// datasource provisioning file, sorted by name. URLs honor --env; secure
+// values are passed through as written, so Grafana's own $VAR expansion
+// can supply secrets at provisioning time.
+func DatasourceProvisioning(cfg *config.Config) ([]byte, error) {
+	var list []provisionedDatasource
+	for _, name := range sortedKeys(cfg.Datasources) {
+		ds := cfg.Datasources[name]
+		list = append(list, provisionedDatasource{
+			Name:           name,
+			Type:           ds.Type,
+			UID:            ds.UID,
+			Access:         "proxy",
+			URL:            cfg.GetDatasourceURL(name),
+			IsDefault:      ds.IsDefault,
+			JSONData:       ds.JSONData,
+			SecureJSONData: ds.SecureJSONData,
+		})
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err := enc.Encode(map[string]interface{}{
+		"apiVersion":  1,
+		"datasources": list,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling datasource provisioning: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteDatasourceProvisioning writes DatasourceProvisioning output to fpath
+// with mode, reporting the file on out.
+func WriteDatasourceProvisioning(cfg *config.Config, fpath string, dryRun bool, out io.Writer, mode os.FileMode) error {
+	data, err := DatasourceProvisioning(cfg)
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		if err := os.WriteFile(fpath, data, mode); err != nil {
+			return fmt.Errorf("writing %s: %w", fpath, err)
+		}
+		if err := os.Chmod(fpath, mode); err != nil {
+			return fmt.Errorf("setting mode on %s: %w", fpath, err)
+		}
+	}
+	fmt.Fprintf(out, "  %s: %d datasources, %s bytes\n", filepath.Base(fpath), len(cfg.Datasources), formatSize(len(data)))
+	return nil
+}
@@ -0,0 +1,66 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDatasourceProvisioning(t *testing.T) {
+	cfg, err := config.LoadFromBytes([]byte(`
+datasources:
+  primary:
+    type: prometheus
+    uid: prom
+    url: http://prometheus:9090
+    is_default: true
+    json_data: { timeInterval: 30s }
+  logs:
+    type: loki
+    uid: loki
+    url: http://loki:3100
+    secure_json_data: { basicAuthPassword: $LOKI_PASSWORD }
+dashboards: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := DatasourceProvisioning(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var file struct {
+		APIVersion  int `yaml:"apiVersion"`
+		Datasources []struct {
+			Name           string                 `yaml:"name"`
+			Type           string                 `yaml:"type"`
+			UID            string                 `yaml:"uid"`
+			Access         string                 `yaml:"access"`
+			URL            string                 `yaml:"url"`
+			IsDefault      bool                   `yaml:"isDefault"`
+			JSONData       map[string]interface{} `yaml:"jsonData"`
+			SecureJSONData map[string]string      `yaml:"secureJsonData"`
+		} `yaml:"datasources"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		t.Fatalf("output is not YAML: %v\n%s", err, data)
+	}
+	if file.APIVersion != 1 || len(file.Datasources) != 2 {
+		t.Fatalf("file = %+v, want apiVersion 1 with 2 datasources", file)
+	}
+	logs, primary := file.Datasources[0], file.Datasources[1]
+	if logs.Name != "logs" || logs.UID != "loki" || logs.URL != "http://loki:3100" || logs.Access != "proxy" {
+		t.Errorf("logs = %+v", logs)
+	}
+	if logs.SecureJSONData["basicAuthPassword"] != "$LOKI_PASSWORD" {
+		t.Errorf("logs secureJsonData = %v", logs.SecureJSONData)
+	}
+	if primary.Name != "primary" || primary.UID != "prom" || primary.URL != "http://prometheus:9090" || !primary.IsDefault {
+		t.Errorf("primary = %+v", primary)
+	}
+	if primary.JSONData["timeInterval"] != "30s" {
+		t.Errorf("primary jsonData = %v", primary.JSONData)
+	}
+}
@@ -0,0 +1,95 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+)
+
+// DashboardStat is the build cost of one dashboard.
+type DashboardStat struct {
+	Name     string
+	UID      string
+	Duration time.Duration
+	Panels   int
+	Bytes    int
+}
+
+// BuildStats builds each dashboard in order without writing it, timing the
+// build and measuring the marshaled size.
+func (db *DashboardBuilder) BuildStats(dashboards map[string]config.DashboardConfig, order []string, navLinks []interface{}, discoverySections []config.SectionConfig) ([]DashboardStat, error) {
+	var stats []DashboardStat
+	for _, name := range order {
+		dbCfg, ok := dashboards[name]
+		if !ok {
+			continue
+		}
+		start := time.Now()
+		dashboard, err := db.Build(dbCfg, navLinks, discoverySections)
+		if err != nil {
+			return nil, fmt.Errorf("building dashboard '%s': %w", name, err)
+		}
+		elapsed := time.Since(start)
+
+		data, err := json.MarshalIndent(dashboard, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("marshaling dashboard '%s': %w", name, err)
+		}
+		panels, _ := dashboard["panels"].([]interface{})
+		stats = append(stats, DashboardStat{
+			Name:     name,
+			UID:      dbCfg.UID,
+			Duration: elapsed,
+			Panels:   countNestedPanels(panels),
+			Bytes:    len(data) + 1,
+		})
+	}
+	return stats, nil
+}
+
+// countNestedPanels counts panels including those inside collapsed rows.
+func countNestedPanels(panels []interface{}) int {
+	n := 0
+	for _, p := range panels {
+		n++
+		if m, ok := p.(map[string]interface{}); ok {
+			if inner, ok := m["panels"].([]interface{}); ok {
+				n += countNestedPanels(inner)
+			}
+		}
+	}
+	return n
+}
+
+// WriteStats prints a per-dashboard timing table, totals, and the slowest
+// dashboards.
+func WriteStats(w io.Writer, stats []DashboardStat, slowest int) {
+	var total time.Duration
+	totalPanels, totalBytes := 0, 0
+	fmt.Fprintf(w, "  %-30s %10s %7s %10s\n", "dashboard", "build", "panels", "bytes")
+	for _, s := range stats {
+		fmt.Fprintf(w, "  %-30s %10s %7d %10s\n", s.Name, s.Duration.Round(time.Microsecond), s.Panels, formatSize(s.Bytes))
+		total += s.Duration
+		totalPanels += s.Panels
+		totalBytes += s.Bytes
+	}
+	fmt.Fprintf(w, "\n  total: %d dashboards, %d panels, %s bytes in %s\n",
+		len(stats), totalPanels, formatSize(totalBytes), total.Round(time.Microsecond))
+
+	sorted := make([]DashboardStat, len(stats))
+	copy(sorted, stats)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Duration > sorted[j].Duration })
+	if slowest > len(sorted) {
+		slowest = len(sorted)
+	}
+	if slowest > 0 {
+		fmt.Fprintf(w, "\n  slowest:\n")
+		for _, s := range sorted[:slowest] {
+			fmt.Fprintf(w, "    %-28s %s\n", s.Name, s.Duration.Round(time.Microsecond))
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package generator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBuildStats(t *testing.T) {
+	cfg := loadFullTestConfig(t)
+	idGen := NewIDGenerator()
+	pf := NewPanelFactory(cfg, idGen)
+	le := NewLayoutEngine()
+	builder := NewDashboardBuilder(cfg, pf, le)
+
+	dbs, _ := cfg.GetDashboards("")
+	order, _ := cfg.GetDashboardOrder("")
+
+	stats, err := builder.BuildStats(dbs, order, nil, nil)
+	if err != nil {
+		t.Fatalf("BuildStats error: %v", err)
+	}
+	if len(stats) != len(dbs) {
+		t.Fatalf("stats = %d, want %d", len(stats), len(dbs))
+	}
+	for _, s := range stats {
+		if s.Duration <= 0 {
+			t.Errorf("%s: duration = %v, want > 0", s.Name, s.Duration)
+		}
+		if s.Bytes == 0 {
+			t.Errorf("%s: bytes = 0", s.Name)
+		}
+	}
+
+	var buf bytes.Buffer
+	WriteStats(&buf, stats, 3)
+	for _, s := range stats {
+		if !strings.Contains(buf.String(), s.Name) {
+			t.Errorf("report missing dashboard %s", s.Name)
+		}
+	}
+	if !strings.Contains(buf.String(), "slowest:") {
+		t.Error("report missing slowest section")
+	}
+}
@@ -0,0 +1,122 @@
+package generator
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+)
+
+//go:embed units.txt
+var unitsList string
+
+// knownUnits holds Grafana's built-in unit ids, one per line in units.txt.
+var knownUnits = func() map[string]bool {
+	units := map[string]bool{}
+	for _, line := range strings.Split(unitsList, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			units[line] = true
+		}
+	}
+	return units
+}()
+
+// customUnitPrefixes are Grafana's custom unit forms, e.g. "suffix: req".
+var customUnitPrefixes = []string{"suffix:", "prefix:", "si:", "count:", "currency:", "time:"}
+
+// isKnownUnit reports whether Grafana recognizes unit.
+func isKnownUnit(unit string) bool {
+	if knownUnits[unit] {
+		return true
+	}
+	for _, p := range customUnitPrefixes {
+		if strings.HasPrefix(unit, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownUnits maps the config path of each unit string in a panel config
+// (unit, y_unit, unit_overrides and axis_overrides) that Grafana doesn't
+// know to the unit; Grafana would render it as a plain suffix rather than
+// scaling values.
+func unknownUnits(cfg map[string]interface{}) map[string]string {
+	units := map[string]string{"unit": getString(cfg, "unit", ""), "y_unit": getString(cfg, "y_unit", "")}
+	if m, ok := cfg["unit_overrides"].(map[string]interface{}); ok {
+		for substr, v := range m {
+			u, _ := v.(string)
+			units["unit_overrides."+substr] = u
+		}
+	}
+	if list, ok := cfg["axis_overrides"].([]interface{}); ok {
+		for i, item := range list {
+			if m, ok := item.(map[string]interface{}); ok {
+				units[fmt.Sprintf("axis_overrides[%d].unit", i)] = getString(m, "unit", "")
+			}
+		}
+	}
+	for path, u := range units {
+		if u == "" || isKnownUnit(u) {
+			delete(units, path)
+		}
+	}
+	return units
+}
+
+// unitWarnings lists the unknown units in a panel config, naming the panel.
+func unitWarnings(cfg map[string]interface{}) []string {
+	units := unknownUnits(cfg)
+	var warnings []string
+	for _, path := range sortedKeys(units) {
+		warnings = append(warnings, fmt.Sprintf("unknown unit '%s' in panel '%s'", units[path], getString(cfg, "title", "")))
+	}
+	return warnings
+}
+
+// UnitProblems reports unknown units in every dashboard's panels as
+// warnings for validate, which can't see the generator's unit list.
+func UnitProblems(dashboards map[string]config.DashboardConfig, order []string) []config.Problem {
+	var problems []config.Problem
+	for _, name := range order {
+		dbCfg, ok := dashboards[name]
+		if !ok {
+			continue
+		}
+		for si, section := range dbCfg.Sections {
+			for pi, panel := range section.Panels {
+				units := unknownUnits(panel)
+				for _, path := range sortedKeys(units) {
+					problems = append(problems, config.Problem{
+						Dashboard: name,
+						Path:      fmt.Sprintf("dashboards.%s.sections[%d].panels[%d].%s", name, si, pi, path),
+						Message:   fmt.Sprintf("unknown unit '%s'", units[path]),
+						Severity:  config.SeverityWarning,
+					})
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// applyFormatting sets decimals on the panel's field defaults and warns on
+// unknown units.
+func applyFormatting(panel, cfg map[string]interface{}) {
+	for _, msg := range unitWarnings(cfg) {
+		fmt.Fprintf(os.Stderr, "  warning: %s\n", msg)
+	}
+	if !hasKey(cfg, "decimals") || getString(cfg, "type", "") == "heatmap" {
+		return
+	}
+	fc, ok := panel["fieldConfig"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if defaults, ok := fc["defaults"].(map[string]interface{}); ok {
+		defaults["decimals"] = getInt(cfg, "decimals", 0)
+	}
+}
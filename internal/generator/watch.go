@@ -0,0 +1,158 @@
+package generator
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Watcher reruns a build whenever one of a set of files changes. Files are
+// polled (modification time and size), which works the same on every
+// platform and for editors that save by renaming over the original.
+type Watcher struct {
+	// Files returns the paths to watch. It is called again after every run
+	// so newly added includes are picked up.
+	Files func() []string
+	// Run rebuilds. An error is printed to Err and watching continues.
+	Run func() error
+	Err io.Writer
+	// Interval is how often files are polled; Debounce is how long they
+	// must stay unchanged after a change before Run is called, so rapid
+	// saves trigger a single rebuild.
+	Interval time.Duration
+	Debounce time.Duration
+}
+
+// NewWatcher creates a Watcher polling every 100ms with a 200ms debounce.
+func NewWatcher(files func() []string, run func() error) *Watcher {
+	return &Watcher{
+		Files:    files,
+		Run:      run,
+		Err:      os.Stderr,
+		Interval: 100 * time.Millisecond,
+		Debounce: 200 * time.Millisecond,
+	}
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func stampFiles(paths []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(paths))
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil {
+			stamps[p] = fileStamp{modTime: info.ModTime(), size: info.Size(), exists: true}
+		} else {
+			stamps[p] = fileStamp{}
+		}
+	}
+	return stamps
+}
+
+func stampsEqual(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, s := range a {
+		o, ok := b[p]
+		if !ok || o.exists != s.exists || o.size != s.size || !o.modTime.Equal(s.modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// Watch runs once, then again after each settled change, until stop is
+// closed.
+func (w *Watcher) Watch(stop <-chan struct{}) {
+	w.run()
+	last := stampFiles(w.Files())
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	var changedAt time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		cur := stampFiles(w.Files())
+		if !stampsEqual(cur, last) {
+			last = cur
+			changedAt = time.Now()
+			continue
+		}
+		if !changedAt.IsZero() && time.Since(changedAt) >= w.Debounce {
+			changedAt = time.Time{}
+			w.run()
+			last = stampFiles(w.Files())
+		}
+	}
+}
+
+func (w *Watcher) run() {
+	if err := w.Run(); err != nil {
+		fmt.Fprintf(w.Err, "error: %v\n", err)
+	}
+}
+
+// FileSizes returns the size of each regular file directly in dir, keyed by
+// file name. Dotfiles (such as the manifest) are skipped; a missing dir
+// yields an empty map.
+func FileSizes(dir string) map[string]int64 {
+	sizes := make(map[string]int64)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return sizes
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			sizes[e.Name()] = info.Size()
+		}
+	}
+	return sizes
+}
+
+// WriteSizeChanges prints one line per file whose size differs between two
+// FileSizes snapshots, including added and removed files, and returns how
+// many were printed.
+func WriteSizeChanges(w io.Writer, before, after map[string]int64) int {
+	names := make([]string, 0, len(after))
+	for name := range after {
+		names = append(names, name)
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	n := 0
+	for _, name := range names {
+		old, had := before[name]
+		cur, has := after[name]
+		switch {
+		case !had:
+			fmt.Fprintf(w, "  + %s: %d bytes\n", name, cur)
+		case !has:
+			fmt.Fprintf(w, "  - %s: removed\n", name)
+		case old != cur:
+			fmt.Fprintf(w, "  ~ %s: %d -> %d bytes (%+d)\n", name, old, cur, cur-old)
+		default:
+			continue
+		}
+		n++
+	}
+	return n
+}
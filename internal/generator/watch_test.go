@@ -0,0 +1,98 @@
+package generator
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+)
+
+func TestWatcherRegenerates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dashboards.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("dashboards:\n  one: { uid: one, title: one }\n")
+
+	ran := make(chan int, 10)
+	var errOut bytes.Buffer
+	w := NewWatcher(func() []string { return config.ConfigFiles(path) }, func() error {
+		cfg, err := config.Load(path, nil)
+		if err != nil {
+			ran <- -1
+			return err
+		}
+		ran <- len(cfg.Dashboards)
+		return nil
+	})
+	w.Err = &errOut
+	w.Interval = 10 * time.Millisecond
+	w.Debounce = 50 * time.Millisecond
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		w.Watch(stop)
+		close(done)
+	}()
+
+	next := func() int {
+		t.Helper()
+		select {
+		case n := <-ran:
+			return n
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for regeneration")
+			return 0
+		}
+	}
+
+	if n := next(); n != 1 {
+		t.Fatalf("initial run saw %d dashboards, want 1", n)
+	}
+	// two quick saves inside the debounce window regenerate once
+	write("dashboards:\n  one: { uid: one, title: one }\n  two: { uid: two, title: two }\n")
+	write("dashboards:\n  one: { uid: one, title: one }\n  two: { uid: two, title: two }\n  three: { uid: three, title: three }\n")
+	if n := next(); n != 3 {
+		t.Fatalf("second run saw %d dashboards, want 3", n)
+	}
+	select {
+	case n := <-ran:
+		t.Fatalf("unexpected extra run (%d dashboards); rapid saves should be debounced", n)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	// a parse error is reported and watching continues
+	write("dashboards: [unclosed\n")
+	if n := next(); n != -1 {
+		t.Fatalf("broken config run = %d, want an error", n)
+	}
+	write("dashboards:\n  one: { uid: one, title: one }\n")
+	if n := next(); n != 1 {
+		t.Fatalf("run after fix saw %d dashboards, want 1", n)
+	}
+	close(stop)
+	<-done
+	if !strings.Contains(errOut.String(), "error: parsing") {
+		t.Errorf("error output = %q, want the parse error", errOut.String())
+	}
+}
+
+func TestWriteSizeChanges(t *testing.T) {
+	var buf bytes.Buffer
+	n := WriteSizeChanges(&buf,
+		map[string]int64{"a.json": 100, "b.json": 50, "gone.json": 10},
+		map[string]int64{"a.json": 120, "b.json": 50, "new.json": 7},
+	)
+	want := "  ~ a.json: 100 -> 120 bytes (+20)\n  - gone.json: removed\n  + new.json: 7 bytes\n"
+	if n != 3 || buf.String() != want {
+		t.Errorf("got %d lines:\n%s\nwant:\n%s", n, buf.String(), want)
+	}
+}
@@ -7,13 +7,27 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
+// ManifestFile records which files in an output directory were written by
+// the generator, so --clean only ever removes our own output.
+const ManifestFile = ".dashboard-generator-manifest.json"
+
 // WriteDashboard writes a dashboard to JSON file, returning the size.
 func WriteDashboard(dashboard map[string]interface{}, fpath string, dryRun bool) (int, error) {
+	return WriteDashboardTo(dashboard, fpath, dryRun, os.Stdout, 0644)
+}
+
+// WriteDashboardTo is WriteDashboard with the per-file report line sent to
+// out instead of stdout, and the file set to mode regardless of umask or an
+// existing file's permissions. Size warnings still go to stderr.
+func WriteDashboardTo(dashboard map[string]interface{}, fpath string, dryRun bool, out io.Writer, mode os.FileMode) (int, error) {
 	data, err := json.MarshalIndent(dashboard, "", "  ")
 	if err != nil {
 		return 0, fmt.Errorf("marshaling dashboard: %w", err)
@@ -23,20 +37,135 @@ func WriteDashboard(dashboard map[string]interface{}, fpath string, dryRun bool)
 	panelCount := countPanels(dashboard)
 	filename := filepath.Base(fpath)
 
-	if size > 750_000 {
-		fmt.Fprintf(os.Stderr, "  WARNING: %s is %s bytes (>750KB ConfigMap limit)\n", filename, formatSize(size))
-	}
+	warnConfigMapSize(filename, size)
 
 	if !dryRun {
-		if err := os.WriteFile(fpath, data, 0644); err != nil {
+		if err := os.WriteFile(fpath, data, mode); err != nil {
 			return 0, fmt.Errorf("writing %s: %w", fpath, err)
 		}
+		if err := os.Chmod(fpath, mode); err != nil {
+			return 0, fmt.Errorf("setting mode on %s: %w", fpath, err)
+		}
 	}
 
-	fmt.Printf("  %s: %d panels, %s bytes\n", filename, panelCount, formatSize(size))
+	fmt.Fprintf(out, "  %s: %d panels, %s bytes\n", filename, panelCount, formatSize(size))
 	return size, nil
 }
 
+// warnConfigMapSize warns on stderr when a dashboard is too large to ship
+// in a ConfigMap.
+func warnConfigMapSize(filename string, size int) {
+	if size > 750_000 {
+		fmt.Fprintf(os.Stderr, "  WARNING: %s is %s bytes (>750KB ConfigMap limit)\n", filename, formatSize(size))
+	}
+}
+
+// FileSummary is the per-file entry of a GenerateSummary.
+type FileSummary struct {
+	File   string `json:"file"`
+	UID    string `json:"uid"`
+	Panels int    `json:"panels"`
+	Bytes  int    `json:"bytes"`
+}
+
+// GenerateSummary holds the totals of a generate run, for --json-summary.
+type GenerateSummary struct {
+	Dashboards int           `json:"dashboards"`
+	Panels     int           `json:"panels"`
+	Bytes      int           `json:"bytes"`
+	Files      []FileSummary `json:"files"`
+}
+
+// Add records one written dashboard.
+func (s *GenerateSummary) Add(file string, dashboard map[string]interface{}, size int) {
+	uid, _ := dashboard["uid"].(string)
+	panels := countPanels(dashboard)
+	s.Dashboards++
+	s.Panels += panels
+	s.Bytes += size
+	s.Files = append(s.Files, FileSummary{File: file, UID: uid, Panels: panels, Bytes: size})
+}
+
+// WriteJSON writes the summary as an indented JSON object.
+func (s *GenerateSummary) WriteJSON(w io.Writer) error {
+	if s.Files == nil {
+		s.Files = []FileSummary{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// ReadManifest returns the generated filenames recorded in dir. A missing
+// manifest yields an empty list.
+func ReadManifest(dir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", ManifestFile, err)
+	}
+	return files, nil
+}
+
+func writeManifest(dir string, files map[string]bool) error {
+	data, err := json.MarshalIndent(sortedKeys(files), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestFile), append(data, '\n'), 0644)
+}
+
+// UpdateManifest adds the files written this run to dir's manifest.
+func UpdateManifest(dir string, written []string) error {
+	prev, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	files := make(map[string]bool)
+	for _, f := range prev {
+		files[f] = true
+	}
+	for _, f := range written {
+		files[f] = true
+	}
+	return writeManifest(dir, files)
+}
+
+// CleanStale deletes manifest-listed dashboard files in dir that are not in
+// keep, returning the removed names. Files the manifest does not list are
+// never touched.
+func CleanStale(dir string, keep []string) ([]string, error) {
+	prev, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	keepSet := make(map[string]bool)
+	for _, f := range keep {
+		keepSet[f] = true
+	}
+	remaining := make(map[string]bool)
+	var removed []string
+	for _, f := range prev {
+		// only plain *.json (or ConfigMap *.yaml) names inside dir
+		if keepSet[f] || filepath.Base(f) != f || !(strings.HasSuffix(f, ".json") || strings.HasSuffix(f, ".yaml")) {
+			remaining[f] = true
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, f)); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("removing %s: %w", f, err)
+		}
+		removed = append(removed, f)
+	}
+	sort.Strings(removed)
+	return removed, writeManifest(dir, remaining)
+}
+
 func countPanels(dashboard map[string]interface{}) int {
 	panels, ok := dashboard["panels"].([]interface{})
 	if !ok {
@@ -61,34 +190,30 @@ func formatSize(n int) string {
 	return string(result)
 }
 
-// PushToGrafana pushes a dashboard to the Grafana API.
-func PushToGrafana(dashboard map[string]interface{}, grafanaURL, authUser, authPass, token string) error {
+// PushToGrafana pushes a dashboard to the Grafana API, into the folder with
+// uid folderUID (the General folder when empty).
+func PushToGrafana(dashboard map[string]interface{}, folderUID string, g GrafanaOptions) error {
 	payload := map[string]interface{}{
 		"dashboard": dashboard,
 		"overwrite": true,
 		"message":   "updated by grafana-dashboard-generator",
 	}
+	if folderUID != "" {
+		payload["folderUid"] = folderUID
+	}
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("marshaling payload: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/api/dashboards/db", trimSlash(grafanaURL))
+	url := fmt.Sprintf("%s/api/dashboards/db", trimSlash(g.URL))
 	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
 	if err != nil {
 		return fmt.Errorf("creating request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	if token != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	} else if authUser != "" && authPass != "" {
-		creds := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", authUser, authPass)))
-		req.Header.Set("Authorization", fmt.Sprintf("Basic %s", creds))
-	}
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := g.do(req)
 	if err != nil {
 		return fmt.Errorf("pushing dashboard: %w", err)
 	}
@@ -96,7 +221,7 @@ func PushToGrafana(dashboard map[string]interface{}, grafanaURL, authUser, authP
 
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("grafana returned %d: %s", resp.StatusCode, string(body))
+		return grafanaError(resp.StatusCode, body, g.URL)
 	}
 
 	var result map[string]interface{}
@@ -117,6 +242,189 @@ func PushToGrafana(dashboard map[string]interface{}, grafanaURL, authUser, authP
 	return nil
 }
 
+// FetchFromGrafana retrieves the live dashboard JSON for a uid along with
+// the uid of the folder it is stored in (empty for General). It returns a
+// nil dashboard without error when the dashboard does not exist.
+func FetchFromGrafana(uid string, g GrafanaOptions) (map[string]interface{}, string, error) {
+	url := fmt.Sprintf("%s/api/dashboards/uid/%s", trimSlash(g.URL), uid)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := g.do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("fetching dashboard: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", grafanaError(resp.StatusCode, body, g.URL)
+	}
+
+	var result struct {
+		Dashboard map[string]interface{} `json:"dashboard"`
+		Meta      struct {
+			FolderUID string `json:"folderUid"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, "", fmt.Errorf("parsing dashboard: %w", err)
+	}
+	return result.Dashboard, result.Meta.FolderUID, nil
+}
+
+// Push outcomes reported by PushChanged.
+const (
+	PushCreated   = "created"
+	PushUpdated   = "updated"
+	PushUnchanged = "unchanged"
+)
+
+// PushChanged pushes a dashboard (and its library panels) unless the live
+// copy already matches it and sits in folderUID, ignoring the id and version
+// Grafana manages. A dashboard in another folder is pushed, which moves it. It
+// returns PushCreated, PushUpdated or PushUnchanged. Library panels are
+// compared by reference only, so a dashboard whose library panel models
+// changed but whose references did not is reported unchanged; the elements
+// themselves are updated, and only when their model changed.
+func PushChanged(dashboard map[string]interface{}, folderUID string, g GrafanaOptions) (string, error) {
+	elements, reduced := ExtractLibraryPanels(dashboard)
+	uid, _ := reduced["uid"].(string)
+	live, liveFolder, err := FetchFromGrafana(uid, g)
+	if err != nil {
+		return "", err
+	}
+	for _, elem := range elements {
+		if err := PushLibraryElement(elem, g); err != nil {
+			return "", err
+		}
+	}
+	status := PushCreated
+	if live != nil {
+		d, err := DiffDashboard(reduced, live)
+		if err != nil {
+			return "", err
+		}
+		if d.Status == "unchanged" && liveFolder == folderUID {
+			return PushUnchanged, nil
+		}
+		status = PushUpdated
+	}
+	if err := PushToGrafana(reduced, folderUID, g); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+// BackupDashboard saves the live copy of a dashboard to dir as
+// <uid>-v<version>.json and returns the written path. Dashboards that do
+// not exist in Grafana yet are skipped with an empty path. fileMode and
+// dirMode are the generator's output modes (see Modes).
+func BackupDashboard(uid, dir string, g GrafanaOptions, fileMode, dirMode os.FileMode) (string, error) {
+	live, _, err := FetchFromGrafana(uid, g)
+	if err != nil {
+		return "", err
+	}
+	if live == nil {
+		return "", nil
+	}
+	data, err := json.MarshalIndent(live, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshaling backup: %w", err)
+	}
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return "", err
+	}
+	name := uid + ".json"
+	if v, ok := live["version"].(float64); ok {
+		name = fmt.Sprintf("%s-v%d.json", uid, int(v))
+	}
+	fpath := filepath.Join(dir, name)
+	if err := os.WriteFile(fpath, append(data, '\n'), fileMode); err != nil {
+		return "", fmt.Errorf("writing %s: %w", fpath, err)
+	}
+	return fpath, nil
+}
+
+// RenderPanelURL builds the Grafana image renderer URL for a single panel of
+// a dashboard that already exists in Grafana.
+func RenderPanelURL(grafanaURL, uid string, panelID, width, height int) string {
+	q := url.Values{}
+	q.Set("panelId", fmt.Sprintf("%d", panelID))
+	q.Set("width", fmt.Sprintf("%d", width))
+	q.Set("height", fmt.Sprintf("%d", height))
+	return fmt.Sprintf("%s/render/d-solo/%s?%s", trimSlash(grafanaURL), url.PathEscape(uid), q.Encode())
+}
+
+// RenderPanel fetches a PNG of a single panel from Grafana's render API.
+// Requires the grafana-image-renderer plugin or service on the Grafana side.
+func RenderPanel(g GrafanaOptions, uid string, panelID, width, height int) ([]byte, error) {
+	req, err := http.NewRequest("GET", RenderPanelURL(g.URL, uid, panelID, width, height), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	// rendering is slow; allow a minute while keeping the shared transport
+	if g.Client == nil {
+		g.Client = defaultGrafanaClient
+	}
+	slow := *g.Client
+	slow.Timeout = 60 * time.Second
+	g.Client = &slow
+	resp, err := g.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("rendering panel: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, grafanaError(resp.StatusCode, body, g.URL)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && ct != "image/png" {
+		return nil, fmt.Errorf("grafana returned %s, expected image/png (is the image renderer installed?)", ct)
+	}
+	return body, nil
+}
+
+// grafanaError turns a failed Grafana API response into an error, adding a
+// hint for authentication failures. Grafana Cloud stacks (*.grafana.net)
+// get Cloud-specific advice since the token type is the usual culprit.
+func grafanaError(status int, body []byte, grafanaURL string) error {
+	err := fmt.Errorf("grafana returned %d: %s", status, strings.TrimSpace(string(body)))
+	if status != http.StatusUnauthorized && status != http.StatusForbidden {
+		return err
+	}
+	host := ""
+	if u, perr := url.Parse(grafanaURL); perr == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	switch {
+	case host == "grafana.com" || strings.HasSuffix(host, ".grafana.com"):
+		return fmt.Errorf("%w\n  hint: use your stack URL (https://<stack>.grafana.net), not grafana.com", err)
+	case strings.HasSuffix(host, ".grafana.net"):
+		return fmt.Errorf("%w\n  hint: Grafana Cloud needs a service account token created in the stack "+
+			"(Administration > Users and access > Service accounts) with Editor role, passed via --grafana-token; "+
+			"Cloud access policy tokens and basic auth are rejected", err)
+	default:
+		return fmt.Errorf("%w\n  hint: check --grafana-token (or --grafana-user/--grafana-pass) and that it may write dashboards", err)
+	}
+}
+
+func setGrafanaAuth(req *http.Request, authUser, authPass, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	} else if authUser != "" && authPass != "" {
+		creds := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", authUser, authPass)))
+		req.Header.Set("Authorization", fmt.Sprintf("Basic %s", creds))
+	}
+}
+
 func trimSlash(s string) string {
 	for len(s) > 0 && s[len(s)-1] == '/' {
 		s = s[:len(s)-1]
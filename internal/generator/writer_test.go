@@ -0,0 +1,353 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wcatz/dashboard-generator/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderPanelURL(t *testing.T) {
+	got := RenderPanelURL("http://grafana:3000/", "gen-overview", 4, 800, 400)
+	want := "http://grafana:3000/render/d-solo/gen-overview?height=400&panelId=4&width=800"
+	if got != want {
+		t.Errorf("RenderPanelURL = %s, want %s", got, want)
+	}
+}
+
+func TestCleanStale(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"gen-overview.json", "gen-removed.json", "hand-made.json"} {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte("{}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := UpdateManifest(dir, []string{"gen-overview.json", "gen-removed.json"}); err != nil {
+		t.Fatalf("UpdateManifest error: %v", err)
+	}
+
+	removed, err := CleanStale(dir, []string{"gen-overview.json"})
+	if err != nil {
+		t.Fatalf("CleanStale error: %v", err)
+	}
+	if len(removed) != 1 || removed[0] != "gen-removed.json" {
+		t.Errorf("removed = %v, want [gen-removed.json]", removed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gen-removed.json")); !os.IsNotExist(err) {
+		t.Error("stale generated file should be removed")
+	}
+	for _, f := range []string{"gen-overview.json", "hand-made.json"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf("%s should be kept: %v", f, err)
+		}
+	}
+
+	manifest, _ := ReadManifest(dir)
+	if len(manifest) != 1 || manifest[0] != "gen-overview.json" {
+		t.Errorf("manifest = %v, want [gen-overview.json]", manifest)
+	}
+}
+
+func TestGenerateSummaryJSON(t *testing.T) {
+	var s GenerateSummary
+	s.Add("gen-overview.json", map[string]interface{}{
+		"uid":    "gen-overview",
+		"panels": []interface{}{map[string]interface{}{}, map[string]interface{}{}},
+	}, 1200)
+	s.Add("gen-compute.json", map[string]interface{}{
+		"uid":    "gen-compute",
+		"panels": []interface{}{map[string]interface{}{}},
+	}, 800)
+
+	var buf bytes.Buffer
+	if err := s.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON error: %v", err)
+	}
+	var got GenerateSummary
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("summary is not valid JSON: %v", err)
+	}
+	if got.Dashboards != 2 || got.Panels != 3 || got.Bytes != 2000 {
+		t.Errorf("totals = %d dashboards, %d panels, %d bytes; want 2, 3, 2000", got.Dashboards, got.Panels, got.Bytes)
+	}
+	if len(got.Files) != 2 || got.Files[1].UID != "gen-compute" || got.Files[1].Bytes != 800 {
+		t.Errorf("files = %+v", got.Files)
+	}
+}
+
+func TestPushToGrafanaCloudUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer glc_wrong" {
+			t.Errorf("Authorization = %q, want bearer token", r.Header.Get("Authorization"))
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message":"invalid API key"}`))
+	}))
+	defer srv.Close()
+
+	err := PushToGrafana(map[string]interface{}{"uid": "gen-overview"}, "", GrafanaOptions{URL: srv.URL, Token: "glc_wrong"})
+	if err == nil || !strings.Contains(err.Error(), "invalid API key") {
+		t.Fatalf("err = %v, want 401 with Grafana message", err)
+	}
+
+	cloud := grafanaError(http.StatusUnauthorized, []byte(`{"message":"invalid API key"}`), "https://mystack.grafana.net/")
+	if !strings.Contains(cloud.Error(), "service account token") {
+		t.Errorf("cloud 401 missing Cloud hint: %v", cloud)
+	}
+	if strings.Contains(err.Error(), "service account token") {
+		t.Errorf("non-Cloud 401 should not get the Cloud hint: %v", err)
+	}
+	if e := grafanaError(http.StatusInternalServerError, []byte("boom"), "https://mystack.grafana.net"); strings.Contains(e.Error(), "hint") {
+		t.Errorf("500 should not carry an auth hint: %v", e)
+	}
+}
+
+func TestBackupDashboard(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/dashboards/uid/gen-overview":
+			w.Write([]byte(`{"dashboard":{"uid":"gen-overview","title":"before push","version":3},"meta":{}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	dir := filepath.Join(t.TempDir(), "backup")
+
+	path, err := BackupDashboard("gen-overview", dir, GrafanaOptions{URL: srv.URL, Token: "token"}, 0600, 0700)
+	if err != nil {
+		t.Fatalf("BackupDashboard error: %v", err)
+	}
+	if filepath.Base(path) != "gen-overview-v3.json" {
+		t.Errorf("backup path = %s, want gen-overview-v3.json", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat backup: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("backup mode = %v, want 0600", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading backup: %v", err)
+	}
+	var saved map[string]interface{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("backup is not valid JSON: %v", err)
+	}
+	if saved["title"] != "before push" {
+		t.Errorf("backup title = %v, want 'before push'", saved["title"])
+	}
+
+	// dashboards missing from Grafana are skipped
+	path, err = BackupDashboard("gen-new", dir, GrafanaOptions{URL: srv.URL, Token: "token"}, 0600, 0700)
+	if err != nil || path != "" {
+		t.Errorf("missing dashboard: path=%q err=%v, want skipped", path, err)
+	}
+}
+
+func TestPushChanged(t *testing.T) {
+	live := `{"dashboard":{"id":12,"version":7,"uid":"gen-overview","title":"Overview","panels":[{"id":1,"type":"stat","title":"up"}],"templating":{"list":[]}},"meta":{"folderUid":"ops"}}`
+	liveLib := `{"dashboard":{"uid":"gen-lib","title":"Lib","panels":[{"id":1,"gridPos":{"h":4,"w":6,"x":0,"y":0},"libraryPanel":{"uid":"lib-up","name":"up"}}],"templating":{"list":[]}},"meta":{"folderUid":"ops"}}`
+	element := `{"result":{"uid":"lib-up","name":"up","version":3,"model":{"type":"stat","title":"up","libraryPanel":{"uid":"lib-up","name":"up"}}}}`
+	posts, patches := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/dashboards/uid/gen-overview":
+			w.Write([]byte(live))
+		case r.Method == "GET" && r.URL.Path == "/api/dashboards/uid/gen-lib":
+			w.Write([]byte(liveLib))
+		case r.Method == "GET" && r.URL.Path == "/api/library-elements/lib-up":
+			w.Write([]byte(element))
+		case r.Method == "GET":
+			http.NotFound(w, r)
+		case r.Method == "POST" && r.URL.Path == "/api/dashboards/db":
+			posts++
+			w.Write([]byte(`{"status":"success"}`))
+		case r.Method == "PATCH" && r.URL.Path == "/api/library-elements/lib-up":
+			patches++
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	dashboard := func(uid, title string) map[string]interface{} {
+		return map[string]interface{}{
+			"uid":        uid,
+			"title":      title,
+			"panels":     []interface{}{map[string]interface{}{"id": 1, "type": "stat", "title": "up"}},
+			"templating": map[string]interface{}{"list": []interface{}{}},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		dashboard map[string]interface{}
+		folder    string
+		want      string
+		wantPosts int
+	}{
+		{"identical", dashboard("gen-overview", "Overview"), "ops", PushUnchanged, 0},
+		{"changed", dashboard("gen-overview", "Overview v2"), "ops", PushUpdated, 1},
+		{"moved", dashboard("gen-overview", "Overview"), "infra", PushUpdated, 1},
+		{"moved to general", dashboard("gen-overview", "Overview"), "", PushUpdated, 1},
+		{"missing", dashboard("gen-new", "New"), "ops", PushCreated, 1},
+	}
+	for _, tt := range tests {
+		posts = 0
+		got, err := PushChanged(tt.dashboard, tt.folder, GrafanaOptions{URL: srv.URL, Token: "token"})
+		if err != nil {
+			t.Fatalf("%s: PushChanged error: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: status = %s, want %s", tt.name, got, tt.want)
+		}
+		if posts != tt.wantPosts {
+			t.Errorf("%s: %d POSTs, want %d", tt.name, posts, tt.wantPosts)
+		}
+	}
+
+	// an identical library panel is not PATCHed, which would bump its version
+	withLibrary := func(title string) map[string]interface{} {
+		return map[string]interface{}{
+			"uid":   "gen-lib",
+			"title": "Lib",
+			"panels": []interface{}{map[string]interface{}{
+				"id": 1, "type": "stat", "title": title,
+				"gridPos":      map[string]interface{}{"h": 4, "w": 6, "x": 0, "y": 0},
+				"libraryPanel": map[string]interface{}{"uid": "lib-up", "name": "up"},
+			}},
+			"templating": map[string]interface{}{"list": []interface{}{}},
+		}
+	}
+	for _, tt := range []struct {
+		title       string
+		wantPatches int
+	}{{"up", 0}, {"up v2", 1}} {
+		posts, patches = 0, 0
+		got, err := PushChanged(withLibrary(tt.title), "ops", GrafanaOptions{URL: srv.URL, Token: "token"})
+		if err != nil {
+			t.Fatalf("library %s: PushChanged error: %v", tt.title, err)
+		}
+		if got != PushUnchanged || posts != 0 {
+			t.Errorf("library %s: status = %s with %d POSTs, want unchanged and none", tt.title, got, posts)
+		}
+		if patches != tt.wantPatches {
+			t.Errorf("library %s: %d PATCHes, want %d", tt.title, patches, tt.wantPatches)
+		}
+	}
+}
+
+func TestWriteDashboardFileMode(t *testing.T) {
+	gen := config.GeneratorSettings{FileMode: "0640"}
+	fileMode, dirMode, err := gen.Modes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileMode != 0640 || dirMode != 0755 {
+		t.Errorf("modes = %o, %o; want 640, 755", fileMode, dirMode)
+	}
+
+	fpath := filepath.Join(t.TempDir(), "gen-overview.json")
+	// an existing file keeps its permissions under os.WriteFile alone
+	if err := os.WriteFile(fpath, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dashboard := map[string]interface{}{"uid": "gen-overview", "panels": []interface{}{}}
+	if _, err := WriteDashboardTo(dashboard, fpath, false, io.Discard, fileMode); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("file mode = %o, want 640", info.Mode().Perm())
+	}
+
+	if _, _, err := (config.GeneratorSettings{DirMode: "rwx"}).Modes(); err == nil {
+		t.Error("expected error for non-octal dir_mode")
+	}
+}
+
+func TestWriteConfigMaps(t *testing.T) {
+	entries := []ConfigMapEntry{
+		{Key: "gen-overview.json", Dashboard: map[string]interface{}{
+			"uid":    "gen-overview",
+			"panels": []interface{}{map[string]interface{}{"type": "stat"}},
+		}},
+		{Key: "gen-Compute_Nodes.json", Dashboard: map[string]interface{}{"uid": "gen-compute"}},
+	}
+	fpath := filepath.Join(t.TempDir(), "dashboards.yaml")
+	var out bytes.Buffer
+	sizes, err := WriteConfigMaps(entries, fpath, false, &out, 0644)
+	if err != nil {
+		t.Fatalf("WriteConfigMaps error: %v", err)
+	}
+	if len(sizes) != 2 || sizes[0] == 0 {
+		t.Errorf("sizes = %v, want two non-zero sizes", sizes)
+	}
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type manifest struct {
+		APIVersion string `yaml:"apiVersion"`
+		Kind       string `yaml:"kind"`
+		Metadata   struct {
+			Name   string            `yaml:"name"`
+			Labels map[string]string `yaml:"labels"`
+		} `yaml:"metadata"`
+		Data map[string]string `yaml:"data"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var docs []manifest
+	for {
+		var m manifest
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("manifest is not valid YAML: %v", err)
+		}
+		docs = append(docs, m)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("got %d documents, want 2", len(docs))
+	}
+
+	m := docs[0]
+	if m.APIVersion != "v1" || m.Kind != "ConfigMap" {
+		t.Errorf("apiVersion/kind = %s/%s, want v1/ConfigMap", m.APIVersion, m.Kind)
+	}
+	if m.Metadata.Name != "grafana-dashboard-gen-overview" {
+		t.Errorf("name = %s, want grafana-dashboard-gen-overview", m.Metadata.Name)
+	}
+	if m.Metadata.Labels["grafana_dashboard"] != "1" {
+		t.Errorf("labels = %v, want grafana_dashboard: \"1\"", m.Metadata.Labels)
+	}
+	var dashboard map[string]interface{}
+	if err := json.Unmarshal([]byte(m.Data["gen-overview.json"]), &dashboard); err != nil {
+		t.Fatalf("embedded dashboard is not valid JSON: %v", err)
+	}
+	if dashboard["uid"] != "gen-overview" {
+		t.Errorf("embedded uid = %v, want gen-overview", dashboard["uid"])
+	}
+	if docs[1].Metadata.Name != "grafana-dashboard-gen-compute-nodes" {
+		t.Errorf("name = %s, want grafana-dashboard-gen-compute-nodes", docs[1].Metadata.Name)
+	}
+	if !strings.Contains(out.String(), "gen-overview.json (dashboards.yaml): 1 panels") {
+		t.Errorf("report = %q", out.String())
+	}
+}
@@ -1,12 +1,16 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/wcatz/dashboard-generator/internal/config"
@@ -379,8 +383,10 @@ func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
 	timeFrom := ""
 	timeTo := ""
 	if gen.TimeRange != nil {
-		timeFrom = gen.TimeRange["from"]
-		timeTo = gen.TimeRange["to"]
+		if tr, err := generator.ResolveTimeRange(gen.TimeRange); err == nil {
+			timeFrom = tr["from"]
+			timeTo = tr["to"]
+		}
 	}
 
 	s.renderPage(w, "settings.html", map[string]interface{}{
@@ -443,7 +449,7 @@ func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) {
 
 	idGen := generator.NewIDGenerator()
 	panelFactory := generator.NewPanelFactory(cfg, idGen)
-	layoutEngine := generator.NewLayoutEngine()
+	layoutEngine := generator.NewLayoutEngineWidth(cfg.GetGenerator().GridColumns())
 	builder := generator.NewDashboardBuilder(cfg, panelFactory, layoutEngine)
 	navLinks := builder.BuildNavigationLinks(dashboards, order)
 
@@ -454,6 +460,7 @@ func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) {
 	}
 	var results []pushResult
 	var errors []string
+	folders := generator.NewFolderResolver(s.grafana)
 
 	for _, name := range order {
 		dbCfg, ok := dashboards[name]
@@ -466,7 +473,12 @@ func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		if err := generator.PushToGrafana(dashboard, grafanaURL, "", "", ""); err != nil {
+		folderUID, err := folders.Resolve(cfg.DashboardFolder(dbCfg))
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", dbCfg.Title, err))
+			continue
+		}
+		if err := generator.PushWithLibraryPanels(dashboard, folderUID, s.grafana); err != nil {
 			errors = append(errors, fmt.Sprintf("%s: %v", dbCfg.Title, err))
 			continue
 		}
@@ -504,6 +516,11 @@ func (s *Server) handleGenerate(w http.ResponseWriter, r *http.Request) {
 		absConfig, _ := filepath.Abs(configDir)
 		outDir = filepath.Join(absConfig, outDir)
 	}
+	fileMode, _, err := gen.Modes()
+	if err != nil {
+		s.renderPartial(w, "generate-result.html", map[string]interface{}{"Error": err.Error()})
+		return
+	}
 
 	dashboards, err := cfg.GetDashboards("")
 	if err != nil {
@@ -526,7 +543,7 @@ func (s *Server) handleGenerate(w http.ResponseWriter, r *http.Request) {
 
 	idGen := generator.NewIDGenerator()
 	panelFactory := generator.NewPanelFactory(cfg, idGen)
-	layoutEngine := generator.NewLayoutEngine()
+	layoutEngine := generator.NewLayoutEngineWidth(cfg.GetGenerator().GridColumns())
 	builder := generator.NewDashboardBuilder(cfg, panelFactory, layoutEngine)
 	navLinks := builder.BuildNavigationLinks(dashboards, order)
 
@@ -562,7 +579,7 @@ func (s *Server) handleGenerate(w http.ResponseWriter, r *http.Request) {
 		}
 		fpath := filepath.Join(outDir, filename)
 
-		size, err := generator.WriteDashboard(dashboard, fpath, false)
+		size, err := generator.WriteDashboardTo(dashboard, fpath, false, os.Stdout, fileMode)
 		if err != nil {
 			s.renderPartial(w, "generate-result.html", map[string]interface{}{
 				"Error": fmt.Sprintf("writing %s: %v", filename, err),
@@ -763,6 +780,7 @@ func (s *Server) handleDatasourceTest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cfg := s.Config()
+	// a connection test must hit the datasource, not the shared cache
 	disc := generator.NewMetricDiscovery(cfg)
 	metrics, err := disc.FetchMetrics(name)
 	if err != nil {
@@ -775,6 +793,26 @@ func (s *Server) handleDatasourceTest(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *Server) handleVariableValues(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		s.renderPartial(w, "variable-values.html", map[string]interface{}{"Error": "no variable name"})
+		return
+	}
+
+	values, fetched, err := s.VariableValues(name, r.URL.Query().Get("refresh") != "")
+	if err != nil {
+		s.renderPartial(w, "variable-values.html", map[string]interface{}{"Error": err.Error()})
+		return
+	}
+
+	s.renderPartial(w, "variable-values.html", map[string]interface{}{
+		"Name":    name,
+		"Values":  values,
+		"Fetched": fetched.Format("15:04:05"),
+	})
+}
+
 func (s *Server) handleDatasourceURL(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", 405)
@@ -813,8 +851,7 @@ func (s *Server) handleMetricsBrowse(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cfg := s.Config()
-	disc := generator.NewMetricDiscovery(cfg)
+	disc := s.Discovery()
 
 	metrics, err := disc.FetchMetrics(dsName)
 	if err != nil {
@@ -948,6 +985,40 @@ func (s *Server) handlePreviewAPI(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handlePanelRender proxies Grafana's image renderer for one panel of a
+// dashboard that has already been pushed, returning a PNG.
+func (s *Server) handlePanelRender(w http.ResponseWriter, r *http.Request) {
+	grafanaURL := s.GrafanaURL()
+	if grafanaURL == "" {
+		http.Error(w, "no Grafana URL configured (set --grafana-url or GRAFANA_URL)", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	uid := q.Get("uid")
+	panelID, err := strconv.Atoi(q.Get("id"))
+	if uid == "" || err != nil {
+		http.Error(w, "uid and numeric id are required", http.StatusBadRequest)
+		return
+	}
+	width, err := strconv.Atoi(q.Get("width"))
+	if err != nil || width <= 0 {
+		width = 1000
+	}
+	height, err := strconv.Atoi(q.Get("height"))
+	if err != nil || height <= 0 {
+		height = 500
+	}
+
+	png, err := generator.RenderPanel(s.grafana, uid, panelID, width, height)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
 
 func (s *Server) generatePreview(uid string) (jsonStr string, title string, size int, panels int, panelInfos []PanelInfo, err error) {
 	cfg := s.Config()
@@ -973,7 +1044,7 @@ func (s *Server) generatePreview(uid string) (jsonStr string, title string, size
 
 	idGen := generator.NewIDGenerator()
 	panelFactory := generator.NewPanelFactory(cfg, idGen)
-	layoutEngine := generator.NewLayoutEngine()
+	layoutEngine := generator.NewLayoutEngineWidth(cfg.GetGenerator().GridColumns())
 	builder := generator.NewDashboardBuilder(cfg, panelFactory, layoutEngine)
 	navLinks := builder.BuildNavigationLinks(dashboards, order)
 
@@ -1154,8 +1225,7 @@ func (s *Server) handleMetricsJobs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cfg := s.Config()
-	disc := generator.NewMetricDiscovery(cfg)
+	disc := s.Discovery()
 	jobs, err := disc.FetchLabelValues(dsName, "job")
 	if err != nil {
 		s.renderPartial(w, "job-tabs.html", map[string]interface{}{"Error": err.Error()})
@@ -1185,8 +1255,7 @@ func (s *Server) handleMetricsCompare(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cfg := s.Config()
-	disc := generator.NewMetricDiscovery(cfg)
+	disc := s.Discovery()
 	cats, err := disc.Categorize(dsA, dsB)
 	if err != nil {
 		s.renderPartial(w, "compare-result.html", map[string]interface{}{"Error": err.Error()})
@@ -1234,8 +1303,7 @@ func (s *Server) handleMetricsSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cfg := s.Config()
-	disc := generator.NewMetricDiscovery(cfg)
+	disc := s.Discovery()
 	meta, _ := disc.FetchMetadata(dsName)
 
 	var lines []string
@@ -1263,6 +1331,38 @@ func (s *Server) handleMetricsSnippet(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleDiscover runs single-datasource discovery and returns the suggested
+// dashboards YAML. include/exclude are comma-separated glob lists and fall
+// back to the config's discovery patterns.
+func (s *Server) handleDiscover(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	dsName := q.Get("datasource")
+	if dsName == "" {
+		s.renderPartial(w, "snippet-result.html", map[string]interface{}{"Error": "datasource is required"})
+		return
+	}
+	cfg := s.Config()
+	discoveryCfg := cfg.GetDiscovery()
+	include, exclude := discoveryCfg.IncludePatterns, discoveryCfg.ExcludePatterns
+	if v := q.Get("include"); v != "" {
+		include = strings.Split(v, ",")
+	}
+	if v := q.Get("exclude"); v != "" {
+		exclude = strings.Split(v, ",")
+	}
+
+	disc := s.Discovery()
+	snippet, count, err := disc.DiscoverySnippet(dsName, include, exclude)
+	if err != nil {
+		s.renderPartial(w, "snippet-result.html", map[string]interface{}{"Error": err.Error()})
+		return
+	}
+	s.renderPartial(w, "snippet-result.html", map[string]interface{}{
+		"Snippet": snippet,
+		"Count":   count,
+	})
+}
+
 // handleComparisonSnippet generates a YAML snippet for comparison panels from selected shared metrics.
 // Accepts either datasource_a+datasource_b (2 DS) or datasources[] (N DS).
 func (s *Server) handleComparisonSnippet(w http.ResponseWriter, r *http.Request) {
@@ -1292,8 +1392,7 @@ func (s *Server) handleComparisonSnippet(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	cfg := s.Config()
-	disc := generator.NewMetricDiscovery(cfg)
+	disc := s.Discovery()
 	// Fetch metadata from first datasource for type info
 	meta, _ := disc.FetchMetadata(dsList[0])
 
@@ -1481,7 +1580,7 @@ func (s *Server) handleDatasourcesCompareLabels(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	disc := generator.NewMetricDiscovery(cfg)
+	disc := s.Discovery()
 
 	// Fetch labels for each datasource
 	allLabels := make(map[string]map[string]bool)
@@ -1558,14 +1657,7 @@ func (s *Server) handleDatasourcesCompareLabels(w http.ResponseWriter, r *http.R
 
 func (s *Server) handleDatasourcesCompareAll(w http.ResponseWriter, r *http.Request) {
 	cfg := s.Config()
-
-	var dsNames []string
-	for name, ds := range cfg.Datasources {
-		if ds.URL != "" {
-			dsNames = append(dsNames, name)
-		}
-	}
-	sort.Strings(dsNames)
+	dsNames := comparableDatasources(cfg)
 
 	if len(dsNames) < 2 {
 		s.renderPartial(w, "ds-compare-all.html", map[string]interface{}{
@@ -1574,7 +1666,7 @@ func (s *Server) handleDatasourcesCompareAll(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	disc := generator.NewMetricDiscovery(cfg)
+	disc := s.Discovery()
 	shared, exclusive, err := disc.CompareAll(dsNames)
 	if err != nil {
 		s.renderPartial(w, "ds-compare-all.html", map[string]interface{}{
@@ -1596,6 +1688,59 @@ func (s *Server) handleDatasourcesCompareAll(w http.ResponseWriter, r *http.Requ
 	})
 }
 
+// handleDatasourcesCompareExport downloads the compare-all result as CSV or
+// Markdown (?format=markdown) for audit docs.
+func (s *Server) handleDatasourcesCompareExport(w http.ResponseWriter, r *http.Request) {
+	cfg := s.Config()
+	dsNames := comparableDatasources(cfg)
+	if len(dsNames) < 2 {
+		http.Error(w, "need at least 2 datasources with URLs configured", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if err := generator.ValidComparisonFormat(format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	disc := s.Discovery()
+	shared, exclusive, err := disc.CompareAll(dsNames)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	// render first so a failure is an error response, not a truncated file
+	var buf bytes.Buffer
+	if err := generator.WriteComparison(&buf, format, dsNames, shared, exclusive); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := "metric-comparison.csv"
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	if format == "markdown" {
+		filename = "metric-comparison.md"
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Printf("writing comparison export: %v", err)
+	}
+}
+
+// comparableDatasources returns the sorted names of datasources with a URL.
+func comparableDatasources(cfg *config.Config) []string {
+	var dsNames []string
+	for name, ds := range cfg.Datasources {
+		if ds.URL != "" {
+			dsNames = append(dsNames, name)
+		}
+	}
+	sort.Strings(dsNames)
+	return dsNames
+}
+
 func (s *Server) handleDatasourceAdd(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", 405)
@@ -1674,8 +1819,7 @@ func (s *Server) handleDatasourceTargets(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	cfg := s.Config()
-	disc := generator.NewMetricDiscovery(cfg)
+	disc := s.Discovery()
 
 	targets, err := disc.FetchTargets(name)
 	if err != nil {
@@ -1718,8 +1862,7 @@ func (s *Server) handleDatasourceTargetMetrics(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	cfg := s.Config()
-	disc := generator.NewMetricDiscovery(cfg)
+	disc := s.Discovery()
 
 	// Get metrics for this job
 	allMetrics, err := disc.FetchMetrics(dsName)
@@ -28,16 +28,20 @@ func (s *Server) registerRoutes() {
 	s.mux.HandleFunc("/api/datasource/targets", s.handleDatasourceTargets)
 	s.mux.HandleFunc("/api/datasource/targets/metrics", s.handleDatasourceTargetMetrics)
 	s.mux.HandleFunc("/api/datasources/compare-all", s.handleDatasourcesCompareAll)
+	s.mux.HandleFunc("/api/datasources/compare-all/export", s.handleDatasourcesCompareExport)
 	s.mux.HandleFunc("/api/datasources/compare-labels", s.handleDatasourcesCompareLabels)
 	s.mux.HandleFunc("/api/datasources/variable-snippet", s.handleVariableSnippet)
+	s.mux.HandleFunc("/api/variable/values", s.handleVariableValues)
 	s.mux.HandleFunc("/api/metrics/browse", s.handleMetricsBrowse)
 	s.mux.HandleFunc("/api/metrics/jobs", s.handleMetricsJobs)
 	s.mux.HandleFunc("/api/metrics/compare", s.handleMetricsCompare)
 	s.mux.HandleFunc("/api/metrics/snippet", s.handleMetricsSnippet)
+	s.mux.HandleFunc("/api/discover", s.handleDiscover)
 	s.mux.HandleFunc("/api/metrics/comparison-snippet", s.handleComparisonSnippet)
 	s.mux.HandleFunc("/api/config/reload", s.handleConfigReload)
 	s.mux.HandleFunc("/api/config/save", s.handleConfigSave)
 	s.mux.HandleFunc("/api/preview", s.handlePreviewAPI)
+	s.mux.HandleFunc("/api/panel/render", s.handlePanelRender)
 	s.mux.HandleFunc("/api/palette/color/set", s.handlePaletteColorSet)
 	s.mux.HandleFunc("/api/palette/color/delete", s.handlePaletteColorDelete)
 	s.mux.HandleFunc("/api/palette/color/rename", s.handlePaletteColorRename)
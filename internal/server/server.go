@@ -8,8 +8,10 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/wcatz/dashboard-generator/internal/config"
+	"github.com/wcatz/dashboard-generator/internal/generator"
 )
 
 var funcMap = template.FuncMap{
@@ -18,29 +20,48 @@ var funcMap = template.FuncMap{
 
 // Server holds the HTTP server state and config.
 type Server struct {
-	cfg        *config.Config
-	cfgPath    string
-	grafanaURL string
-	mu         sync.RWMutex
-	webFS      fs.FS
-	partials   *template.Template
-	staticFS   http.FileSystem
-	mux        *http.ServeMux
-}
-
-// New creates a new Server with the given embedded filesystem, config path, and optional Grafana URL.
-func New(webFS fs.FS, cfgPath string, grafanaURL string) (*Server, error) {
+	cfg      *config.Config
+	cfgPath  string
+	grafana  generator.GrafanaOptions
+	mu       sync.RWMutex
+	webFS    fs.FS
+	partials *template.Template
+	staticFS http.FileSystem
+	mux      *http.ServeMux
+	// disc is shared by the discovery handlers so its response cache
+	// outlives a single request.
+	disc *generator.MetricDiscovery
+
+	// varValues caches live variable values for the variables page
+	varValuesMu sync.Mutex
+	varValues   map[string]varValuesEntry
+}
+
+// varValuesTTL is how long fetched variable values are reused before the
+// datasource is queried again.
+const varValuesTTL = time.Minute
+
+type varValuesEntry struct {
+	values  []generator.LabelValue
+	fetched time.Time
+}
+
+// New creates a new Server with the given embedded filesystem, config path,
+// and Grafana connection (its URL may be empty) used for push and render.
+func New(webFS fs.FS, cfgPath string, grafana generator.GrafanaOptions) (*Server, error) {
 	cfg, err := config.Load(cfgPath, nil)
 	if err != nil {
 		return nil, fmt.Errorf("loading config: %w", err)
 	}
 
 	s := &Server{
-		cfg:        cfg,
-		cfgPath:    cfgPath,
-		grafanaURL: grafanaURL,
-		webFS:      webFS,
-		mux:        http.NewServeMux(),
+		cfg:       cfg,
+		cfgPath:   cfgPath,
+		grafana:   grafana,
+		webFS:     webFS,
+		mux:       http.NewServeMux(),
+		varValues: make(map[string]varValuesEntry),
+		disc:      generator.NewMetricDiscovery(cfg),
 	}
 
 	if err := s.loadTemplates(); err != nil {
@@ -88,7 +109,13 @@ func (s *Server) ReloadConfig() error {
 	}
 	s.mu.Lock()
 	s.cfg = cfg
+	// handlers still holding the old instance must not serve stale data
+	s.disc.FlushCache()
+	s.disc = generator.NewMetricDiscovery(cfg)
 	s.mu.Unlock()
+	s.varValuesMu.Lock()
+	s.varValues = make(map[string]varValuesEntry)
+	s.varValuesMu.Unlock()
 	return nil
 }
 
@@ -99,9 +126,51 @@ func (s *Server) Config() *config.Config {
 	return s.cfg
 }
 
+// Discovery returns the shared metric discovery for the current config.
+func (s *Server) Discovery() *generator.MetricDiscovery {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.disc
+}
+
+// VariableValues returns live values for a query variable, served from a
+// short-lived cache unless refresh is set.
+func (s *Server) VariableValues(name string, refresh bool) ([]generator.LabelValue, time.Time, error) {
+	cfg := s.Config()
+	v, ok := cfg.Variables[name]
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("variable '%s' not found", name)
+	}
+	if v.Type != "query" {
+		return nil, time.Time{}, fmt.Errorf("variable '%s' is not a query variable", name)
+	}
+	if v.Datasource == "" {
+		return nil, time.Time{}, fmt.Errorf("variable '%s' has no datasource", name)
+	}
+	query := cfg.ResolveRef(v.Query)
+	key := v.Datasource + "|" + query
+
+	s.varValuesMu.Lock()
+	entry, cached := s.varValues[key]
+	s.varValuesMu.Unlock()
+	if cached && !refresh && time.Since(entry.fetched) < varValuesTTL {
+		return entry.values, entry.fetched, nil
+	}
+
+	values, err := generator.NewMetricDiscovery(cfg).FetchVariableValues(v.Datasource, query)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	entry = varValuesEntry{values: values, fetched: time.Now()}
+	s.varValuesMu.Lock()
+	s.varValues[key] = entry
+	s.varValuesMu.Unlock()
+	return values, entry.fetched, nil
+}
+
 // GrafanaURL returns the configured Grafana URL (empty if not set).
 func (s *Server) GrafanaURL() string {
-	return s.grafanaURL
+	return s.grafana.URL
 }
 
 // ConfigPath returns the absolute path to the config file.